@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// describe renders the checks of an assert step with placeholders
+// substituted.
+func (a *AssertStep) describe(vars map[string]string) string {
+	var checks []string
+	if a.File != "" {
+		file := replacePlaceholders(a.File, vars)
+		checks = append(checks, "exists "+file)
+		if a.NotEmpty {
+			checks = append(checks, "not empty")
+		}
+		if a.MinLines != nil {
+			checks = append(checks, fmt.Sprintf("at least %d lines", *a.MinLines))
+		}
+		if a.MaxLines != nil {
+			checks = append(checks, fmt.Sprintf("at most %d lines", *a.MaxLines))
+		}
+	}
+	if a.Command != "" {
+		checks = append(checks, fmt.Sprintf("%q exits %d", replacePlaceholders(a.Command, vars), a.ExitCode))
+	}
+	if a.Expr != "" {
+		checks = append(checks, a.Expr)
+	}
+	return strings.Join(checks, ", ")
+}
+
+func runAssertStep(a *AssertStep, shell string, vars map[string]string) error {
+	if a.File == "" && a.Command == "" && a.Expr == "" {
+		return fmt.Errorf("assert step needs file, command or expr")
+	}
+
+	if err := a.check(shell, vars); err != nil {
+		if a.Message != "" {
+			return fmt.Errorf("assertion failed: %s (%v)", replacePlaceholders(a.Message, vars), err)
+		}
+		return fmt.Errorf("assertion failed: %v", err)
+	}
+	return nil
+}
+
+func (a *AssertStep) check(shell string, vars map[string]string) error {
+	if a.File != "" {
+		file := replacePlaceholders(a.File, vars)
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("%s does not exist", file)
+		}
+		if a.NotEmpty && info.Size() == 0 {
+			return fmt.Errorf("%s is empty", file)
+		}
+		if a.MinLines != nil || a.MaxLines != nil {
+			n, err := countLines(file)
+			if err != nil {
+				return err
+			}
+			lines := int(n.(float64))
+			if a.MinLines != nil && lines < *a.MinLines {
+				return fmt.Errorf("%s has %d lines, expected at least %d", file, lines, *a.MinLines)
+			}
+			if a.MaxLines != nil && lines > *a.MaxLines {
+				return fmt.Errorf("%s has %d lines, expected at most %d", file, lines, *a.MaxLines)
+			}
+		}
+	}
+
+	if a.Command != "" {
+		command := replacePlaceholders(a.Command, vars)
+		code := 0
+		if err := shellCommand(shell, command).Run(); err != nil {
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				return err
+			}
+			code = exitErr.ExitCode()
+		}
+		if code != a.ExitCode {
+			return fmt.Errorf("%q exited %d, expected %d", command, code, a.ExitCode)
+		}
+	}
+
+	if a.Expr != "" {
+		ok, err := evalCondition(a.Expr, vars)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s is false", a.Expr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditLog records every command run to the file given with -audit-log, or
+// is nil.
+var auditLog *auditWriter
+
+// auditEntry is a line of the audit log: a command exactly as it was run,
+// so `rayder replay` can run it again without resolving anything. Values of
+// secret variables are replaced by their {{NAME}} placeholder and have to be
+// given again to replay the command.
+type auditEntry struct {
+	Time       time.Time         `json:"time"`
+	RunID      string            `json:"run_id"`
+	Module     string            `json:"module"`
+	Dir        string            `json:"dir"`
+	Argv       []string          `json:"argv"`
+	Env        map[string]string `json:"env,omitempty"` // set by the module, over rayder's environment
+	EnvClean   bool              `json:"env_clean,omitempty"`
+	Secrets    []string          `json:"secrets,omitempty"` // variables left as placeholders
+	ExitCode   int               `json:"exit_code"`
+	DurationMS int64             `json:"duration_ms"`
+	Status     string            `json:"status"`
+	StdoutFile string            `json:"stdout_file,omitempty"` // with -capture-dir
+	StderrFile string            `json:"stderr_file,omitempty"`
+
+	Stdin  *auditStdin `json:"stdin,omitempty"`
+	Stream string      `json:"stream,omitempty"` // stream stdout was written to
+
+	// Step is the kind of a built-in step, such as http or download, which
+	// is described in Argv but can't be replayed.
+	Step string `json:"step,omitempty"`
+}
+
+// auditStdin is what a command was fed on stdin, as resolved.
+type auditStdin struct {
+	Text   string `json:"text,omitempty"`   // inline text or variable value
+	File   string `json:"file,omitempty"`   // file read
+	Stream string `json:"stream,omitempty"` // stream read, written by an earlier command of the run
+}
+
+type auditWriter struct {
+	mu  sync.Mutex
+	out *os.File
+}
+
+// openAuditLog opens the audit log at path, appending to earlier runs.
+func openAuditLog(path string) (*auditWriter, error) {
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditWriter{out: out}, nil
+}
+
+// record appends an entry for the command argv of task, which ran for
+// duration and ended in state, with err as judged with the module's exit
+// code settings. stdin is the input given to the command, if any, and
+// capture holds the files its output was captured to.
+func (a *auditWriter) record(taskName string, task Task, argv []string, stdin *Stdin, vars map[string]string, state *os.ProcessState, duration time.Duration, err error, capture *commandCapture) {
+	if a == nil {
+		return
+	}
+	entry := auditEntry{
+		Time:       time.Now(),
+		RunID:      currentRunID(),
+		Module:     taskName,
+		Argv:       make([]string, len(argv)),
+		EnvClean:   task.EnvClean,
+		ExitCode:   -1,
+		DurationMS: duration.Milliseconds(),
+		Status:     statusCompleted,
+	}
+	entry.Dir, _ = os.Getwd()
+	if capture != nil {
+		entry.StdoutFile, entry.StderrFile = capture.stdoutPath, capture.stderrPath
+	}
+	if task.output != nil {
+		entry.Stream = task.Stream
+	}
+	if state != nil {
+		entry.ExitCode = state.ExitCode()
+	}
+	var skip *skipError
+	switch {
+	case errors.As(err, &skip):
+		entry.Status = statusSkipped
+	case err != nil:
+		entry.Status = statusErrored
+	}
+
+	secrets := make(map[string]bool)
+	for i, arg := range argv {
+		entry.Argv[i] = redactSecrets(arg, vars, secrets)
+	}
+	switch {
+	case stdin != nil && stdin.File != "":
+		entry.Stdin = &auditStdin{File: redactSecrets(replacePlaceholders(stdin.File, vars), vars, secrets)}
+	case stdin != nil && stdin.Var != "":
+		entry.Stdin = &auditStdin{Text: redactSecrets(vars[stdin.Var], vars, secrets)}
+	case stdin != nil:
+		entry.Stdin = &auditStdin{Text: redactSecrets(replacePlaceholders(stdin.Text, vars), vars, secrets)}
+	case task.input != nil:
+		entry.Stdin = &auditStdin{Stream: task.StdinStream}
+	}
+	if env := taskEnv(task, vars); len(env) > 0 {
+		entry.Env = make(map[string]string, len(env))
+		for key, value := range env {
+			entry.Env[key] = redactSecrets(value, vars, secrets)
+		}
+	}
+	for name := range secrets {
+		entry.Secrets = append(entry.Secrets, name)
+	}
+	sort.Strings(entry.Secrets)
+
+	a.write(entry)
+}
+
+// recordStep appends an entry for the built-in step cmd of task, so the log
+// accounts for everything the run did even though replay can't redo it.
+func (a *auditWriter) recordStep(taskName string, cmd Command, vars map[string]string, duration time.Duration, err error) {
+	if a == nil {
+		return
+	}
+	entry := auditEntry{
+		Time:       time.Now(),
+		RunID:      currentRunID(),
+		Module:     taskName,
+		ExitCode:   -1,
+		DurationMS: duration.Milliseconds(),
+		Status:     statusCompleted,
+	}
+	entry.Dir, _ = os.Getwd()
+	if err != nil {
+		entry.Status = statusErrored
+	}
+	secrets := make(map[string]bool)
+	description := redactSecrets(describeCommand(cmd, vars), vars, secrets)
+	entry.Step, _, _ = strings.Cut(description, " ")
+	entry.Argv = []string{description}
+	for name := range secrets {
+		entry.Secrets = append(entry.Secrets, name)
+	}
+	sort.Strings(entry.Secrets)
+	a.write(entry)
+}
+
+func (a *auditWriter) write(entry auditEntry) {
+	line, _ := json.Marshal(entry)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.out.Write(append(line, '\n'))
+}
+
+// redactSecrets replaces the values of secret variables in s with their
+// placeholders, longest first like maskSecrets, and adds the names replaced
+// to used.
+func redactSecrets(s string, vars map[string]string, used map[string]bool) string {
+	var names []string
+	for name, value := range vars {
+		if value != "" && isSecretVar(name) && strings.Contains(s, value) {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return len(vars[names[i]]) > len(vars[names[j]]) })
+
+	for _, name := range names {
+		if strings.Contains(s, vars[name]) {
+			s = strings.ReplaceAll(s, vars[name], "{{"+name+"}}")
+			used[name] = true
+		}
+	}
+	return s
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	runVarsOnce sync.Once
+	runVars     map[string]string
+)
+
+// withRunVars adds the automatic variables of this run (TIMESTAMP, DATE,
+// RANDOM, HOSTNAME, CWD) to vars. They are computed once, so every module
+// sees the same values, and never replace variables set by the user.
+func withRunVars(vars map[string]string) map[string]string {
+	runVarsOnce.Do(func() {
+		now := time.Now()
+		runVars = map[string]string{
+			"TIMESTAMP": now.Format("20060102-150405"),
+			"DATE":      now.Format("2006-01-02"),
+			"RANDOM":    fmt.Sprint(rand.New(rand.NewSource(now.UnixNano())).Intn(32768)),
+		}
+		if hostname, err := os.Hostname(); err == nil {
+			runVars["HOSTNAME"] = hostname
+		}
+		if cwd, err := os.Getwd(); err == nil {
+			runVars["CWD"] = cwd
+		}
+	})
+
+	if vars == nil {
+		vars = make(map[string]string)
+	}
+	for key, value := range runVars {
+		if _, exists := vars[key]; !exists {
+			vars[key] = value
+		}
+	}
+	return vars
+}
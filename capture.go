@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// captureDir is the directory -capture-dir writes the stdout and stderr of
+// every command to, as separate files, or "".
+var captureDir string
+
+// captureCounts numbers the commands of each module, so their files sort in
+// the order they started.
+var captureCounts struct {
+	sync.Mutex
+	n map[string]int
+}
+
+// commandCapture holds the files the output of one command is written to.
+type commandCapture struct {
+	stdout, stderr         *os.File
+	stdoutPath, stderrPath string
+}
+
+// openCapture creates the files for the next command of the module
+// taskName, CAPTURE_DIR/RUN_ID/MODULE/NNN.stdout and NNN.stderr. It returns
+// nil without -capture-dir.
+func openCapture(taskName string) (*commandCapture, error) {
+	if captureDir == "" {
+		return nil, nil
+	}
+	module := strings.NewReplacer("/", "_", `\`, "_", " ", "_", "(", "", ")", "").Replace(taskName)
+	dir := filepath.Join(captureDir, currentRunID(), module)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	captureCounts.Lock()
+	if captureCounts.n == nil {
+		captureCounts.n = make(map[string]int)
+	}
+	captureCounts.n[module]++
+	n := captureCounts.n[module]
+	captureCounts.Unlock()
+
+	c := &commandCapture{
+		stdoutPath: filepath.Join(dir, fmt.Sprintf("%03d.stdout", n)),
+		stderrPath: filepath.Join(dir, fmt.Sprintf("%03d.stderr", n)),
+	}
+	var err error
+	if c.stdout, err = os.Create(c.stdoutPath); err != nil {
+		return nil, err
+	}
+	if c.stderr, err = os.Create(c.stderrPath); err != nil {
+		c.stdout.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *commandCapture) close() {
+	if c == nil {
+		return
+	}
+	c.stdout.Close()
+	c.stderr.Close()
+}
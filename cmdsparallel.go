@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// CmdsParallel makes the commands of a module run at the same time instead
+// of one after the other: true runs them all at once, a number at most that
+// many at a time. Zero, the default, runs them in order.
+type CmdsParallel int
+
+// allCmds is the CmdsParallel of cmds_parallel: true.
+const allCmds CmdsParallel = -1
+
+func (p *CmdsParallel) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var on bool
+	if err := unmarshal(&on); err == nil {
+		*p = 0
+		if on {
+			*p = allCmds
+		}
+		return nil
+	}
+	var limit int
+	if err := unmarshal(&limit); err != nil || limit < 1 {
+		return fmt.Errorf("invalid cmds_parallel, expected true, false or a number of commands")
+	}
+	*p = CmdsParallel(limit)
+	return nil
+}
+
+// runParallelCommands executes cmds at the same time, as many at once as
+// the module's cmds_parallel allows. Unlike in order, a failing command
+// doesn't stop the others; the module fails once all have finished.
+func runParallelCommands(taskName string, task Task, cmds []Command, vars map[string]string, cyan, yellow, red func(a ...interface{}) string) error {
+	limit := int(task.CmdsParallel)
+	if limit < 0 || limit > len(cmds) {
+		limit = len(cmds)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failed int
+	var skipped error
+	sem := make(chan struct{}, limit)
+
+	for _, cmd := range cmds {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(cmd Command) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := runCommand(taskName, task, cmd, vars, cyan, yellow, red)
+
+			mu.Lock()
+			defer mu.Unlock()
+			var skipErr *skipError
+			switch {
+			case errors.As(err, &skipErr):
+				if skipped == nil {
+					skipped = err
+				}
+			case err != nil:
+				failed++
+			}
+		}(cmd)
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		return fmt.Errorf("Module '%s' %s%s (%d of %d commands failed)", taskName, red(word("errored")), mark("failure"), failed, len(cmds))
+	}
+	return skipped
+}
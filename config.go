@@ -0,0 +1,465 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Include pulls the vars and modules of another workflow file into the
+// current one. Included module names (and their required lists) are
+// prefixed with Prefix, if set, to keep them from clashing.
+type Include struct {
+	Path   string `yaml:"path"`
+	Prefix string `yaml:"prefix"`
+}
+
+func (inc *Include) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var path string
+	if err := unmarshal(&path); err == nil {
+		inc.Path = path
+		return nil
+	}
+
+	type include Include
+	return unmarshal((*include)(inc))
+}
+
+// workflowList collects the -w flag, which can be repeated or given a comma
+// separated list of workflows.
+type workflowList []string
+
+func (w *workflowList) String() string {
+	return strings.Join(*w, ",")
+}
+
+func (w *workflowList) Set(value string) error {
+	*w = append(*w, splitList(value)...)
+	return nil
+}
+
+// loadWorkflows loads every workflow given with -w and merges them into one
+// configuration.
+func loadWorkflows(refs []string, refresh bool, verify verifyOptions) (Config, error) {
+	if verify.sha256 != "" && len(refs) > 1 {
+		return Config{}, fmt.Errorf("-sha256 can only be used with a single workflow")
+	}
+
+	stdin := 0
+	for _, ref := range refs {
+		if ref == "-" {
+			stdin++
+		}
+	}
+	if stdin > 1 {
+		return Config{}, fmt.Errorf("-w - can only be given once")
+	}
+
+	var configs []Config
+	for _, ref := range refs {
+		config, err := loadWorkflow(ref, refresh, verify)
+		if err != nil {
+			return Config{}, err
+		}
+		// Unpinned remote children would run unverified; catch those known
+		// before the run starts.
+		for _, task := range config.Tasks {
+			if task.Workflow != "" && !strings.Contains(task.Workflow, "{{") {
+				if _, err := subWorkflowVerify(task, task.Workflow, verify); err != nil {
+					return Config{}, err
+				}
+			}
+		}
+		configs = append(configs, config)
+	}
+
+	if len(configs) == 1 {
+		return configs[0], nil
+	}
+	return mergeConfigs(configs)
+}
+
+// loadWorkflow resolves ref, which may name an installed, local or remote
+// workflow, verifies it if requested and loads it.
+func loadWorkflow(ref string, refresh bool, verify verifyOptions) (Config, error) {
+	path := ref
+	if ref == "-" {
+		// Read from stdin by loadConfigFile; there is no file to verify.
+		if verify.enabled() {
+			return Config{}, fmt.Errorf("a workflow read from stdin cannot be verified")
+		}
+		return loadConfig(path)
+	}
+	if installed, ok := installedWorkflowPath(ref); ok {
+		path = installed
+	} else if isRemoteWorkflow(ref) {
+		fetched, err := fetchWorkflow(ref, refresh)
+		if err != nil {
+			return Config{}, err
+		}
+		path = fetched
+	}
+
+	if verify.enabled() {
+		if err := verifyWorkflow(ref, path, verify); err != nil {
+			return Config{}, err
+		}
+	}
+
+	config, err := loadConfig(path)
+	if err != nil {
+		return config, err
+	}
+	return config, applyOverrides(&config, path)
+}
+
+// mergeConfigs combines several workflows given on the command line. Modules
+// run in the order the workflows were given and may require modules of
+// earlier workflows; for variables, later workflows override earlier ones.
+func mergeConfigs(configs []Config) (Config, error) {
+	merged := Config{
+		Vars:     make(map[string]string),
+		Profiles: make(map[string]map[string]string),
+		Groups:   make(map[string]int),
+	}
+
+	names := make(map[string]bool)
+	var usages []string
+	for _, config := range configs {
+		for key, value := range config.Vars {
+			merged.Vars[key] = value
+		}
+		for name, spec := range config.VarSpecs {
+			if merged.VarSpecs == nil {
+				merged.VarSpecs = make(map[string]VarSpec)
+			}
+			merged.VarSpecs[name] = spec
+		}
+		for name, vars := range config.Profiles {
+			if merged.Profiles[name] == nil {
+				merged.Profiles[name] = make(map[string]string)
+			}
+			for key, value := range vars {
+				merged.Profiles[name][key] = value
+			}
+		}
+		for name, limit := range config.Groups {
+			merged.Groups[name] = limit
+		}
+
+		if merged.Shell == "" {
+			merged.Shell = config.Shell
+		}
+		if config.Usage != "" {
+			usages = append(usages, config.Usage)
+		}
+		merged.Secrets = append(merged.Secrets, config.Secrets...)
+		merged.SecretsFiles = append(merged.SecretsFiles, config.SecretsFiles...)
+		merged.NotifyOnDiff = merged.NotifyOnDiff || config.NotifyOnDiff
+		merged.Sinks = append(merged.Sinks, config.Sinks...)
+		if merged.Storage == nil {
+			merged.Storage = config.Storage
+		}
+		if merged.MinFreeDisk == nil {
+			merged.MinFreeDisk = config.MinFreeDisk
+		}
+		if merged.Preflight == nil {
+			merged.Preflight = config.Preflight
+		}
+		if merged.MaxFailures == nil {
+			merged.MaxFailures = config.MaxFailures
+		}
+		merged.Tools = append(merged.Tools, config.Tools...)
+		for name, cmd := range config.Install {
+			if merged.Install == nil {
+				merged.Install = make(map[string]string)
+			}
+			merged.Install[name] = cmd
+		}
+		merged.Before = append(merged.Before, config.Before...)
+		merged.After = append(merged.After, config.After...)
+
+		for _, task := range config.Tasks {
+			if names[task.Name] {
+				return merged, fmt.Errorf("module %q is defined by more than one workflow", task.Name)
+			}
+			names[task.Name] = true
+			merged.Tasks = append(merged.Tasks, task)
+		}
+	}
+	merged.Usage = strings.Join(usages, "\n")
+
+	return merged, nil
+}
+
+// loadConfig reads the workflow at path and resolves its includes.
+func loadConfig(path string) (Config, error) {
+	return loadConfigFile(path, map[string]bool{})
+}
+
+// allowUnknownFields turns off strict decoding, for workflows written for
+// newer versions of rayder. It is set by -allow-unknown.
+var allowUnknownFields bool
+
+// decodeWorkflow decodes a workflow file, rejecting unknown (usually
+// misspelled) fields unless allowUnknownFields is set. Errors name the line
+// of every offending field. JSON is a subset of YAML and decoded the same way;
+// TOML is converted first.
+func decodeWorkflow(path string, content []byte, config *Config) error {
+	content, err := workflowYAML(path, content)
+	if err != nil {
+		return err
+	}
+
+	if allowUnknownFields {
+		return yaml.Unmarshal(content, config)
+	}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		msg := strings.ReplaceAll(err.Error(), "in type main.", "in ")
+		if !strings.Contains(msg, " not found in ") {
+			return errors.New(msg)
+		}
+		return fmt.Errorf("%s (use -allow-unknown to ignore unknown fields)", msg)
+	}
+	return nil
+}
+
+// workflowYAML returns the content of a workflow file as YAML, converting
+// TOML files.
+func workflowYAML(path string, content []byte) ([]byte, error) {
+	if !strings.EqualFold(filepath.Ext(path), ".toml") {
+		return content, nil
+	}
+	doc, err := parseTOML(string(content))
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+func loadConfigFile(path string, seen map[string]bool) (Config, error) {
+	var config Config
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return config, err
+	}
+	if seen[abs] {
+		return config, fmt.Errorf("include cycle detected at %s", path)
+	}
+	seen[abs] = true
+	defer delete(seen, abs)
+
+	var content []byte
+	if path == "-" {
+		content, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		content, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return config, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if err := decodeWorkflow(path, content, &config); err != nil {
+		return config, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	var tasks []Task
+	for _, inc := range config.Includes {
+		incPath := inc.Path
+		if !filepath.IsAbs(incPath) {
+			incPath = filepath.Join(filepath.Dir(path), incPath)
+		}
+
+		included, err := loadConfigFile(incPath, seen)
+		if err != nil {
+			return config, fmt.Errorf("including %s: %w", inc.Path, err)
+		}
+
+		// Variables of the including file win over included defaults.
+		if config.Vars == nil {
+			config.Vars = make(map[string]string)
+		}
+		for key, value := range included.Vars {
+			if _, exists := config.Vars[key]; !exists {
+				config.Vars[key] = value
+			}
+		}
+		for name, spec := range included.VarSpecs {
+			if config.VarSpecs == nil {
+				config.VarSpecs = make(map[string]VarSpec)
+			}
+			if _, exists := config.VarSpecs[name]; !exists {
+				config.VarSpecs[name] = spec
+			}
+		}
+
+		for name, vars := range included.Profiles {
+			if config.Profiles == nil {
+				config.Profiles = make(map[string]map[string]string)
+			}
+			if config.Profiles[name] == nil {
+				config.Profiles[name] = make(map[string]string)
+			}
+			for key, value := range vars {
+				if _, exists := config.Profiles[name][key]; !exists {
+					config.Profiles[name][key] = value
+				}
+			}
+		}
+
+		// Templates are shared with the including file, unprefixed.
+		for name, tmpl := range included.Templates {
+			if config.Templates == nil {
+				config.Templates = make(map[string]Template)
+			}
+			if _, exists := config.Templates[name]; !exists {
+				config.Templates[name] = tmpl
+			}
+		}
+
+		config.Tools = append(config.Tools, included.Tools...)
+		for name, cmd := range included.Install {
+			if config.Install == nil {
+				config.Install = make(map[string]string)
+			}
+			if _, exists := config.Install[name]; !exists {
+				config.Install[name] = cmd
+			}
+		}
+		tasks = append(tasks, prefixTasks(included.Tasks, inc.Prefix)...)
+	}
+
+	if err := expandTemplates(config.Tasks, config.Templates); err != nil {
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// The workflow shell, pacing and allowed window are the defaults for the
+	// modules declared in the same file, so included libraries keep the
+	// settings they were written for.
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return config, err
+	}
+	for i, file := range config.SecretsFiles {
+		if !filepath.IsAbs(file) {
+			config.SecretsFiles[i] = filepath.Join(dir, file)
+		}
+	}
+	for i := range config.Tasks {
+		config.Tasks[i].dir = dir
+		if config.Tasks[i].Shell == "" {
+			config.Tasks[i].Shell = config.Shell
+		}
+		config.Tasks[i].Pacing = config.Tasks[i].Pacing.withDefaults(config.Pacing)
+		if err := config.Tasks[i].Pacing.check(); err != nil {
+			return config, fmt.Errorf("%s: module %s: %w", path, config.Tasks[i].Name, err)
+		}
+		if config.Tasks[i].Window == nil {
+			config.Tasks[i].Window = config.Window
+		}
+		if config.Tasks[i].Heartbeat == "" {
+			config.Tasks[i].Heartbeat = config.Heartbeat
+		}
+		if err := checkHeartbeat(config.Tasks[i].Heartbeat); err != nil {
+			return config, fmt.Errorf("%s: module %s: %w", path, config.Tasks[i].Name, err)
+		}
+		if config.Tasks[i].Proxy == nil {
+			config.Tasks[i].Proxy = config.Proxy
+		}
+
+		// Sub-workflows are relative to the workflow referencing them.
+		sub := config.Tasks[i].Workflow
+		if sub != "" && !filepath.IsAbs(sub) && !isRemoteWorkflow(sub) && !strings.Contains(sub, "{{") {
+			if _, ok := installedWorkflowPath(sub); !ok {
+				config.Tasks[i].Workflow = filepath.Join(filepath.Dir(path), sub)
+			}
+		}
+	}
+
+	// Included modules run first so the including workflow can require them.
+	config.Tasks = append(tasks, config.Tasks...)
+	config.Includes = nil
+
+	return config, nil
+}
+
+// prefixTasks namespaces the names of tasks, along with any required entries
+// and streams that point at tasks from the same file.
+func prefixTasks(tasks []Task, prefix string) []Task {
+	if prefix == "" {
+		return tasks
+	}
+
+	names := make(map[string]bool, len(tasks))
+	streams := make(map[string]bool)
+	for _, task := range tasks {
+		names[task.Name] = true
+		if task.Stream != "" {
+			streams[task.Stream] = true
+		}
+	}
+
+	prefixed := make([]Task, len(tasks))
+	for i, task := range tasks {
+		task.Name = prefix + ":" + task.Name
+
+		required := make([]Requirement, len(task.Required))
+		for j, req := range task.Required {
+			if names[req.Module] {
+				req.Module = prefix + ":" + req.Module
+			}
+			required[j] = req
+		}
+		task.Required = required
+
+		optional := make([]string, len(task.OptionalReq))
+		for j, req := range task.OptionalReq {
+			if names[req] {
+				req = prefix + ":" + req
+			}
+			optional[j] = req
+		}
+		task.OptionalReq = optional
+
+		if task.Stream != "" {
+			task.Stream = prefix + ":" + task.Stream
+		}
+		if streams[task.StdinStream] {
+			task.StdinStream = prefix + ":" + task.StdinStream
+		}
+
+		prefixed[i] = task
+	}
+	return prefixed
+}
+
+// applyProfile overrides the workflow variables with those of the named
+// profile. Command line assignments are applied later and still win.
+func applyProfile(config *Config, name string) error {
+	profile, ok := config.Profiles[name]
+	if !ok {
+		var names []string
+		for n := range config.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return fmt.Errorf("unknown profile %q, the workflow defines no profiles", name)
+		}
+		return fmt.Errorf("unknown profile %q, available profiles: %s", name, strings.Join(names, ", "))
+	}
+
+	if config.Vars == nil {
+		config.Vars = make(map[string]string)
+	}
+	for key, value := range profile {
+		config.Vars[key] = value
+	}
+	return nil
+}
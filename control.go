@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// errCancelled is returned for the work a cancelled run no longer starts.
+var errCancelled = errors.New("run cancelled")
+
+// killGrace is how long commands get to exit after a cancel before they are
+// killed.
+const killGrace = 5 * time.Second
+
+// failFast cancels the run as soon as a module fails; set with -fail-fast.
+// Without it, the other modules keep running and only the modules requiring
+// the failed one are affected.
+var failFast bool
+
+// failureLimit is how many modules and instances may fail before the run is
+// cancelled: 0 with -fail-fast, else the workflow's max_failures, and -1 for
+// no limit.
+var failureLimit = -1
+
+// runController lets a run be paused and cancelled from outside: by signals
+// in the CLI, which rayder serve sends to the runs it started.
+//
+// Pausing stops new modules from starting; running ones finish. Cancelling
+// terminates the running commands and skips every module that hasn't started
+// yet, except always_run ones. after hooks and after_all still run, so the
+// run can clean up.
+type runController struct {
+	mu        sync.Mutex
+	resumed   *sync.Cond
+	paused    bool
+	held      bool // by rayder itself, as when the disk is full
+	cancelled bool
+	failed    bool // cancelled by failureLimit rather than from outside
+	failures  int
+	running   map[*exec.Cmd]bool
+	done      chan struct{} // closed on cancel
+}
+
+var control = newRunController()
+
+func newRunController() *runController {
+	c := &runController{running: make(map[*exec.Cmd]bool), done: make(chan struct{})}
+	c.resumed = sync.NewCond(&c.mu)
+	return c
+}
+
+// togglePause pauses or resumes the run and reports whether it is now
+// paused.
+func (c *runController) togglePause() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = !c.paused
+	if !c.paused && !c.held {
+		c.resumed.Broadcast()
+	}
+	return c.paused
+}
+
+// hold pauses the run, or resumes it unless it was also paused by the
+// user. Unlike togglePause it is idempotent.
+func (c *runController) hold(held bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.held = held
+	if !held {
+		c.resumed.Broadcast()
+	}
+}
+
+// waitWhilePaused blocks until the run is resumed or cancelled.
+func (c *runController) waitWhilePaused() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for (c.paused || c.held) && !c.cancelled {
+		c.resumed.Wait()
+	}
+}
+
+func (c *runController) isPaused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused || c.held
+}
+
+func (c *runController) isCancelled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cancelled
+}
+
+// sleep waits for d and reports whether it did, or false as soon as the run
+// is cancelled.
+func (c *runController) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// failedFast reports whether the run was cancelled because too many modules
+// failed, so it ends as failed rather than cancelled.
+func (c *runController) failedFast() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failed
+}
+
+// countFailure counts the failure of module, a module or an instance of
+// one, and cancels the run once more have failed than failureLimit allows:
+// running modules are terminated and pending ones skipped, while always_run
+// modules and cleanup hooks still run, as with any cancel.
+func countFailure(module string, cyan, yellow, red func(a ...interface{}) string) {
+	control.mu.Lock()
+	control.failures++
+	failures := control.failures
+	exceeded := failureLimit >= 0 && failures > failureLimit && !control.cancelled
+	control.failed = control.failed || exceeded
+	control.mu.Unlock()
+	if !exceeded {
+		return
+	}
+	reason := fmt.Sprintf("%d failures, max_failures is %d", failures, failureLimit)
+	if failFast {
+		reason = "-fail-fast"
+	}
+	fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s' failed, cancelling the run (%s): terminating running modules, then running cleanup hooks\n", yellow(currentTime()), red("INFO"), cyan(module), reason)
+	control.cancel()
+}
+
+// cancel terminates the commands running now, and kills those still running
+// after killGrace. Commands started afterwards, such as hooks cleaning up,
+// run normally.
+func (c *runController) cancel() {
+	c.mu.Lock()
+	if c.cancelled {
+		c.mu.Unlock()
+		return
+	}
+	c.cancelled = true
+	close(c.done)
+	c.resumed.Broadcast()
+	var cmds []*exec.Cmd
+	for cmd := range c.running {
+		cmds = append(cmds, cmd)
+	}
+	c.mu.Unlock()
+
+	for _, cmd := range cmds {
+		terminateTree(cmd.Process.Pid, false)
+	}
+	go func() {
+		time.Sleep(killGrace)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, cmd := range cmds {
+			if c.running[cmd] {
+				terminateTree(cmd.Process.Pid, true)
+			}
+		}
+	}()
+}
+
+// run runs cmd in its own process group, keeping track of it so a cancel
+// can terminate it with everything it spawned.
+func (c *runController) run(cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
+	// Starting under the lock means a concurrent cancel either sees the
+	// command or happens before it starts.
+	c.mu.Lock()
+	if err := cmd.Start(); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.running[cmd] = true
+	c.mu.Unlock()
+
+	err := cmd.Wait()
+
+	c.mu.Lock()
+	delete(c.running, cmd)
+	c.mu.Unlock()
+	return err
+}
+
+// pid returns the process ID of cmd while run runs it, or 0.
+func (c *runController) pid(cmd *exec.Cmd) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running[cmd] {
+		return 0
+	}
+	return cmd.Process.Pid
+}
+
+// kill kills the commands still running right away.
+func (c *runController) kill() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for cmd := range c.running {
+		terminateTree(cmd.Process.Pid, true)
+	}
+}
+
+func handleControlSignal(cancel bool, yellow, red func(a ...interface{}) string) {
+	if cancel {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Cancelling the run: terminating running modules, then running cleanup hooks\n", yellow(currentTime()), red("INFO"))
+		control.cancel()
+		return
+	}
+	if control.togglePause() {
+		logLifecycle("[%s] [%s] Run %s, no new modules start until it is resumed\n", yellow(currentTime()), yellow("INFO"), yellow("paused"))
+	} else {
+		logLifecycle("[%s] [%s] Run %s\n", yellow(currentTime()), yellow("INFO"), yellow("resumed"))
+	}
+}
+
+// watchInterrupts cancels the run on the first SIGINT or SIGTERM, like
+// SIGUSR2 does, so running modules are terminated and the run is still
+// recorded. A second one kills what is still running and exits at once.
+func watchInterrupts(yellow, red func(a ...interface{}) string) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		handleControlSignal(true, yellow, red)
+		<-signals
+		control.kill()
+		exit(exitInterrupted)
+	}()
+}
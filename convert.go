@@ -0,0 +1,363 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// converters translate the pipelines of other tools into rayder workflows,
+// by the name given to rayder convert -from.
+var converters = map[string]func(data []byte, job string) (string, []string, error){
+	"gha":      convertGHA,
+	"make":     convertMake,
+	"taskfile": convertTaskfile,
+}
+
+func runConvertCommand(args []string) int {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	from := fs.String("from", "", "Format of the file to convert: gha, make or taskfile")
+	output := fs.String("o", "", "Path of the workflow file to create (default: print it)")
+	job := fs.String("job", "", "Only convert this job, target or task")
+	force := fs.Bool("force", false, "Overwrite an existing file")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: rayder convert -from gha|make|taskfile [-o workflow.yaml] [-job name] FILE\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	convert, ok := converters[*from]
+	if !ok || fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+	content, warnings, err := convert(data, *job)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: converting %s: %v\n", fs.Arg(0), err)
+		return exitInvalid
+	}
+
+	// Never write a file rayder itself would refuse to load.
+	var check Config
+	if err := yaml.UnmarshalStrict([]byte(content), &check); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: converted workflow is invalid: %v\n", err)
+		return exitFailed
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	if *output == "" {
+		fmt.Print(content)
+		return 0
+	}
+	if _, err := os.Stat(*output); err == nil && !*force {
+		fmt.Fprintf(os.Stderr, "Error: %s already exists, use -force to overwrite it\n", *output)
+		return exitFailed
+	}
+	if err := os.WriteFile(*output, []byte(content), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+	fmt.Fprintf(os.Stderr, "Created %s, run it with: rayder -w %s\n", *output, *output)
+	return 0
+}
+
+type ghaWorkflow struct {
+	Name string            `yaml:"name"`
+	Env  map[string]string `yaml:"env"`
+	Jobs yaml.MapSlice     `yaml:"jobs"`
+}
+
+type ghaJob struct {
+	Name     string            `yaml:"name"`
+	Needs    stringList        `yaml:"needs"`
+	If       string            `yaml:"if"`
+	Env      map[string]string `yaml:"env"`
+	Defaults struct {
+		Run struct {
+			Shell            string `yaml:"shell"`
+			WorkingDirectory string `yaml:"working-directory"`
+		} `yaml:"run"`
+	} `yaml:"defaults"`
+	Strategy struct {
+		Matrix map[string]interface{} `yaml:"matrix"`
+	} `yaml:"strategy"`
+	Steps []ghaStep `yaml:"steps"`
+}
+
+type ghaStep struct {
+	Name             string            `yaml:"name"`
+	Run              string            `yaml:"run"`
+	Uses             string            `yaml:"uses"`
+	If               string            `yaml:"if"`
+	Env              map[string]string `yaml:"env"`
+	Shell            string            `yaml:"shell"`
+	WorkingDirectory string            `yaml:"working-directory"`
+}
+
+// stringList is a YAML value that is either a single string or a list of
+// strings, like the needs of a job.
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*l = stringList{single}
+		return nil
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// convertedModule and convertedWorkflow fix the order of the fields of a
+// converted workflow.
+type convertedModule struct {
+	Name        string              `yaml:"name"`
+	Description string              `yaml:"description,omitempty"`
+	Required    []string            `yaml:"required,omitempty"`
+	Shell       string              `yaml:"shell,omitempty"`
+	Env         yaml.MapSlice       `yaml:"env,omitempty"`
+	Matrix      map[string][]string `yaml:"matrix,omitempty"`
+	Cmds        []string            `yaml:"cmds"`
+}
+
+type convertedWorkflow struct {
+	Vars    yaml.MapSlice     `yaml:"vars,omitempty"`
+	Usage   string            `yaml:"usage,omitempty"`
+	Modules []convertedModule `yaml:"modules"`
+}
+
+// ghaExpression matches a ${{ }} expression of a GitHub Actions workflow.
+var ghaExpression = regexp.MustCompile(`\$\{\{\s*(.*?)\s*\}\}`)
+
+// ghaVariable matches the expressions convertGHA knows how to translate: a
+// context and the name of one of its values.
+var ghaVariable = regexp.MustCompile(`^(env|secrets|inputs|vars|matrix|github\.event\.inputs)\.([A-Za-z_][A-Za-z0-9_-]*)$`)
+
+// convertGHA translates the jobs of a GitHub Actions workflow into modules:
+// run steps become commands, env becomes module env and needs becomes
+// required. Secrets and inputs become workflow variables, matrix values
+// placeholders. What has no rayder equivalent, such as actions or
+// conditions, is dropped with a warning.
+func convertGHA(data []byte, only string) (string, []string, error) {
+	var workflow ghaWorkflow
+	if err := yaml.Unmarshal(data, &workflow); err != nil {
+		return "", nil, err
+	}
+	if len(workflow.Jobs) == 0 {
+		return "", nil, fmt.Errorf("no jobs found, is it a GitHub Actions workflow?")
+	}
+
+	var warnings []string
+	warn := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+	vars := make(map[string]string)
+
+	// translate rewrites the expressions of s: env values become shell
+	// variables, the module sets them, and the rest rayder placeholders.
+	translate := func(where, s string) string {
+		return ghaExpression.ReplaceAllStringFunc(s, func(expr string) string {
+			inner := ghaExpression.FindStringSubmatch(expr)[1]
+			m := ghaVariable.FindStringSubmatch(inner)
+			if m == nil {
+				warn("%s: expression %s left as is", where, expr)
+				return expr
+			}
+			switch m[1] {
+			case "env":
+				return "${" + m[2] + "}"
+			case "matrix":
+				return "{{" + m[2] + "}}"
+			}
+			vars[m[2]] = ""
+			return "{{" + m[2] + "}}"
+		})
+	}
+
+	out := convertedWorkflow{Usage: workflow.Name}
+	groups := make(map[string][]string)
+	names := make(map[string]bool)
+	for _, item := range workflow.Jobs {
+		id, _ := item.Key.(string)
+		names[id] = true
+	}
+	for _, item := range workflow.Jobs {
+		id, _ := item.Key.(string)
+		if only != "" && id != only {
+			continue
+		}
+		raw, err := yaml.Marshal(item.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		var job ghaJob
+		if err := yaml.Unmarshal(raw, &job); err != nil {
+			return "", nil, fmt.Errorf("job %s: %v", id, err)
+		}
+
+		module := convertedModule{Name: id, Description: job.Name, Shell: job.Defaults.Run.Shell}
+		if job.If != "" {
+			warn("job %s: condition %q dropped", id, job.If)
+		}
+		for _, need := range job.Needs {
+			if only != "" || !names[need] {
+				warn("job %s: needs %s, which is not converted", id, need)
+				continue
+			}
+			module.Required = append(module.Required, need)
+		}
+
+		env := make(map[string]string)
+		addEnv := func(where string, values map[string]string) {
+			for key, value := range values {
+				value = translate(where, value)
+				if previous, ok := env[key]; ok && previous != value {
+					warn("%s: env %s=%q replaces %q for the whole module", where, key, value, previous)
+				}
+				env[key] = value
+			}
+		}
+		addEnv("workflow", workflow.Env)
+		addEnv("job "+id, job.Env)
+
+		for key, values := range job.Strategy.Matrix {
+			list, ok := values.([]interface{})
+			if !ok {
+				warn("job %s: matrix %s dropped, only lists of values are supported", id, key)
+				continue
+			}
+			for _, value := range list {
+				if module.Matrix == nil {
+					module.Matrix = make(map[string][]string)
+				}
+				module.Matrix[key] = append(module.Matrix[key], fmt.Sprint(value))
+			}
+		}
+
+		for i, step := range job.Steps {
+			where := fmt.Sprintf("job %s step %d", id, i+1)
+			if step.Name != "" {
+				where = fmt.Sprintf("job %s step %q", id, step.Name)
+			}
+			if step.Uses != "" {
+				warn("%s: action %s dropped", where, step.Uses)
+				continue
+			}
+			if step.If != "" {
+				warn("%s: condition %q dropped", where, step.If)
+			}
+			if step.Shell != "" && step.Shell != module.Shell {
+				warn("%s: shell %s dropped", where, step.Shell)
+			}
+			addEnv(where, step.Env)
+			cmd := translate(where, strings.TrimRight(step.Run, "\n"))
+			dir := step.WorkingDirectory
+			if dir == "" {
+				dir = job.Defaults.Run.WorkingDirectory
+			}
+			if dir != "" {
+				cmd = "cd " + shellQuote(translate(where, dir)) + " && " + cmd
+			}
+			module.Cmds = append(module.Cmds, cmd)
+		}
+		if len(module.Cmds) == 0 {
+			groups[id] = module.Required
+			continue
+		}
+
+		module.Env = convertedEnv(env)
+		out.Modules = append(out.Modules, module)
+	}
+	if only != "" && len(out.Modules) == 0 && !names[only] {
+		return "", nil, fmt.Errorf("no job named %s", only)
+	}
+	if len(out.Modules) == 0 {
+		return "", nil, fmt.Errorf("no job has run steps")
+	}
+	resolveRequired(out.Modules, groups)
+
+	out.Vars = convertedVars(vars)
+
+	content, err := yaml.Marshal(out)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(content), warnings, nil
+}
+
+// resolveRequired replaces what modules require on the jobs or tasks that
+// had no commands, in groups, by what those required in turn.
+func resolveRequired(modules []convertedModule, groups map[string][]string) {
+	var resolve func(names []string, seen map[string]bool) []string
+	resolve = func(names []string, seen map[string]bool) []string {
+		var required []string
+		for _, name := range names {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if group, ok := groups[name]; ok {
+				required = append(required, resolve(group, seen)...)
+			} else {
+				required = append(required, name)
+			}
+		}
+		return required
+	}
+	for i := range modules {
+		modules[i].Required = resolve(modules[i].Required, map[string]bool{})
+	}
+}
+
+// convertedVars renders the variables of a converted workflow in name
+// order, the ones without a value as required.
+func convertedVars(values map[string]string) yaml.MapSlice {
+	var vars yaml.MapSlice
+	for _, key := range sortedKeys(values) {
+		if values[key] == "" {
+			vars = append(vars, yaml.MapItem{Key: key, Value: yaml.MapSlice{{Key: "required", Value: true}}})
+			continue
+		}
+		vars = append(vars, yaml.MapItem{Key: key, Value: values[key]})
+	}
+	return vars
+}
+
+// convertedEnv renders env entries in name order.
+func convertedEnv(values map[string]string) yaml.MapSlice {
+	var env yaml.MapSlice
+	for _, key := range sortedKeys(values) {
+		env = append(env, yaml.MapItem{Key: key, Value: values[key]})
+	}
+	return env
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// shellQuote renders s as a single-quoted shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
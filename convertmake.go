@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	makeAssignment = regexp.MustCompile(`^(?:export\s+|override\s+)?([A-Za-z_][A-Za-z0-9_]*)\s*(\?=|::=|:=|\+=|=)\s*(.*)$`)
+	makeRule       = regexp.MustCompile(`^([^:=#]+?)\s*::?\s*([^=]*)$`)
+	makeReference  = regexp.MustCompile(`\$(?:\(([^()]*)\)|\{([^{}]*)\}|([@<^*?]))`)
+)
+
+// makeDirectives are the lines of a Makefile convertMake can't follow.
+var makeDirectives = []string{"include", "-include", "sinclude", "ifeq", "ifneq", "ifdef", "ifndef", "else", "endif", "define", "endef", "vpath"}
+
+type makeTarget struct {
+	name    string
+	prereqs []string
+	recipe  []string
+}
+
+// convertMake translates the targets of a Makefile into modules: recipe
+// lines become commands and prerequisites that are targets become
+// required. Variables become workflow variables; as in make, an empty one
+// is expected on the command line.
+func convertMake(data []byte, only string) (string, []string, error) {
+	var warnings []string
+	warn := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	vars := make(map[string]string)
+	var varOrder []string
+	targets := make(map[string]*makeTarget)
+	var order []string
+	var current []*makeTarget
+
+	lines := strings.Split(string(data), "\n")
+	for n := 0; n < len(lines); n++ {
+		where := fmt.Sprintf("line %d", n+1)
+		line := lines[n]
+		for strings.HasSuffix(line, "\\") && n+1 < len(lines) {
+			n++
+			line = strings.TrimSuffix(line, "\\") + " " + strings.TrimLeft(lines[n], " \t")
+		}
+		if strings.HasPrefix(line, "\t") {
+			if len(current) == 0 {
+				continue
+			}
+			recipe := strings.TrimSpace(line)
+			if recipe == "" || strings.HasPrefix(recipe, "#") {
+				continue
+			}
+			for _, target := range current {
+				target.recipe = append(target.recipe, recipe)
+			}
+			continue
+		}
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if word := strings.Fields(line)[0]; containsString(makeDirectives, word) {
+			warn("%s: %s is not supported and was ignored", where, word)
+			current = nil
+			continue
+		}
+		if m := makeAssignment.FindStringSubmatch(line); m != nil {
+			name, op, value := m[1], m[2], strings.TrimSpace(m[3])
+			if _, ok := vars[name]; !ok {
+				varOrder = append(varOrder, name)
+			} else if op == "?=" {
+				continue
+			}
+			if op == "+=" && vars[name] != "" {
+				value = vars[name] + " " + value
+			}
+			vars[name] = value
+			current = nil
+			continue
+		}
+		m := makeRule.FindStringSubmatch(line)
+		if m == nil {
+			warn("%s: %q was not understood and was ignored", where, line)
+			current = nil
+			continue
+		}
+		prereqs, inline := m[2], ""
+		if i := strings.Index(prereqs, ";"); i >= 0 {
+			prereqs, inline = prereqs[:i], strings.TrimSpace(prereqs[i+1:])
+		}
+		current = nil
+		for _, name := range strings.Fields(m[1]) {
+			if strings.HasPrefix(name, ".") {
+				continue
+			}
+			if strings.Contains(name, "%") {
+				warn("%s: pattern rule %s is not supported and was ignored", where, name)
+				continue
+			}
+			target, ok := targets[name]
+			if !ok {
+				target = &makeTarget{name: name}
+				targets[name] = target
+				order = append(order, name)
+			}
+			target.prereqs = append(target.prereqs, strings.Fields(strings.Split(prereqs, "|")[0])...)
+			if inline != "" {
+				target.recipe = append(target.recipe, inline)
+			}
+			current = append(current, target)
+		}
+	}
+	if len(targets) == 0 {
+		return "", nil, fmt.Errorf("no targets found, is it a Makefile?")
+	}
+	if only != "" && targets[only] == nil {
+		return "", nil, fmt.Errorf("no target named %s", only)
+	}
+
+	// translate rewrites the references of s: make variables become
+	// placeholders, environment variables shell variables and $@ the
+	// target.
+	translate := func(where string, target *makeTarget, s string) string {
+		s = strings.ReplaceAll(s, "$$", "\x00")
+		s = makeReference.ReplaceAllStringFunc(s, func(ref string) string {
+			m := makeReference.FindStringSubmatch(ref)
+			name := m[1] + m[2]
+			switch {
+			case m[3] == "@" && target != nil:
+				return target.name
+			case m[3] == "<" && target != nil && len(target.prereqs) > 0:
+				return target.prereqs[0]
+			case m[3] == "^" && target != nil:
+				return strings.Join(target.prereqs, " ")
+			case m[3] != "", strings.ContainsAny(name, " ,:"):
+				warn("%s: %s left as is", where, ref)
+				return ref
+			}
+			if _, ok := vars[name]; ok {
+				return "{{" + name + "}}"
+			}
+			return "${" + name + "}"
+		})
+		return strings.ReplaceAll(s, "\x00", "$")
+	}
+
+	// Rayder doesn't resolve placeholders in variables, so variables made
+	// of others get the value the others have here.
+	resolved := make(map[string]string, len(vars))
+	for _, name := range varOrder {
+		value := vars[name]
+		for depth := 0; depth < 10 && makeReference.MatchString(value); depth++ {
+			value = makeReference.ReplaceAllStringFunc(value, func(ref string) string {
+				m := makeReference.FindStringSubmatch(ref)
+				if v, ok := vars[m[1]+m[2]]; ok {
+					return v
+				}
+				return ref
+			})
+		}
+		if value != vars[name] {
+			warn("variable %s refers to other variables and was given their current values", name)
+		}
+		resolved[name] = translate("variable "+name, nil, value)
+	}
+
+	// Targets without a recipe only group others, they are replaced by the
+	// targets they group.
+	var requires func(name string, seen map[string]bool) []string
+	requires = func(name string, seen map[string]bool) []string {
+		var required []string
+		for _, prereq := range targets[name].prereqs {
+			target, ok := targets[prereq]
+			switch {
+			case !ok:
+				warn("target %s: prerequisite %s is not a target and was ignored", name, prereq)
+			case seen[prereq]:
+			case len(target.recipe) == 0:
+				seen[prereq] = true
+				required = append(required, requires(prereq, seen)...)
+			default:
+				seen[prereq] = true
+				required = append(required, prereq)
+			}
+		}
+		return required
+	}
+
+	out := convertedWorkflow{Vars: convertedVars(resolved)}
+	for _, name := range order {
+		target := targets[name]
+		if only != "" && name != only || len(target.recipe) == 0 {
+			continue
+		}
+		module := convertedModule{Name: name, Required: requires(name, map[string]bool{})}
+		if only != "" {
+			for _, required := range module.Required {
+				warn("target %s: requires %s, which is not converted", name, required)
+			}
+			module.Required = nil
+		}
+		for _, line := range target.recipe {
+			where := "target " + name
+			cmd := strings.TrimLeft(line, "@+")
+			if strings.HasPrefix(cmd, "-") {
+				warn("%s: %q ignores errors in make but fails the module in rayder", where, line)
+				cmd = strings.TrimLeft(cmd, "-@+")
+			}
+			module.Cmds = append(module.Cmds, translate(where, target, strings.TrimSpace(cmd)))
+		}
+		out.Modules = append(out.Modules, module)
+	}
+	if len(out.Modules) == 0 {
+		return "", nil, fmt.Errorf("no target has a recipe")
+	}
+
+	content, err := yaml.Marshal(out)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(content), warnings, nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type taskfile struct {
+	Vars  yaml.MapSlice          `yaml:"vars"`
+	Env   map[string]interface{} `yaml:"env"`
+	Tasks yaml.MapSlice          `yaml:"tasks"`
+	Other map[string]interface{} `yaml:",inline"`
+}
+
+type taskfileTask struct {
+	Desc  string                 `yaml:"desc"`
+	Deps  []taskfileCall         `yaml:"deps"`
+	Cmds  []taskfileCmd          `yaml:"cmds"`
+	Dir   string                 `yaml:"dir"`
+	Env   map[string]interface{} `yaml:"env"`
+	Vars  yaml.MapSlice          `yaml:"vars"`
+	Other map[string]interface{} `yaml:",inline"`
+}
+
+func (t *taskfileTask) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var cmd string
+	if err := unmarshal(&cmd); err == nil {
+		t.Cmds = []taskfileCmd{{Cmd: cmd}}
+		return nil
+	}
+	var cmds []taskfileCmd
+	if err := unmarshal(&cmds); err == nil {
+		t.Cmds = cmds
+		return nil
+	}
+	type plain taskfileTask
+	return unmarshal((*plain)(t))
+}
+
+// taskfileCall is a dependency or command calling another task, by its
+// name alone or with vars.
+type taskfileCall struct {
+	Task string        `yaml:"task"`
+	Vars yaml.MapSlice `yaml:"vars"`
+}
+
+func (c *taskfileCall) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&c.Task); err == nil {
+		return nil
+	}
+	type plain taskfileCall
+	return unmarshal((*plain)(c))
+}
+
+type taskfileCmd struct {
+	Cmd   string                 `yaml:"cmd"`
+	Task  string                 `yaml:"task"`
+	Other map[string]interface{} `yaml:",inline"`
+}
+
+func (c *taskfileCmd) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&c.Cmd); err == nil {
+		return nil
+	}
+	type plain taskfileCmd
+	return unmarshal((*plain)(c))
+}
+
+// taskfileTemplate matches a template action of a Taskfile.
+var taskfileTemplate = regexp.MustCompile(`\{\{-?\s*(.*?)\s*-?\}\}`)
+
+// convertTaskfile translates the tasks of a Taskfile into modules: cmds
+// become commands and deps become required. Variables become workflow
+// variables, or are filled in when a task sets them.
+func convertTaskfile(data []byte, only string) (string, []string, error) {
+	var file taskfile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return "", nil, err
+	}
+	if len(file.Tasks) == 0 {
+		return "", nil, fmt.Errorf("no tasks found, is it a Taskfile?")
+	}
+
+	var warnings []string
+	warn := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+	ignored := func(where string, other map[string]interface{}) {
+		keys := make([]string, 0, len(other))
+		for key := range other {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if key != "version" && key != "silent" {
+				warn("%s: %s is not supported and was ignored", where, key)
+			}
+		}
+	}
+	ignored("taskfile", file.Other)
+
+	// translate rewrites the template actions of s: workflow variables
+	// become placeholders and the variables of the task their values.
+	vars := make(map[string]string)
+	translate := func(where, name string, local map[string]string, s string) string {
+		return taskfileTemplate.ReplaceAllStringFunc(s, func(action string) string {
+			inner := taskfileTemplate.FindStringSubmatch(action)[1]
+			key := strings.TrimPrefix(inner, ".")
+			switch _, global := vars[key]; {
+			case !strings.HasPrefix(inner, ".") || strings.ContainsAny(key, " .|("):
+			case key == "TASK" && name != "":
+				return name
+			case local[key] != "":
+				return local[key]
+			case global:
+				return "{{" + key + "}}"
+			}
+			warn("%s: %s left as is", where, action)
+			return action
+		})
+	}
+	staticVars := func(where string, items yaml.MapSlice) map[string]string {
+		values := make(map[string]string, len(items))
+		for _, item := range items {
+			key := fmt.Sprint(item.Key)
+			switch value := item.Value.(type) {
+			case string, int, float64, bool:
+				values[key] = fmt.Sprint(value)
+			default:
+				warn("%s: variable %s is not a plain value and was ignored", where, key)
+				values[key] = ""
+			}
+		}
+		return values
+	}
+	for key, value := range staticVars("taskfile", file.Vars) {
+		vars[key] = value
+	}
+	for key, value := range vars {
+		vars[key] = translate("variable "+key, "", nil, value)
+	}
+	env := make(map[string]string)
+	for key, value := range file.Env {
+		env[key] = translate("env "+key, "", nil, fmt.Sprint(value))
+	}
+
+	names := make(map[string]bool)
+	for _, item := range file.Tasks {
+		names[fmt.Sprint(item.Key)] = true
+	}
+	if only != "" && !names[only] {
+		return "", nil, fmt.Errorf("no task named %s", only)
+	}
+
+	out := convertedWorkflow{Vars: convertedVars(vars)}
+	groups := make(map[string][]string)
+	for _, item := range file.Tasks {
+		name := fmt.Sprint(item.Key)
+		if only != "" && name != only {
+			continue
+		}
+		raw, err := yaml.Marshal(item.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		var task taskfileTask
+		if err := yaml.Unmarshal(raw, &task); err != nil {
+			return "", nil, fmt.Errorf("task %s: %v", name, err)
+		}
+		where := "task " + name
+		ignored(where, task.Other)
+
+		module := convertedModule{Name: strings.ReplaceAll(name, ":", "-"), Description: task.Desc}
+		local := staticVars(where, task.Vars)
+		require := func(call string) {
+			if only != "" || !names[call] {
+				warn("%s: calls %s, which is not converted", where, call)
+				return
+			}
+			if call = strings.ReplaceAll(call, ":", "-"); !containsString(module.Required, call) {
+				module.Required = append(module.Required, call)
+			}
+		}
+		for _, dep := range task.Deps {
+			if len(dep.Vars) > 0 {
+				warn("%s: the vars given to %s were ignored", where, dep.Task)
+			}
+			require(dep.Task)
+		}
+
+		moduleEnv := make(map[string]string, len(env)+len(task.Env))
+		for key, value := range env {
+			moduleEnv[key] = value
+		}
+		for key, value := range task.Env {
+			moduleEnv[key] = translate(where, name, local, fmt.Sprint(value))
+		}
+		module.Env = convertedEnv(moduleEnv)
+
+		onlyCalls := true
+		for _, cmd := range task.Cmds {
+			onlyCalls = onlyCalls && cmd.Task != ""
+		}
+		for _, cmd := range task.Cmds {
+			ignored(where, cmd.Other)
+			if cmd.Task != "" {
+				if !onlyCalls {
+					warn("%s: the call of %s runs before the task's commands in rayder", where, cmd.Task)
+				}
+				require(cmd.Task)
+				continue
+			}
+			line := translate(where, name, local, strings.TrimRight(cmd.Cmd, "\n"))
+			if task.Dir != "" {
+				line = "cd " + shellQuote(translate(where, name, local, task.Dir)) + " && " + line
+			}
+			module.Cmds = append(module.Cmds, line)
+		}
+		if len(module.Cmds) == 0 {
+			groups[module.Name] = module.Required
+			continue
+		}
+		out.Modules = append(out.Modules, module)
+	}
+	if len(out.Modules) == 0 {
+		return "", nil, fmt.Errorf("no task has commands")
+	}
+	resolveRequired(out.Modules, groups)
+
+	content, err := yaml.Marshal(out)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(content), warnings, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+
+	"github.com/mattn/go-isatty"
+)
+
+// debugOnFail opens a shell when a command of a module fails, so the failure
+// can be reproduced right away instead of rerunning the whole workflow.
+var debugOnFail bool
+
+// debugShells makes failing parallel modules open their shells one at a
+// time.
+var debugShells sync.Mutex
+
+// debugShell opens an interactive shell in the environment cmd of task ran
+// in, with the variables exported, and returns once it exits. The run goes
+// on afterwards, with the module failed.
+func debugShell(taskName string, task Task, cmd Command, vars map[string]string, cyan, yellow, red func(a ...interface{}) string) {
+	debugShells.Lock()
+	defer debugShells.Unlock()
+
+	stdin, stdout, stderr := os.Stdin, os.Stdout, os.Stderr
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer tty.Close()
+		stdin, stdout, stderr = tty, tty, tty
+	} else if !isatty.IsTerminal(os.Stdin.Fd()) && !isatty.IsCygwinTerminal(os.Stdin.Fd()) {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s': -debug-on-fail needs a terminal, not opening a shell\n", yellow(currentTime()), red("ERROR"), cyan(taskName))
+		return
+	}
+
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = defaultShell()
+	}
+	fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s' failed, opening %s with its environment and variables. Exit the shell to continue the run.\n", yellow(currentTime()), red("DEBUG"), cyan(taskName), shell)
+
+	sh := exec.Command(shell)
+	sh.Stdin, sh.Stdout, sh.Stderr = stdin, stdout, stderr
+	sh.Env = debugEnv(taskName, task, cmd, vars)
+	if err := sh.Run(); err != nil {
+		if _, exited := err.(*exec.ExitError); !exited {
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s': opening a shell: %v\n", yellow(currentTime()), red("ERROR"), cyan(taskName), err)
+		}
+	}
+	logLifecycle("[%s] [%s] Debug shell of module '%s' closed, continuing the run\n", yellow(currentTime()), yellow("INFO"), cyan(taskName))
+}
+
+// debugEnv returns the environment of the commands of task with the
+// variables added, except where the module's env sets the same name, and
+// RAYDER_MODULE and RAYDER_FAILED_COMMAND describing the failure.
+func debugEnv(taskName string, task Task, cmd Command, vars map[string]string) []string {
+	env := commandEnv(task, vars)
+	if env == nil {
+		env = os.Environ()
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		if _, set := task.Env[name]; !set && varNamePattern.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, name+"="+vars[name])
+	}
+	return append(env, "RAYDER_MODULE="+taskName, "RAYDER_FAILED_COMMAND="+describeCommand(cmd, vars))
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// diffLast makes a run compare its artifacts with the previous run of the
+// same workflows once it ends.
+var diffLast bool
+
+// artifactDiff lists the lines an artifact gained and lost between two runs.
+type artifactDiff struct {
+	Path    string   `json:"path"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// diffRuns compares the artifact snapshots of two runs line by line, ignoring
+// order and duplicates. Artifacts missing from a run count as empty.
+func diffRuns(dir string, a, b RunRecord) ([]artifactDiff, error) {
+	paths := make(map[string]bool)
+	for path := range a.Snapshots {
+		paths[path] = true
+	}
+	for path := range b.Snapshots {
+		paths[path] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	var diffs []artifactDiff
+	for _, path := range sorted {
+		before, err := snapshotLines(dir, a, path)
+		if err != nil {
+			return nil, err
+		}
+		after, err := snapshotLines(dir, b, path)
+		if err != nil {
+			return nil, err
+		}
+
+		d := artifactDiff{Path: path}
+		for _, line := range after.order {
+			if !before.set[line] {
+				d.Added = append(d.Added, line)
+			}
+		}
+		for _, line := range before.order {
+			if !after.set[line] {
+				d.Removed = append(d.Removed, line)
+			}
+		}
+		if len(d.Added) > 0 || len(d.Removed) > 0 {
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs, nil
+}
+
+type lineSet struct {
+	order []string
+	set   map[string]bool
+}
+
+// snapshotLines returns the distinct non-empty lines of the snapshot of path
+// in run.
+func snapshotLines(dir string, run RunRecord, path string) (lineSet, error) {
+	lines := lineSet{set: make(map[string]bool)}
+	name, ok := run.Snapshots[path]
+	if !ok {
+		return lines, nil
+	}
+
+	file, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return lines, fmt.Errorf("run %s: %w", run.ID, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || lines.set[line] {
+			continue
+		}
+		lines.set[line] = true
+		lines.order = append(lines.order, line)
+	}
+	return lines, scanner.Err()
+}
+
+// previousRun returns the most recent run of the same workflows before run.
+func previousRun(runs []RunRecord, run RunRecord) (RunRecord, bool) {
+	for _, prev := range runs {
+		if prev.ID != run.ID && prev.Started.Before(run.Started) &&
+			strings.Join(prev.Workflows, "\x00") == strings.Join(run.Workflows, "\x00") {
+			return prev, true
+		}
+	}
+	return RunRecord{}, false
+}
+
+func writeDiffs(w io.Writer, diffs []artifactDiff, cyan, red, green func(a ...interface{}) string) {
+	for _, d := range diffs {
+		fmt.Fprintf(w, "%s: %d new, %d removed\n", cyan(d.Path), len(d.Added), len(d.Removed))
+		for _, line := range d.Added {
+			fmt.Fprintf(w, "%s %s\n", green("+"), line)
+		}
+		for _, line := range d.Removed {
+			fmt.Fprintf(w, "%s %s\n", red("-"), line)
+		}
+	}
+}
+
+// printChanges prints the result of changesSincePrevious; errors are
+// reported by the caller.
+func printChanges(prev *RunRecord, diffs []artifactDiff, err error, yellow, cyan, red, green func(a ...interface{}) string) {
+	switch {
+	case err != nil:
+	case prev == nil:
+		logLifecycle("[%s] [%s] No previous run of these workflows to compare with\n", yellow(currentTime()), yellow("INFO"))
+	case len(diffs) == 0:
+		logLifecycle("[%s] [%s] No artifact changed since run %s\n", yellow(currentTime()), yellow("INFO"), cyan(prev.ID))
+	default:
+		logLifecycle("[%s] [%s] Changes since run %s:\n", yellow(currentTime()), yellow("INFO"), cyan(prev.ID))
+		writeDiffs(os.Stdout, diffs, cyan, red, green)
+	}
+}
+
+// changesSincePrevious diffs run against the previous run of the same
+// workflows. Without a previous run, which is then nil, everything run
+// produced counts as new.
+func changesSincePrevious(run RunRecord) (*RunRecord, []artifactDiff, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	runs, err := loadRuns()
+	if err != nil {
+		return nil, nil, err
+	}
+	prev, ok := previousRun(runs, run)
+	diffs, err := diffRuns(dir, prev, run)
+	if !ok {
+		return nil, diffs, err
+	}
+	return &prev, diffs, err
+}
+
+func runDiffCommand(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	noColor := fs.Bool("no-color", userConfig.NoColor, "Disable colored output")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fmt.Fprintln(os.Stderr, "Usage: rayder diff <run-a> [<run-b>]")
+		fmt.Fprintln(os.Stderr, "Compares the artifacts of two runs; run-b defaults to the most recent run.")
+		return 2
+	}
+
+	dir, err := historyDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	runs, err := loadRuns()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	ids := []string{fs.Arg(0), "last"}
+	if fs.NArg() == 2 {
+		ids[1] = fs.Arg(1)
+	}
+	var pair [2]RunRecord
+	for i, id := range ids {
+		if pair[i], err = findRun(runs, id); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	diffs, err := diffRuns(dir, pair[0], pair[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(diffs) == 0 {
+		fmt.Fprintln(os.Stderr, "No artifact changed")
+		return 0
+	}
+
+	if *noColor || os.Getenv("NO_COLOR") != "" {
+		color.NoColor = true
+	}
+	writeDiffs(os.Stdout, diffs, color.New(color.FgCyan).SprintFunc(), color.New(color.FgRed).SprintFunc(), color.New(color.FgGreen).SprintFunc())
+	return 0
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// diskCheckInterval is how often free disk space is checked during a run.
+const diskCheckInterval = 15 * time.Second
+
+// DiskGuard watches the free space of the filesystem results are written
+// to, so a full disk stops the run cleanly instead of making tools fail in
+// confusing ways halfway through writing results.
+type DiskGuard struct {
+	Size   string `yaml:"size"`
+	Path   string `yaml:"path"`
+	Action string `yaml:"action"` // pause (default) or warn
+
+	size uint64
+}
+
+// UnmarshalYAML accepts a size alone, as in min_free_disk: 5GiB, or the
+// full form.
+func (g *DiskGuard) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var size string
+	if err := unmarshal(&size); err == nil {
+		g.Size = size
+	} else {
+		type diskGuard DiskGuard
+		if err := unmarshal((*diskGuard)(g)); err != nil {
+			return err
+		}
+	}
+
+	n, err := parseBytes(g.Size)
+	if err != nil {
+		return fmt.Errorf("invalid min_free_disk: %w", err)
+	}
+	g.size = n
+	if g.Action == "" {
+		g.Action = "pause"
+	}
+	if g.Action != "pause" && g.Action != "warn" {
+		return fmt.Errorf("invalid min_free_disk action %q, expected pause or warn", g.Action)
+	}
+	return nil
+}
+
+// path returns the directory whose filesystem is watched: the configured
+// path, else OUTPUT_DIR, else the working directory. A directory that
+// doesn't exist yet is represented by its closest existing parent.
+func (g *DiskGuard) path(vars map[string]string) string {
+	path := replacePlaceholders(g.Path, vars)
+	if path == "" {
+		path = vars["OUTPUT_DIR"]
+	}
+	if path == "" {
+		path = "."
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "."
+	}
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// watchDisk checks the free space right away, so a run doesn't start on a
+// full disk, and then every diskCheckInterval until the returned function
+// is called. Below the threshold it warns and, with the pause action, holds
+// back new modules until space is freed.
+func watchDisk(g *DiskGuard, vars map[string]string, yellow, cyan, red func(a ...interface{}) string) func() {
+	if g == nil {
+		return func() {}
+	}
+	path := g.path(vars)
+	low := false
+
+	check := func() {
+		free, err := freeDiskSpace(path)
+		if err != nil {
+			if !low {
+				fmt.Fprintf(os.Stderr, "[%s] [%s] Checking free disk space on %s: %v\n", yellow(currentTime()), red("ERROR"), path, err)
+			}
+			return
+		}
+		switch {
+		case free < g.size && !low:
+			low = true
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Only %s free on %s, below min_free_disk of %s\n", yellow(currentTime()), yellow("WARN"), formatBytes(free), cyan(path), formatBytes(g.size))
+			if g.Action == "pause" {
+				logLifecycle("[%s] [%s] Run %s, no new modules start until disk space is freed\n", yellow(currentTime()), yellow("INFO"), yellow("paused"))
+				control.hold(true)
+			}
+		case free >= g.size && low:
+			low = false
+			logLifecycle("[%s] [%s] %s free on %s again\n", yellow(currentTime()), yellow("INFO"), formatBytes(free), cyan(path))
+			if g.Action == "pause" {
+				logLifecycle("[%s] [%s] Run %s\n", yellow(currentTime()), yellow("INFO"), yellow("resumed"))
+				control.hold(false)
+			}
+		}
+	}
+
+	check()
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(diskCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				check()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		control.hold(false)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ageArmorHeader starts the ASCII armored output of age -a.
+const ageArmorHeader = "-----BEGIN AGE ENCRYPTED FILE-----"
+
+// ageIdentity is the identity file encrypted variables are decrypted with;
+// set with -age-identity or RAYDER_AGE_IDENTITY.
+var ageIdentity string
+
+// decryptVars decrypts the encrypted variables of config in place: vars
+// defaults that hold armored age ciphertext, and the variables of its
+// secrets_files, which are decrypted with sops and override the defaults.
+// Variables given in args, the command line's assignments, are left alone,
+// so a key that can't be decrypted can still be passed by hand. Decrypted
+// variables are secret.
+func decryptVars(config *Config, args []string) error {
+	given := assignedVars(args)
+
+	for _, path := range config.SecretsFiles {
+		values, err := sopsDecrypt(path)
+		if err != nil {
+			return fmt.Errorf("decrypting %s: %w", path, err)
+		}
+		for name, value := range values {
+			if config.Vars == nil {
+				config.Vars = make(map[string]string)
+			}
+			config.Vars[name] = value
+			markSecret(name)
+		}
+	}
+
+	names := make([]string, 0, len(config.Vars))
+	for name, value := range config.Vars {
+		if !given[name] && strings.HasPrefix(strings.TrimSpace(value), ageArmorHeader) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value, err := ageDecrypt(config.Vars[name])
+		if err != nil {
+			return fmt.Errorf("decrypting variable %s: %w", name, err)
+		}
+		config.Vars[name] = value
+		markSecret(name)
+	}
+	return nil
+}
+
+// ageIdentityFile returns the identity file to decrypt with: ageIdentity,
+// else the one sops uses.
+func ageIdentityFile() (string, error) {
+	if ageIdentity != "" {
+		return ageIdentity, nil
+	}
+	if path := os.Getenv("SOPS_AGE_KEY_FILE"); path != "" {
+		return path, nil
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		path := filepath.Join(dir, "sops", "age", "keys.txt")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no age identity, pass one with -age-identity")
+}
+
+// ageDecrypt decrypts armored age ciphertext with the age CLI. The newline
+// ending most encrypted values is dropped.
+func ageDecrypt(ciphertext string) (string, error) {
+	identity, err := ageIdentityFile()
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("age", "--decrypt", "--identity", identity)
+	cmd.Stdin = strings.NewReader(strings.TrimSpace(ciphertext) + "\n")
+	out, err := runDecrypter(cmd)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// sopsDecrypt decrypts a sops encrypted YAML, JSON or dotenv file of
+// variables with the sops CLI. Nested values are not variables and are
+// skipped.
+func sopsDecrypt(path string) (map[string]string, error) {
+	cmd := exec.Command("sops", "--decrypt", "--output-type", "json", path)
+	if ageIdentity != "" {
+		cmd.Env = append(os.Environ(), "SOPS_AGE_KEY_FILE="+ageIdentity)
+	}
+	out, err := runDecrypter(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(doc))
+	for name, value := range doc {
+		switch value := value.(type) {
+		case map[string]interface{}, []interface{}:
+		case string:
+			values[name] = value
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	return values, nil
+}
+
+// runDecrypter runs an age or sops command and returns its output, or its
+// error message as the error.
+func runDecrypter(cmd *exec.Cmd) ([]byte, error) {
+	if _, err := exec.LookPath(cmd.Path); err != nil {
+		return nil, fmt.Errorf("%s was not found in PATH", filepath.Base(cmd.Path))
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		name := filepath.Base(cmd.Path)
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			if !strings.HasPrefix(msg, name+":") {
+				msg = name + ": " + msg
+			}
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// estimateRuns is how many of the most recent completed runs estimates are
+// based on.
+const estimateRuns = 10
+
+// estimates holds how long the workflows and their modules took in previous
+// completed runs of the same workflows, from the run history.
+var estimates struct {
+	runs    int
+	total   time.Duration
+	modules map[string]time.Duration
+}
+
+// loadEstimates averages the durations of the last completed runs of
+// workflows and of their completed modules.
+func loadEstimates(workflows []string) error {
+	var runs []RunRecord
+	var err error
+	if database != nil {
+		runs, err = database.loadRuns()
+	} else {
+		runs, err = loadRuns()
+	}
+	if err != nil {
+		return err
+	}
+
+	var total time.Duration
+	sums := make(map[string]time.Duration)
+	counts := make(map[string]int)
+	estimates.runs = 0
+	for _, run := range runs {
+		if estimates.runs == estimateRuns {
+			break
+		}
+		if run.Status != statusCompleted || strings.Join(run.Workflows, "\x00") != strings.Join(workflows, "\x00") {
+			continue
+		}
+		estimates.runs++
+		total += run.Finished.Sub(run.Started)
+		for _, module := range run.Modules {
+			if module.Status == statusCompleted {
+				sums[module.Name] += module.Duration
+				counts[module.Name]++
+			}
+		}
+	}
+	if estimates.runs == 0 {
+		return nil
+	}
+
+	estimates.total = total / time.Duration(estimates.runs)
+	estimates.modules = make(map[string]time.Duration, len(sums))
+	for name, sum := range sums {
+		estimates.modules[name] = sum / time.Duration(counts[name])
+	}
+	return nil
+}
+
+// printEstimate logs how long the run is expected to take.
+func printEstimate(yellow, cyan func(a ...interface{}) string) {
+	if estimates.runs == 0 {
+		return
+	}
+	finish := time.Now().Add(estimates.total)
+	logLifecycle("[%s] [%s] Expected to take about %s, finishing around %s (from %d previous runs)\n", yellow(currentTime()), yellow("INFO"), cyan(roundEstimate(estimates.total)), finish.Format("15:04"), estimates.runs)
+}
+
+// estimateNote returns the usual duration of module to append to its
+// running line, or "" when it is unknown.
+func estimateNote(module string) string {
+	if d, ok := estimates.modules[module]; ok {
+		return fmt.Sprintf(" (usually %s)", roundEstimate(d))
+	}
+	return ""
+}
+
+// watchOverrun warns when module is still running well after its usual
+// duration: twice as long, and at least a minute more. The returned function
+// stops watching.
+func watchOverrun(module string, yellow, cyan func(a ...interface{}) string) func() {
+	usual, ok := estimates.modules[module]
+	if !ok {
+		return func() {}
+	}
+	after := 2 * usual
+	if after < usual+time.Minute {
+		after = usual + time.Minute
+	}
+	timer := time.AfterFunc(after, func() {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s' has been running for %s, it usually takes %s\n", yellow(currentTime()), yellow("WARN"), cyan(module), roundEstimate(after), roundEstimate(usual))
+	})
+	return func() { timer.Stop() }
+}
+
+// roundEstimate rounds d to a precision that doesn't pretend to be exact.
+func roundEstimate(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Hour:
+		return d.Round(time.Minute)
+	case d >= time.Minute:
+		return d.Round(10 * time.Second)
+	case d >= time.Second:
+		return d.Round(time.Second)
+	default:
+		return d.Round(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"embed"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+// exampleWorkflows are the starter workflows built into rayder, so new users
+// have something to run without hunting for workflows first.
+//
+//go:embed examples/*.yaml
+var exampleWorkflows embed.FS
+
+func runExamplesCommand(args []string) int {
+	if len(args) > 0 && args[0] == "get" {
+		return runExamplesGet(args[1:])
+	}
+	fs := flag.NewFlagSet("examples", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: rayder examples\n       rayder examples get [-o file] [-force] NAME")
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, name := range exampleNames() {
+		data, _ := exampleWorkflows.ReadFile("examples/" + name + ".yaml")
+		var example struct {
+			Usage string `yaml:"usage"`
+		}
+		yaml.Unmarshal(data, &example)
+		fmt.Fprintf(w, "%s\t%s\n", name, example.Usage)
+	}
+	w.Flush()
+	fmt.Fprintln(os.Stderr, "\nGet one with: rayder examples get <name>")
+	return 0
+}
+
+func runExamplesGet(args []string) int {
+	fs := flag.NewFlagSet("examples get", flag.ExitOnError)
+	output := fs.String("o", "", "Path of the workflow file to create, - for stdout (default: NAME.yaml)")
+	force := fs.Bool("force", false, "Overwrite an existing file")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: rayder examples get [-o file] [-force] NAME")
+		return exitUsage
+	}
+
+	name := strings.TrimSuffix(fs.Arg(0), ".yaml")
+	data, err := exampleWorkflows.ReadFile("examples/" + name + ".yaml")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: no example named %s, expected one of: %s\n", name, strings.Join(exampleNames(), ", "))
+		return exitFailed
+	}
+
+	if *output == "-" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if *output == "" {
+		*output = name + ".yaml"
+	}
+	if _, err := os.Stat(*output); err == nil && !*force {
+		fmt.Fprintf(os.Stderr, "Error: %s already exists, use -force to overwrite it\n", *output)
+		return exitFailed
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+	fmt.Fprintf(os.Stderr, "Created %s, see what it needs with: rayder -w %s usage\n", *output, *output)
+	return 0
+}
+
+func exampleNames() []string {
+	entries, _ := exampleWorkflows.ReadDir("examples")
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), path.Ext(entry.Name())))
+	}
+	sort.Strings(names)
+	return names
+}
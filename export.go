@@ -0,0 +1,466 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+func runExportCommand(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var files workflowList
+	fs.Var(&files, "w", "Path to the workflow YAML file (repeat or comma separate for several)")
+	format := fs.String("format", "sh", "Output format: sh")
+	output := fs.String("o", "", "Path of the script to create (default: print it)")
+	profile := fs.String("profile", "", "Profile from the workflow's profiles section to apply")
+	tags := fs.String("tags", "", "Only export modules with one of these comma separated tags")
+	skipTags := fs.String("skip-tags", "", "Leave out modules with one of these comma separated tags")
+	force := fs.Bool("force", false, "Overwrite an existing file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: rayder export -w workflow.yaml [-format sh] [-o script.sh] [VAR=value ...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if len(files) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if *format != "sh" {
+		fmt.Fprintf(os.Stderr, "Error: unknown format %q, expected sh\n", *format)
+		return exitUsage
+	}
+
+	config, err := loadWorkflows(files, false, verifyOptions{})
+	if err == nil && *profile != "" {
+		err = applyProfile(&config, *profile)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading workflow: %v\n", err)
+		return exitInvalid
+	}
+	if *tags != "" || *skipTags != "" {
+		config.Tasks, _ = selectTasks(config.Tasks, splitList(*tags), splitList(*skipTags))
+	}
+
+	vars := make(map[string]string)
+	for _, arg := range fs.Args() {
+		if name, value, ok := strings.Cut(arg, "="); ok {
+			vars[name] = value
+		}
+	}
+	for key, value := range config.Vars {
+		if _, exists := vars[key]; !exists {
+			vars[key] = value
+		}
+	}
+
+	script, warnings := exportShell(config, files, vars)
+
+	// Secrets are read from the environment when the script runs.
+	var missing []string
+	for _, name := range missingVars(config.VarSpecs, vars) {
+		if !isSecretVar(name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: missing required variables: %s\n", strings.Join(missing, ", "))
+		return exitUsage
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	if *output == "" {
+		fmt.Print(script)
+		return 0
+	}
+	if _, err := os.Stat(*output); err == nil && !*force {
+		fmt.Fprintf(os.Stderr, "Error: %s already exists, use -force to overwrite it\n", *output)
+		return exitFailed
+	}
+	if err := os.WriteFile(*output, []byte(script), 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailed
+	}
+	fmt.Fprintf(os.Stderr, "Created %s\n", *output)
+	return 0
+}
+
+// shellRunVars are the automatic variables an exported script computes when
+// it starts, rather than when it was exported.
+var shellRunVars = map[string]string{
+	"TIMESTAMP": "$(date +%Y%m%d-%H%M%S)",
+	"DATE":      "$(date +%Y-%m-%d)",
+	"RANDOM":    "$(awk 'BEGIN { srand(); print int(rand() * 32768) }')",
+	"HOSTNAME":  "$(uname -n)",
+	"CWD":       "$(pwd)",
+}
+
+// shellRef marks where a variable the script resolves itself, an automatic
+// variable, a secret or the current line of a foreach_file, is referenced
+// once placeholders are replaced.
+var shellRef = regexp.MustCompile("\x01([A-Za-z_][A-Za-z0-9_]*)\x02")
+
+func shellRefTo(name string) string {
+	return "\x01" + name + "\x02"
+}
+
+// shellRefs turns the references of s into shell variables, inside single
+// quotes when quoted is set.
+func shellRefs(s string, quoted bool) string {
+	if quoted {
+		return shellRef.ReplaceAllString(s, `'"$${$1}"'`)
+	}
+	return shellRef.ReplaceAllString(s, "$${$1}")
+}
+
+// shellScript is an exported script being written, with what it left out.
+type shellScript struct {
+	strings.Builder
+	warnings []string
+}
+
+func (s *shellScript) warn(format string, args ...interface{}) {
+	s.warnings = append(s.warnings, fmt.Sprintf(format, args...))
+}
+
+// exportShell writes config as a POSIX shell script running its modules one
+// after another with vars resolved, and returns the script and what of the
+// workflow it could not express. Like rayder, the script runs every module
+// even if one fails, skips all but always_run modules when before_all fails,
+// and exits with 1 if anything failed. Secrets are never written to the
+// script: it reads them from the environment.
+func exportShell(config Config, files []string, given map[string]string) (string, []string) {
+	vars := make(map[string]string, len(given))
+	for key, value := range given {
+		vars[key] = value
+	}
+	markSecret(secretNames(config.Secrets)...)
+	var secrets []string
+	for _, secret := range config.Secrets {
+		vars[secret.Name] = ""
+	}
+	for name, value := range vars {
+		if isSecretVar(name) || strings.HasPrefix(value, ageArmorHeader) {
+			vars[name] = shellRefTo(name)
+			secrets = append(secrets, name)
+		}
+	}
+	for name := range shellRunVars {
+		if _, exists := vars[name]; !exists {
+			vars[name] = shellRefTo("RAYDER_" + name)
+		}
+	}
+	sort.Strings(secrets)
+
+	var body shellScript
+	if len(config.Before) > 0 {
+		body.WriteString("before_all() (\n\tset -e\n")
+		for _, cmd := range config.Before {
+			body.command(Task{Name: "before_all", Shell: config.Shell}, cmd, vars)
+		}
+		body.WriteString(")\n\nbefore_all\n[ $? -eq 0 ] || { echo \"before_all hooks errored, skipping modules\" >&2; failed=1; aborted=1; }\n\n")
+	}
+	for i, task := range config.Tasks {
+		body.module(i+1, task, vars)
+	}
+	if len(config.After) > 0 {
+		body.WriteString("after_all() (\n\tset -e\n")
+		for _, cmd := range config.After {
+			body.command(Task{Name: "after_all", Shell: config.Shell}, cmd, vars)
+		}
+		body.WriteString(")\n\nafter_all\n[ $? -eq 0 ] || failed=1\n\n")
+	}
+
+	text := shellRefs(body.String(), false)
+	var s strings.Builder
+	fmt.Fprintf(&s, "#!/bin/sh\n# Exported by rayder %s from %s.\n", version, strings.Join(files, ", "))
+	s.WriteString("# Modules run one after another. A failed module doesn't stop the next\n# ones, but the script exits with 1.\n\n")
+	header := s.Len()
+	for _, name := range secrets {
+		if strings.Contains(text, "${"+name+"}") {
+			fmt.Fprintf(&s, ": \"${%s:?%s must be set}\"\n", name, name)
+		}
+	}
+	names := make([]string, 0, len(shellRunVars))
+	for name := range shellRunVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.Contains(text, "${RAYDER_"+name+"}") {
+			fmt.Fprintf(&s, "RAYDER_%s=%s\n", name, shellRunVars[name])
+		}
+	}
+	if strings.Contains(text, "$RAYDER_STREAMS") {
+		s.WriteString("RAYDER_STREAMS=$(mktemp -d) || exit 1\ntrap 'rm -rf \"$RAYDER_STREAMS\"' EXIT\n")
+	}
+	if s.Len() > header {
+		s.WriteString("\n")
+	}
+	s.WriteString(`failed=0
+aborted=0
+
+# run FUNCTION MODULE [always] runs a module and records whether it failed.
+run() {
+	if [ "$aborted" = 1 ] && [ "$3" != always ]; then
+		echo "Module '$2' skipped" >&2
+		return
+	fi
+	echo "Module '$2' running" >&2
+	"$1"
+	if [ $? -eq 0 ]; then
+		echo "Module '$2' completed" >&2
+	else
+		echo "Module '$2' errored" >&2
+		failed=1
+	fi
+}
+
+`)
+	s.WriteString(text)
+	s.WriteString("exit \"$failed\"\n")
+	return s.String(), body.warnings
+}
+
+// module writes the functions running task, one per matrix instance, and
+// their calls.
+func (s *shellScript) module(n int, task Task, vars map[string]string) {
+	vars = taskVars(task, vars)
+	fmt.Fprintf(s, "# Module: %s\n", task.Name)
+	if task.Description != "" {
+		fmt.Fprintf(s, "# %s\n", strings.ReplaceAll(task.Description, "\n", "\n# "))
+	}
+
+	if task.When != "" {
+		ok, err := evalCondition(task.When, vars)
+		switch {
+		case err != nil:
+			s.warn("module %s: when: %v, exported without its condition", task.Name, err)
+		case !ok:
+			fmt.Fprintf(s, "# Skipped, when: %s\n\n", task.When)
+			return
+		}
+	}
+	var unsupported string
+	switch {
+	case task.Workflow != "":
+		unsupported = "sub-workflows"
+	case task.Service:
+		unsupported = "services"
+	case task.Chunks > 0:
+		unsupported = "chunks"
+	}
+	if unsupported != "" {
+		s.warn("module %s: %s can't be exported and were left out", task.Name, unsupported)
+		fmt.Fprintf(s, "# Left out, %s can't be exported\n\n", unsupported)
+		return
+	}
+	for _, feature := range []struct {
+		set  bool
+		name string
+	}{
+		{task.WaitFor != nil, "wait_for"},
+		{task.Window != nil, "allowed_window"},
+		{task.Retry != nil, "retry"},
+		{task.FailIf != "", "fail_if"},
+		{task.SkipIf != "", "skip_if"},
+		{task.OnlyIf != "", "only_if"},
+		{len(task.AllowedExit) > 0, "allowed_exit_codes"},
+		{len(task.SkipExit) > 0, "skip_exit_codes"},
+		{len(task.Extract) > 0, "extract"},
+		{task.Parser != "", "parser"},
+		{task.Limits != nil, "limits"},
+		{task.EnvClean, "env_clean"},
+	} {
+		if feature.set {
+			s.warn("module %s: %s is not exported", task.Name, feature.name)
+		}
+	}
+
+	always := ""
+	if task.AlwaysRun {
+		always = " always"
+	}
+	for i, inst := range expandMatrix(task.Matrix, vars) {
+		fn := fmt.Sprintf("module_%d_%s", n, shellName(task.Name))
+		name := task.Name
+		if inst.label != "" {
+			fn = fmt.Sprintf("%s_%d", fn, i+1)
+			name = fmt.Sprintf("%s [%s]", task.Name, inst.label)
+		}
+		s.function(fn, task, inst.vars)
+		fmt.Fprintf(s, "run %s %s%s\n\n", fn, shellQuote(name), always)
+	}
+}
+
+// function writes fn, running the hooks and commands of task in a subshell
+// stopping at the first failure. after hooks run in a subshell of their own,
+// as they run even when the module failed.
+func (s *shellScript) function(fn string, task Task, vars map[string]string) {
+	if task.ForeachFile != "" {
+		vars = withVar(vars, "ITEM", shellRefTo("ITEM"))
+	}
+	env := taskEnv(task, vars)
+	keys := sortedKeys(env)
+	setup := func() {
+		s.WriteString("\tset -e\n")
+		for _, key := range keys {
+			fmt.Fprintf(s, "\texport %s=%s\n", key, shellQuoteRefs(env[key]))
+		}
+	}
+
+	fmt.Fprintf(s, "%s() {\n\t(\n", fn)
+	setup()
+	if d := task.DelayBefore; d != "" {
+		s.sleep(task, d, vars)
+	}
+	for _, cmd := range task.Before {
+		s.command(task, cmd, vars)
+	}
+	if task.StdinStream != "" {
+		fmt.Fprintf(s, "\texec <\"$RAYDER_STREAMS/%s\"\n", shellName(task.StdinStream))
+	}
+	if task.Stream != "" {
+		fmt.Fprintf(s, "\texec >\"$RAYDER_STREAMS/%s\"\n", shellName(task.Stream))
+	}
+	if task.ForeachFile != "" {
+		fmt.Fprintf(s, "\twhile IFS= read -r ITEM || [ -n \"$ITEM\" ]; do\n")
+		s.WriteString("\tITEM=$(printf '%s' \"$ITEM\" | sed 's/^[[:space:]]*//; s/[[:space:]]*$//')\n")
+		s.WriteString("\t[ -n \"$ITEM\" ] || continue\n")
+	}
+	for _, cmd := range task.Cmds {
+		s.command(task, cmd, vars)
+	}
+	if task.ForeachFile != "" {
+		fmt.Fprintf(s, "\tdone <%s\n", shellQuoteRefs(replacePlaceholders(task.ForeachFile, vars)))
+	}
+	s.WriteString("\t)\n")
+	if len(task.After) == 0 && task.DelayAfter == "" {
+		s.WriteString("}\n")
+		return
+	}
+	s.WriteString("\tstatus=$?\n")
+	if len(task.After) > 0 {
+		s.WriteString("\t(\n")
+		setup()
+		for _, cmd := range task.After {
+			s.command(task, cmd, vars)
+		}
+		s.WriteString("\t)\n\tafter=$?\n\t[ \"$status\" -ne 0 ] || status=$after\n")
+	}
+	if task.DelayAfter != "" {
+		s.sleep(task, task.DelayAfter, vars)
+	}
+	s.WriteString("\treturn \"$status\"\n}\n")
+}
+
+// command writes cmd as a line of shell, or a comment when it is a built-in
+// step with no shell equivalent.
+func (s *shellScript) command(task Task, cmd Command, vars map[string]string) {
+	var line string
+	switch {
+	case len(cmd.Argv) > 0:
+		argv := make([]string, len(cmd.Argv))
+		for i, arg := range cmd.Argv {
+			argv[i] = shellQuoteRefs(replacePlaceholders(arg, vars))
+		}
+		line = strings.Join(argv, " ")
+	case cmd.Copy != nil:
+		line = fmt.Sprintf("cp %s %s", shellQuoteRefs(replacePlaceholders(cmd.Copy.Src, vars)), shellQuoteRefs(replacePlaceholders(cmd.Copy.Dest, vars)))
+	case cmd.Download != nil:
+		line = fmt.Sprintf("curl -fsSL -o %s %s", shellQuoteRefs(replacePlaceholders(cmd.Download.Dest, vars)), shellQuoteRefs(replacePlaceholders(cmd.Download.URL, vars)))
+	case cmd.HTTP != nil:
+		line = "curl -fsS -X " + httpMethod(cmd.HTTP.Method)
+		for _, key := range sortedKeys(cmd.HTTP.Headers) {
+			line += " -H " + shellQuoteRefs(key+": "+replacePlaceholders(cmd.HTTP.Headers[key], vars))
+		}
+		if cmd.HTTP.Body != "" {
+			line += " --data-binary " + shellQuoteRefs(replacePlaceholders(cmd.HTTP.Body, vars))
+		}
+		if cmd.HTTP.Output != "" {
+			line += " -o " + shellQuoteRefs(replacePlaceholders(cmd.HTTP.Output, vars))
+		}
+		line += " " + shellQuoteRefs(replacePlaceholders(cmd.HTTP.URL, vars))
+	case cmd.Sleep != "":
+		s.sleep(task, cmd.Sleep, vars)
+		return
+	case cmd.isBuiltin():
+		step := describeCommand(cmd, vars)
+		s.warn("module %s: %s can't be exported and was left out", task.Name, strings.Fields(step)[0])
+		fmt.Fprintf(s, "\t# Left out: %s\n", strings.ReplaceAll(step, "\n", " "))
+		return
+	default:
+		line = replacePlaceholders(cmd.Shell, vars)
+		if task.Shell != "" {
+			args := shellArgs(task.Shell)
+			for i, arg := range args {
+				args[i] = shellQuote(arg)
+			}
+			line = strings.Join(args, " ") + " " + shellQuoteRefs(line)
+		}
+	}
+
+	if in := cmd.Stdin; in != nil {
+		switch {
+		case in.File != "":
+			line += " <" + shellQuoteRefs(replacePlaceholders(in.File, vars))
+		case in.Var != "":
+			line = fmt.Sprintf("printf '%%s\\n' %s | %s", shellQuoteRefs(strings.TrimSuffix(vars[in.Var], "\n")), line)
+		default:
+			line = fmt.Sprintf("printf '%%s\\n' %s | %s", shellQuoteRefs(strings.TrimSuffix(replacePlaceholders(in.Text, vars), "\n")), line)
+		}
+	}
+
+	// Only the first line is indented, the next ones may be inside quotes
+	// or a heredoc.
+	s.WriteString("\t" + line + "\n")
+}
+
+func (s *shellScript) sleep(task Task, duration string, vars map[string]string) {
+	d, err := parseDuration(replacePlaceholders(duration, vars))
+	if err != nil {
+		s.warn("module %s: sleep %s: %v", task.Name, duration, err)
+		return
+	}
+	fmt.Fprintf(s, "\tsleep %d\n", int(math.Ceil(d.Seconds())))
+}
+
+// shellQuoteRefs quotes s as a single shell word in which the variables the
+// script resolves itself are still expanded.
+func shellQuoteRefs(s string) string {
+	quoted := shellRefs(shellQuote(s), true)
+	if strings.HasPrefix(quoted, `''"`) {
+		quoted = strings.TrimPrefix(quoted, `''`)
+	}
+	if strings.HasSuffix(quoted, `"''`) {
+		quoted = strings.TrimSuffix(quoted, `''`)
+	}
+	return quoted
+}
+
+// shellName turns name into something usable in a shell function or file
+// name.
+func shellName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func withVar(vars map[string]string, key, value string) map[string]string {
+	merged := make(map[string]string, len(vars)+1)
+	for k, v := range vars {
+		merged[k] = v
+	}
+	merged[key] = value
+	return merged
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Expressions are used by when and fail_if. They are deliberately small:
+// string, number and boolean literals, variables referenced by name, the
+// comparison operators, &&, ||, ! and parentheses, plus a few functions for
+// inspecting files and strings. Placeholders inside string literals are
+// substituted before evaluation.
+
+type exprToken struct {
+	kind  byte // 'i'dent, 's'tring, 'n'umber, 'o'perator
+	value string
+}
+
+type exprNode interface {
+	eval(env exprEnv) (interface{}, error)
+}
+
+type exprEnv struct {
+	vars map[string]string
+}
+
+type literalNode struct{ value interface{} }
+
+type identNode struct{ name string }
+
+type notNode struct{ operand exprNode }
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+type callNode struct {
+	name string
+	args []exprNode
+}
+
+// evalCondition evaluates expr against vars and reports whether it holds.
+func evalCondition(expr string, vars map[string]string) (bool, error) {
+	tokens, err := tokenizeExpr(expr)
+	if err != nil {
+		return false, err
+	}
+
+	p := &exprParser{tokens: tokens, vars: vars}
+	node, err := p.parseOr()
+	if err != nil {
+		return false, err
+	}
+	if p.pos < len(p.tokens) {
+		return false, fmt.Errorf("unexpected %q in expression", p.tokens[p.pos].value)
+	}
+
+	value, err := node.eval(exprEnv{vars: vars})
+	if err != nil {
+		return false, err
+	}
+	return truthy(value), nil
+}
+
+// checkFailIf fails a module whose fail_if condition holds after its
+// commands ran.
+func checkFailIf(task Task, vars map[string]string) error {
+	failed, err := evalCondition(task.FailIf, vars)
+	if err != nil {
+		return fmt.Errorf("fail_if: %w", err)
+	}
+	if failed {
+		return fmt.Errorf("fail_if condition met: %s", task.FailIf)
+	}
+	return nil
+}
+
+func tokenizeExpr(expr string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		r, size := utf8.DecodeRuneInString(expr[i:])
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			var sb strings.Builder
+			for end < len(expr) && expr[end] != c {
+				if expr[end] == '\\' && end+1 < len(expr) {
+					end++
+				}
+				sb.WriteByte(expr[end])
+				end++
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string in expression")
+			}
+			tokens = append(tokens, exprToken{'s', sb.String()})
+			i = end + 1
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(expr) && expr[i+1] >= '0' && expr[i+1] <= '9':
+			end := i + 1
+			for end < len(expr) && (expr[end] >= '0' && expr[end] <= '9' || expr[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, exprToken{'n', expr[i:end]})
+			i = end
+		case r == '_' || unicode.IsLetter(r):
+			end := i + size
+			for end < len(expr) {
+				r, size := utf8.DecodeRuneInString(expr[end:])
+				if r != '_' && r != '.' && r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				end += size
+			}
+			tokens = append(tokens, exprToken{'i', expr[i:end]})
+			i = end
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", ","} {
+				if strings.HasPrefix(expr[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q in expression", string(r))
+			}
+			tokens = append(tokens, exprToken{'o', op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+	vars   map[string]string
+}
+
+func (p *exprParser) peek(op string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == 'o' && p.tokens[p.pos].value == op
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.peek("||") {
+		p.pos++
+		var right exprNode
+		right, err = p.parseAnd()
+		left = &binaryNode{op: "||", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseComparison()
+	for err == nil && p.peek("&&") {
+		p.pos++
+		var right exprNode
+		right, err = p.parseComparison()
+		left = &binaryNode{op: "&&", left: left, right: right}
+	}
+	return left, err
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.peek(op) {
+			p.pos++
+			right, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return &binaryNode{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.peek("!") {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+
+	switch tok.kind {
+	case 's':
+		return &literalNode{replacePlaceholders(tok.value, p.vars)}, nil
+	case 'n':
+		n, ok := parseNumber(tok.value)
+		if !ok {
+			return nil, fmt.Errorf("invalid number %q in expression", tok.value)
+		}
+		return &literalNode{n}, nil
+	case 'i':
+		switch tok.value {
+		case "true":
+			return &literalNode{true}, nil
+		case "false":
+			return &literalNode{false}, nil
+		}
+		if !p.peek("(") {
+			return &identNode{tok.value}, nil
+		}
+		p.pos++
+		call := &callNode{name: tok.value}
+		for !p.peek(")") {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if p.peek(",") {
+				p.pos++
+				if p.peek(")") {
+					return nil, fmt.Errorf("expected an argument after ',' in call to %s", tok.value)
+				}
+			} else if !p.peek(")") {
+				return nil, fmt.Errorf("expected ',' or ')' in call to %s", tok.value)
+			}
+		}
+		p.pos++
+		return call, nil
+	}
+
+	if tok.value == "(" {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, fmt.Errorf("missing ')' in expression")
+		}
+		p.pos++
+		return node, nil
+	}
+	return nil, fmt.Errorf("unexpected %q in expression", tok.value)
+}
+
+func (n *literalNode) eval(exprEnv) (interface{}, error) { return n.value, nil }
+
+func (n *identNode) eval(env exprEnv) (interface{}, error) {
+	value, ok := env.vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("undefined variable %s", n.name)
+	}
+	return value, nil
+}
+
+func (n *notNode) eval(env exprEnv) (interface{}, error) {
+	value, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return !truthy(value), nil
+}
+
+func (n *binaryNode) eval(env exprEnv) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "&&":
+		if !truthy(left) {
+			return false, nil
+		}
+		right, err := n.right.eval(env)
+		return truthy(right), err
+	case "||":
+		if truthy(left) {
+			return true, nil
+		}
+		right, err := n.right.eval(env)
+		return truthy(right), err
+	}
+
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Values are compared as numbers when both sides are numeric, so
+	// variables (always strings) compare naturally with number literals.
+	var cmp int
+	lnum, lok := toNumber(left)
+	rnum, rok := toNumber(right)
+	switch {
+	case lok && rok:
+		cmp = compareFloats(lnum, rnum)
+	default:
+		cmp = strings.Compare(toString(left), toString(right))
+	}
+
+	switch n.op {
+	case "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+func (n *callNode) eval(env exprEnv) (interface{}, error) {
+	if n.name == "defined" {
+		if len(n.args) != 1 {
+			return nil, fmt.Errorf("defined expects 1 argument")
+		}
+		// defined takes a variable, not its value: defined(NAME) or
+		// defined("NAME").
+		name := ""
+		switch arg := n.args[0].(type) {
+		case *identNode:
+			name = arg.name
+		case *literalNode:
+			name = toString(arg.value)
+		}
+		_, exists := env.vars[name]
+		return exists, nil
+	}
+
+	args := make([]string, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = toString(value)
+	}
+
+	fn, ok := exprFuncs[n.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", n.name)
+	}
+	if fn.args != len(args) {
+		return nil, fmt.Errorf("%s expects %d argument(s)", n.name, fn.args)
+	}
+	return fn.call(args)
+}
+
+var exprFuncs = map[string]struct {
+	args int
+	call func(args []string) (interface{}, error)
+}{
+	"exists": {1, func(a []string) (interface{}, error) {
+		_, err := os.Stat(a[0])
+		return err == nil, nil
+	}},
+	"size": {1, func(a []string) (interface{}, error) {
+		info, err := os.Stat(a[0])
+		if err != nil {
+			return float64(0), nil
+		}
+		return float64(info.Size()), nil
+	}},
+	"lines": {1, func(a []string) (interface{}, error) { return countLines(a[0]) }},
+	"read": {1, func(a []string) (interface{}, error) {
+		data, err := os.ReadFile(a[0])
+		if err != nil {
+			return nil, err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}},
+	"len":        {1, func(a []string) (interface{}, error) { return float64(len(a[0])), nil }},
+	"contains":   {2, func(a []string) (interface{}, error) { return strings.Contains(a[0], a[1]), nil }},
+	"startsWith": {2, func(a []string) (interface{}, error) { return strings.HasPrefix(a[0], a[1]), nil }},
+	"endsWith":   {2, func(a []string) (interface{}, error) { return strings.HasSuffix(a[0], a[1]), nil }},
+	"matches": {2, func(a []string) (interface{}, error) {
+		re, err := regexp.Compile(a[1])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(a[0]), nil
+	}},
+}
+
+// countLines returns the number of non-empty lines in a file; a missing
+// file has none.
+func countLines(path string) (interface{}, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return float64(0), nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	n := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) != "" {
+			n++
+		}
+	}
+	return float64(n), scanner.Err()
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != "" && v != "0" && !strings.EqualFold(v, "false")
+	}
+	return false
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		return parseNumber(strings.TrimSpace(v))
+	}
+	return 0, false
+}
+
+// numberPattern matches decimal numbers, leaving out the NaN, Inf, hex and
+// underscore forms strconv.ParseFloat accepts too.
+var numberPattern = regexp.MustCompile(`^[-+]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][-+]?[0-9]+)?$`)
+
+func parseNumber(s string) (float64, bool) {
+	if !numberPattern.MatchString(s) {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	return n, err == nil
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	}
+	return ""
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
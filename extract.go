@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Extract pulls values out of an output file of a module once it has
+// completed, with a JSONPath over JSON or JSON Lines, a regular expression
+// over the lines of text, or a column of CSV, and stores them in a variable
+// of later modules, a list file, or both.
+type Extract struct {
+	From      string `yaml:"from"`
+	JSONPath  string `yaml:"jsonpath"`
+	Regex     string `yaml:"regex"`
+	CSVColumn string `yaml:"csv_column"`
+	Var       string `yaml:"var"`
+	File      string `yaml:"file"`
+	Unique    bool   `yaml:"unique"`
+
+	path []jsonPathStep
+	re   *regexp.Regexp
+}
+
+func (e *Extract) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Extract
+	var p plain
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	*e = Extract(p)
+
+	if e.From == "" {
+		return fmt.Errorf("extract needs from, the file to extract from")
+	}
+	if e.Var == "" && e.File == "" {
+		return fmt.Errorf("extract from %s needs var or file to store the values in", e.From)
+	}
+	var err error
+	switch {
+	case e.JSONPath != "" && e.Regex == "" && e.CSVColumn == "":
+		e.path, err = parseJSONPath(e.JSONPath)
+	case e.Regex != "" && e.JSONPath == "" && e.CSVColumn == "":
+		e.re, err = regexp.Compile(e.Regex)
+	case e.CSVColumn != "" && e.JSONPath == "" && e.Regex == "":
+	default:
+		return fmt.Errorf("extract from %s takes exactly one of jsonpath, regex or csv_column", e.From)
+	}
+	if err != nil {
+		return fmt.Errorf("extract from %s: %w", e.From, err)
+	}
+	return nil
+}
+
+// extractedVars holds the variables extracted during a run of a workflow.
+// Modules see the values extracted by modules that completed before they
+// started, so a module using one should require the module extracting it.
+type extractedVars struct {
+	mu   sync.Mutex
+	vars map[string]string
+}
+
+func (x *extractedVars) set(name, value string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.vars == nil {
+		x.vars = make(map[string]string)
+	}
+	x.vars[name] = value
+}
+
+// merge returns vars with the values extracted so far set over them.
+func (x *extractedVars) merge(vars map[string]string) map[string]string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if len(x.vars) == 0 {
+		return vars
+	}
+	merged := make(map[string]string, len(vars)+len(x.vars))
+	for key, value := range vars {
+		merged[key] = value
+	}
+	for key, value := range x.vars {
+		merged[key] = value
+	}
+	return merged
+}
+
+// runExtracts runs the extracts of a completed task. A variable gets the
+// values one per line; a file is written with one value per line.
+func runExtracts(task Task, vars map[string]string, cyan, yellow func(a ...interface{}) string) error {
+	for _, e := range task.Extract {
+		from := replacePlaceholders(e.From, vars)
+		values, err := e.values(from)
+		if err != nil {
+			return fmt.Errorf("extract from %s: %w", from, err)
+		}
+		if e.Unique {
+			values = uniqueStrings(values)
+		}
+
+		if e.File != "" {
+			file := replacePlaceholders(e.File, vars)
+			out, err := createFile(file)
+			if err != nil {
+				return fmt.Errorf("extract from %s: %w", from, err)
+			}
+			for _, value := range values {
+				fmt.Fprintln(out, value)
+			}
+			if err := out.Close(); err != nil {
+				return fmt.Errorf("extract from %s: %w", from, err)
+			}
+		}
+		if e.Var != "" && task.extracted != nil {
+			task.extracted.set(e.Var, strings.Join(values, "\n"))
+		}
+		logDebug("[%s] [%s] Module '%s' extracted %d values from %s\n", yellow(currentTime()), yellow("DEBUG"), cyan(task.Name), len(values), from)
+	}
+	return nil
+}
+
+// values returns what e extracts from the file at path.
+func (e *Extract) values(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case e.path != nil:
+		return extractJSON(data, e.path)
+	case e.re != nil:
+		return extractRegex(data, e.re), nil
+	}
+	return extractCSV(data, e.CSVColumn)
+}
+
+// extractJSON applies path to every JSON value in data, which covers both a
+// single document and JSON Lines.
+func extractJSON(data []byte, path []jsonPathStep) ([]string, error) {
+	var values []string
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	for {
+		var doc interface{}
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		for _, node := range applyJSONPath(doc, path) {
+			switch v := node.(type) {
+			case nil:
+			case string:
+				values = append(values, v)
+			case json.Number, bool:
+				values = append(values, fmt.Sprint(v))
+			default:
+				b, _ := json.Marshal(v)
+				values = append(values, string(b))
+			}
+		}
+	}
+	return values, nil
+}
+
+// extractRegex returns the matches of re in the lines of data: the first
+// capturing group if re has one, the whole match otherwise.
+func extractRegex(data []byte, re *regexp.Regexp) []string {
+	var values []string
+	for _, line := range strings.Split(string(data), "\n") {
+		for _, match := range re.FindAllStringSubmatch(strings.TrimRight(line, "\r"), -1) {
+			if len(match) > 1 {
+				values = append(values, match[1])
+			} else {
+				values = append(values, match[0])
+			}
+		}
+	}
+	return values
+}
+
+// extractCSV returns a column of the CSV in data, named by its header or,
+// in a file without one, numbered from 1.
+func extractCSV(data []byte, column string) ([]string, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	index := -1
+	for i, name := range records[0] {
+		if strings.TrimSpace(name) == column {
+			index = i
+		}
+	}
+	if index >= 0 {
+		records = records[1:]
+	} else if n, err := strconv.Atoi(column); err == nil && n >= 1 {
+		index = n - 1
+	} else {
+		return nil, fmt.Errorf("no column %q in the header", column)
+	}
+
+	var values []string
+	for _, record := range records {
+		if index < len(record) && strings.TrimSpace(record[index]) != "" {
+			values = append(values, strings.TrimSpace(record[index]))
+		}
+	}
+	return values, nil
+}
+
+// jsonPathStep is a step of a JSONPath: a member name, an array index (with
+// isIndex), or every element (with all).
+type jsonPathStep struct {
+	name    string
+	index   int
+	isIndex bool
+	all     bool
+}
+
+// parseJSONPath parses the subset of JSONPath covering output of the usual
+// tools: $.a.b, $.a[0], $.a[*].b, $.* and $['a b'].
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("invalid jsonpath %q, expected it to start with $", path)
+	}
+	rest := path[1:]
+	steps := []jsonPathStep{}
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			if name == "" {
+				return nil, fmt.Errorf("invalid jsonpath %q, empty member name", path)
+			}
+			if name == "*" {
+				steps = append(steps, jsonPathStep{all: true})
+			} else {
+				steps = append(steps, jsonPathStep{name: name})
+			}
+			rest = rest[end:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid jsonpath %q, unclosed [", path)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			if inner == "*" {
+				steps = append(steps, jsonPathStep{all: true})
+			} else if n, err := strconv.Atoi(inner); err == nil && n >= 0 {
+				steps = append(steps, jsonPathStep{index: n, isIndex: true})
+			} else if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				steps = append(steps, jsonPathStep{name: inner[1 : len(inner)-1]})
+			} else {
+				return nil, fmt.Errorf("invalid jsonpath %q, unsupported [%s]", path, inner)
+			}
+		default:
+			return nil, fmt.Errorf("invalid jsonpath %q at %q", path, rest)
+		}
+	}
+	return steps, nil
+}
+
+// applyJSONPath returns the nodes path selects in doc. Members and indexes
+// that don't exist select nothing.
+func applyJSONPath(doc interface{}, path []jsonPathStep) []interface{} {
+	nodes := []interface{}{doc}
+	for _, step := range path {
+		var next []interface{}
+		for _, node := range nodes {
+			switch v := node.(type) {
+			case map[string]interface{}:
+				if step.all {
+					keys := make([]string, 0, len(v))
+					for key := range v {
+						keys = append(keys, key)
+					}
+					sort.Strings(keys)
+					for _, key := range keys {
+						next = append(next, v[key])
+					}
+				} else if child, ok := v[step.name]; ok && !step.isIndex {
+					next = append(next, child)
+				}
+			case []interface{}:
+				switch {
+				case step.all:
+					next = append(next, v...)
+				case step.isIndex && step.index < len(v):
+					next = append(next, v[step.index])
+				}
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// uniqueStrings returns values without duplicates, keeping the first of each.
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
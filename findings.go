@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// StoredFinding is a finding in the findings store, which holds every
+// finding of every recorded run once, however often it was found.
+type StoredFinding struct {
+	Finding
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	FirstRun  string    `json:"first_run"`
+	LastRun   string    `json:"last_run"`
+	Runs      int       `json:"runs"` // how many runs found it
+}
+
+func findingsPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("locating home directory: %w", err)
+	}
+	return filepath.Join(home, ".rayder", "findings.json"), nil
+}
+
+// findingKey identifies what a finding is about, so the same port found by
+// nmap and masscan, or in two runs, is stored once. Hosts are identified by
+// their IP when it is known.
+func findingKey(f Finding) string {
+	target := strings.ToLower(firstNonEmpty(f.IP, f.Host))
+	switch f.Kind {
+	case findingPort:
+		return fmt.Sprintf("port|%s|%d/%s", target, f.Port, f.Protocol)
+	case findingURL:
+		return "url|" + f.URL
+	case findingVuln:
+		return fmt.Sprintf("vuln|%s|%s|%s", firstNonEmpty(f.ID, f.Name), firstNonEmpty(f.URL, target), strconv.Itoa(f.Port))
+	}
+	return f.Kind + "|" + target
+}
+
+// mergeFinding updates stored with the fields f knows and keeps the rest, so
+// the service nmap found survives masscan finding the port again.
+func mergeFinding(stored *Finding, f Finding) {
+	fields := []struct {
+		dst *string
+		src string
+	}{
+		{&stored.Host, f.Host}, {&stored.IP, f.IP}, {&stored.Protocol, f.Protocol},
+		{&stored.Service, f.Service}, {&stored.Product, f.Product}, {&stored.URL, f.URL},
+		{&stored.Name, f.Name}, {&stored.ID, f.ID}, {&stored.Severity, f.Severity},
+	}
+	for _, field := range fields {
+		if field.src != "" {
+			*field.dst = field.src
+		}
+	}
+	stored.Module, stored.Source = f.Module, f.Source
+}
+
+func loadFindings() ([]StoredFinding, error) {
+	path, err := findingsPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var findings []StoredFinding
+	if err := json.Unmarshal(data, &findings); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return findings, nil
+}
+
+// storeFindings adds the findings of run to the findings store. Runs ending
+// at the same time take turns, holding a lock next to the store.
+func storeFindings(run *RunRecord) error {
+	if len(run.Findings) == 0 {
+		return nil
+	}
+	path, err := findingsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	for attempt := 0; lockFile(lock) != nil; attempt++ {
+		if attempt == 100 {
+			return fmt.Errorf("the findings store is locked by another run")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	stored, err := loadFindings()
+	if err != nil {
+		return err
+	}
+	index := make(map[string]int, len(stored))
+	for i, f := range stored {
+		index[findingKey(f.Finding)] = i
+	}
+	for _, f := range run.Findings {
+		key := findingKey(f)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(stored)
+			stored = append(stored, StoredFinding{Finding: f, FirstSeen: run.Finished, LastSeen: run.Finished, FirstRun: run.ID, LastRun: run.ID, Runs: 1})
+			continue
+		}
+		mergeFinding(&stored[i].Finding, f)
+		if stored[i].LastRun != run.ID {
+			stored[i].Runs++
+		}
+		stored[i].LastSeen, stored[i].LastRun = run.Finished, run.ID
+	}
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// runFindingsCommand queries the findings store.
+func runFindingsCommand(args []string) int {
+	fs := flag.NewFlagSet("findings", flag.ExitOnError)
+	kind := fs.String("kind", "", "Only show findings of this kind: host, port, url or vuln")
+	host := fs.String("host", "", "Only show findings whose host, IP or URL contains this")
+	severity := fs.String("severity", "", "Only show vulns of these severities (comma separated)")
+	module := fs.String("module", "", "Only show findings of this module")
+	runID := fs.String("run", "", "Only show findings of this run (an ID, a prefix of one, or last)")
+	onlyNew := fs.Bool("new", false, "Only show findings first seen in the run given with -run, or the latest run")
+	since := fs.String("since", "", "Only show findings seen within this duration, e.g. 24h")
+	asJSON := fs.Bool("json", false, "Print the findings as JSON lines")
+	fs.Parse(args)
+
+	findings, err := loadFindings()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	var inRun map[string]bool
+	if *runID != "" {
+		runs, err := loadRuns()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		run, err := findRun(runs, *runID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		*runID = run.ID
+		inRun = make(map[string]bool, len(run.Findings))
+		for _, f := range run.Findings {
+			inRun[findingKey(f)] = true
+		}
+	} else if *onlyNew {
+		var latest time.Time
+		for _, f := range findings {
+			if f.LastSeen.After(latest) {
+				latest, *runID = f.LastSeen, f.LastRun
+			}
+		}
+	}
+	var after time.Time
+	if *since != "" {
+		d, err := parseDuration(*since)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: -since: %v\n", err)
+			return 2
+		}
+		after = time.Now().Add(-d)
+	}
+	severities := splitList(strings.ToLower(*severity))
+
+	var shown []StoredFinding
+	for _, f := range findings {
+		switch {
+		case *kind != "" && f.Kind != *kind,
+			*host != "" && !strings.Contains(strings.Join([]string{f.Host, f.IP, f.URL}, " "), *host),
+			len(severities) > 0 && !containsString(severities, strings.ToLower(f.Severity)),
+			*module != "" && f.Module != *module,
+			inRun != nil && !inRun[findingKey(f.Finding)],
+			*onlyNew && f.FirstRun != *runID,
+			f.LastSeen.Before(after):
+			continue
+		}
+		shown = append(shown, f)
+	}
+	sort.SliceStable(shown, func(i, j int) bool {
+		if shown[i].Kind != shown[j].Kind {
+			return findingOrder(shown[i].Kind) < findingOrder(shown[j].Kind)
+		}
+		return shown[i].String() < shown[j].String()
+	})
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, f := range shown {
+			enc.Encode(f)
+		}
+		return 0
+	}
+	if len(shown) == 0 {
+		fmt.Fprintln(os.Stderr, "No findings")
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tFINDING\tFIRST SEEN\tLAST SEEN\tRUNS")
+	for _, f := range shown {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", f.Kind, f, f.FirstSeen.Format("2006-01-02 15:04"), f.LastSeen.Format("2006-01-02 15:04"), f.Runs)
+	}
+	w.Flush()
+	return 0
+}
+
+// findingOrder sorts hosts before their ports, URLs and vulns.
+func findingOrder(kind string) int {
+	for i, k := range []string{findingHost, findingPort, findingURL, findingVuln} {
+		if k == kind {
+			return i
+		}
+	}
+	return 4
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runGraphCommand prints the dependency graph of a workflow as a Mermaid
+// flowchart or a Graphviz digraph.
+func runGraphCommand(args []string) int {
+	fs := flag.NewFlagSet("graph", flag.ExitOnError)
+	var files workflowList
+	fs.Var(&files, "w", "Path to the workflow YAML file (repeat or comma separate for several)")
+	format := fs.String("format", "mermaid", "Output format: mermaid or dot")
+	fs.Parse(args)
+
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: rayder graph -w workflow.yaml [-format mermaid|dot]")
+		return 2
+	}
+
+	config, err := loadWorkflows(files, false, verifyOptions{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	switch *format {
+	case "mermaid":
+		writeMermaid(os.Stdout, config.Tasks)
+	case "dot":
+		writeDot(os.Stdout, config.Tasks)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown format %q, expected mermaid or dot\n", *format)
+		return 2
+	}
+	return 0
+}
+
+// graphNodeIDs assigns every module a stable identifier that is valid in
+// both output formats, since module names may contain ':' and '-'.
+func graphNodeIDs(tasks []Task) map[string]string {
+	ids := make(map[string]string, len(tasks))
+	for i, task := range tasks {
+		ids[task.Name] = "m" + strconv.Itoa(i)
+	}
+	return ids
+}
+
+func writeMermaid(w io.Writer, tasks []Task) {
+	ids := graphNodeIDs(tasks)
+	node := func(task Task) string {
+		return fmt.Sprintf("%s[\"%s\"]", ids[task.Name], strings.ReplaceAll(task.Name, `"`, "#quot;"))
+	}
+
+	fmt.Fprintln(w, "flowchart TD")
+	for _, b := range stageBatches(tasks) {
+		if b.stage == "" {
+			fmt.Fprintf(w, "    %s\n", node(b.tasks[0]))
+			continue
+		}
+		fmt.Fprintf(w, "    subgraph stage_%s[\"stage: %s\"]\n", ids[b.tasks[0].Name], b.stage)
+		for _, task := range b.tasks {
+			fmt.Fprintf(w, "        %s\n", node(task))
+		}
+		fmt.Fprintln(w, "    end")
+	}
+	for _, task := range tasks {
+		for _, req := range requiredNames(task) {
+			if id, ok := ids[req]; ok {
+				fmt.Fprintf(w, "    %s --> %s\n", id, ids[task.Name])
+			}
+		}
+		for _, req := range task.OptionalReq {
+			if id, ok := ids[req]; ok {
+				fmt.Fprintf(w, "    %s -.-> %s\n", id, ids[task.Name])
+			}
+		}
+	}
+	for _, edge := range streamEdges(tasks) {
+		fmt.Fprintf(w, "    %s -. %s .-> %s\n", ids[edge.from], edge.stream, ids[edge.to])
+	}
+}
+
+func writeDot(w io.Writer, tasks []Task) {
+	ids := graphNodeIDs(tasks)
+
+	fmt.Fprintln(w, "digraph workflow {")
+	fmt.Fprintln(w, "    rankdir=TB;")
+	fmt.Fprintln(w, "    node [shape=box];")
+	for _, b := range stageBatches(tasks) {
+		indent := "    "
+		if b.stage != "" {
+			fmt.Fprintf(w, "    subgraph cluster_%s {\n", ids[b.tasks[0].Name])
+			fmt.Fprintf(w, "        label=%s;\n", strconv.Quote("stage: "+b.stage))
+			indent = "        "
+		}
+		for _, task := range b.tasks {
+			fmt.Fprintf(w, "%s%s [label=%s];\n", indent, ids[task.Name], strconv.Quote(task.Name))
+		}
+		if b.stage != "" {
+			fmt.Fprintln(w, "    }")
+		}
+	}
+	for _, task := range tasks {
+		for _, req := range requiredNames(task) {
+			if id, ok := ids[req]; ok {
+				fmt.Fprintf(w, "    %s -> %s;\n", id, ids[task.Name])
+			}
+		}
+		for _, req := range task.OptionalReq {
+			if id, ok := ids[req]; ok {
+				fmt.Fprintf(w, "    %s -> %s [style=dashed];\n", id, ids[task.Name])
+			}
+		}
+	}
+	for _, edge := range streamEdges(tasks) {
+		fmt.Fprintf(w, "    %s -> %s [style=dashed, label=%s];\n", ids[edge.from], ids[edge.to], strconv.Quote(edge.stream))
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// streamEdge connects a module streaming its output to one reading it.
+type streamEdge struct {
+	from, to, stream string
+}
+
+// streamEdges returns the stream connections between tasks, drawn dashed
+// as the modules run alongside each other rather than one after the other.
+func streamEdges(tasks []Task) []streamEdge {
+	producers := make(map[string]string)
+	for _, task := range tasks {
+		if task.Stream != "" {
+			producers[task.Stream] = task.Name
+		}
+	}
+	var edges []streamEdge
+	for _, task := range tasks {
+		if producer, ok := producers[task.StdinStream]; ok {
+			edges = append(edges, streamEdge{from: producer, to: task.Name, stream: task.StdinStream})
+		}
+	}
+	return edges
+}
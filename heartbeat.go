@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultHeartbeat applies to modules whose workflow sets no heartbeat; set
+// with -heartbeat.
+var defaultHeartbeat time.Duration
+
+// heartbeatInterval returns how long a command of task may stay silent
+// before a heartbeat is logged, or 0 for no heartbeats.
+func (t Task) heartbeatInterval(vars map[string]string) (time.Duration, error) {
+	if t.Heartbeat == "" {
+		return defaultHeartbeat, nil
+	}
+	d, err := parseDuration(replacePlaceholders(t.Heartbeat, vars))
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid heartbeat %q", replacePlaceholders(t.Heartbeat, vars))
+	}
+	return d, nil
+}
+
+// checkHeartbeat rejects a heartbeat that isn't a duration, which would
+// otherwise turn heartbeats off. Values with placeholders are checked when
+// the module runs.
+func checkHeartbeat(heartbeat string) error {
+	if heartbeat == "" || strings.Contains(heartbeat, "{{") {
+		return nil
+	}
+	if d, err := parseDuration(heartbeat); err != nil || d < 0 {
+		return fmt.Errorf("invalid heartbeat %q, expected a duration such as 5m", heartbeat)
+	}
+	return nil
+}
+
+// heartbeat logs that a command is still running whenever it has produced
+// no output for an interval, so a slow module can be told from a hung one.
+type heartbeat struct {
+	taskName string
+	interval time.Duration
+	started  time.Time
+	done     chan struct{}
+
+	mu   sync.Mutex
+	last time.Time // of the last output
+	beat time.Time // of the last heartbeat
+}
+
+func newHeartbeat(taskName string, interval time.Duration) *heartbeat {
+	now := time.Now()
+	return &heartbeat{taskName: taskName, interval: interval, started: now, last: now, done: make(chan struct{})}
+}
+
+// writer returns w noting the time of each write; a nil w discards.
+func (h *heartbeat) writer(w io.Writer) io.Writer {
+	if w == nil {
+		return h
+	}
+	return io.MultiWriter(w, h)
+}
+
+func (h *heartbeat) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last = time.Now()
+	return len(p), nil
+}
+
+// watch logs heartbeats for cmd until stop is called. The CPU time and
+// memory of the command's process tree are included where they can be read.
+func (h *heartbeat) watch(cmd *exec.Cmd, yellow, cyan func(a ...interface{}) string) {
+	for {
+		h.mu.Lock()
+		next := h.last
+		if h.beat.After(next) {
+			next = h.beat
+		}
+		h.mu.Unlock()
+
+		select {
+		case <-h.done:
+			return
+		case <-time.After(time.Until(next.Add(h.interval))):
+		}
+
+		h.mu.Lock()
+		silent := time.Since(h.last)
+		due := silent >= h.interval && time.Since(h.beat) >= h.interval
+		if due {
+			h.beat = time.Now()
+		}
+		h.mu.Unlock()
+		if !due {
+			continue
+		}
+
+		usage := ""
+		if pid := control.pid(cmd); pid > 0 {
+			if cpu, rss, ok := processUsage(pid); ok {
+				usage = fmt.Sprintf(", cpu %s, memory %s", cpu.Round(time.Second), formatBytes(rss))
+			}
+		}
+		logLifecycle("[%s] [%s] Module '%s' still running after %s, no output for %s%s\n", yellow(currentTime()), yellow("INFO"), cyan(h.taskName), time.Since(h.started).Round(time.Second), silent.Round(time.Second), usage)
+	}
+}
+
+func (h *heartbeat) stop() {
+	close(h.done)
+}
+
+// formatBytes renders n in binary units.
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Module statuses recorded in the run history.
+const (
+	statusCompleted = "completed"
+	statusErrored   = "errored"
+	statusSkipped   = "skipped"
+	statusCancelled = "cancelled"
+)
+
+// RunRecord is the history entry of one run, stored in the SQLite database
+// ~/.rayder/history.db.
+type RunRecord struct {
+	ID        string            `json:"id"`
+	Workflows []string          `json:"workflows"`
+	Vars      map[string]string `json:"vars"`
+	Started   time.Time         `json:"started"`
+	Finished  time.Time         `json:"finished"`
+	Status    string            `json:"status"`
+	Modules   []ModuleRecord    `json:"modules"`
+	Artifacts []string          `json:"artifacts,omitempty"`
+	Findings  []Finding         `json:"findings,omitempty"`
+
+	// Snapshots maps artifact paths to copies taken when the run ended,
+	// relative to the history directory.
+	Snapshots map[string]string `json:"snapshots,omitempty"`
+}
+
+type ModuleRecord struct {
+	Name      string        `json:"name"`
+	Status    string        `json:"status"`
+	Started   time.Time     `json:"started"`
+	Duration  time.Duration `json:"duration"`
+	Artifacts []string      `json:"artifacts,omitempty"`
+
+	CPU        time.Duration `json:"cpu,omitempty"`
+	PeakMemory uint64        `json:"peak_memory,omitempty"`
+	Written    uint64        `json:"written,omitempty"`
+}
+
+// currentRun collects the record of the run in progress. It is only written
+// to the history when save is set.
+var currentRun struct {
+	sync.Mutex
+	record *RunRecord
+	save   bool
+}
+
+func historyDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("locating home directory: %w", err)
+	}
+	return filepath.Join(home, ".rayder", "history"), nil
+}
+
+// startHistory begins recording a run. Secret variables are stored masked.
+func startHistory(workflows []string, vars map[string]string, save bool) {
+	b := make([]byte, 3)
+	rand.Read(b)
+	started := time.Now()
+
+	masked := make(map[string]string, len(vars))
+	for key, value := range vars {
+		if isSecretVar(key) {
+			value = "****"
+		}
+		masked[key] = value
+	}
+
+	currentRun.Lock()
+	defer currentRun.Unlock()
+	currentRun.save = save
+	currentRun.record = &RunRecord{
+		ID:        started.Format("20060102-150405") + "-" + hex.EncodeToString(b),
+		Workflows: workflows,
+		Vars:      masked,
+		Started:   started,
+	}
+	if save && database != nil {
+		database.recordRun(*currentRun.record)
+	}
+}
+
+// currentRunID returns the ID of the run in progress.
+func currentRunID() string {
+	currentRun.Lock()
+	defer currentRun.Unlock()
+	if currentRun.record == nil {
+		return ""
+	}
+	return currentRun.record.ID
+}
+
+// recordModule records the outcome of a module. A module already recorded
+// as skipped stays skipped.
+func recordModule(name, status string, started time.Time, artifacts []string) {
+	currentRun.Lock()
+	run := currentRun.record
+	if run == nil {
+		currentRun.Unlock()
+		return
+	}
+
+	usage := usageOf(name)
+	module := ModuleRecord{
+		Name:       name,
+		Status:     status,
+		Started:    started,
+		Duration:   time.Since(started),
+		Artifacts:  artifacts,
+		CPU:        usage.cpu,
+		PeakMemory: usage.peakMemory,
+		Written:    usage.written,
+	}
+	found := false
+	for i := range run.Modules {
+		if run.Modules[i].Name == name {
+			if run.Modules[i].Status != statusSkipped {
+				run.Modules[i].Status = status
+				run.Modules[i].Duration = module.Duration
+				run.Modules[i].CPU = module.CPU
+				run.Modules[i].PeakMemory = module.PeakMemory
+				run.Modules[i].Written = module.Written
+			} else {
+				module.Status = statusSkipped
+			}
+			found = true
+			break
+		}
+	}
+	if !found {
+		run.Modules = append(run.Modules, module)
+		run.Artifacts = append(run.Artifacts, artifacts...)
+	}
+	id, save := run.ID, currentRun.save
+	currentRun.Unlock()
+
+	progress.moduleFinished(name, module.Status, module.Duration)
+	journal.moduleFinished(name, module.Status, module.Duration)
+	tui.moduleFinished(name, module.Status, module.Duration)
+
+	// The database is written outside the lock, so modules finishing at the
+	// same time don't wait for each other's round trips.
+	if save && database != nil {
+		database.recordModule(id, module)
+	}
+}
+
+// moduleStatus returns the status recorded for the module name, or "".
+func moduleStatus(name string) string {
+	currentRun.Lock()
+	defer currentRun.Unlock()
+	if currentRun.record == nil {
+		return ""
+	}
+	for _, module := range currentRun.record.Modules {
+		if module.Name == name {
+			return module.Status
+		}
+	}
+	return ""
+}
+
+// recordFindings adds the findings a module's parser produced to the run.
+func recordFindings(findings []Finding) {
+	currentRun.Lock()
+	defer currentRun.Unlock()
+	if currentRun.record != nil {
+		currentRun.record.Findings = append(currentRun.record.Findings, findings...)
+	}
+}
+
+// finishHistory completes the run record and returns it, storing it in the
+// history unless that is disabled.
+func finishHistory(ok bool) (*RunRecord, error) {
+	currentRun.Lock()
+	defer currentRun.Unlock()
+	run := currentRun.record
+	if run == nil {
+		return nil, nil
+	}
+
+	run.Finished = time.Now()
+	switch {
+	case control.isCancelled() && !control.failedFast():
+		run.Status = statusCancelled
+	case !ok:
+		run.Status = statusErrored
+	default:
+		run.Status = statusCompleted
+	}
+	if !currentRun.save {
+		return run, nil
+	}
+	if database != nil {
+		database.recordRun(*run)
+	}
+
+	dir, err := historyDir()
+	if err != nil {
+		return run, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return run, err
+	}
+	if err := snapshotArtifacts(dir, run); err != nil {
+		return run, err
+	}
+	if err := storeFindings(run); err != nil {
+		return run, fmt.Errorf("storing findings: %w", err)
+	}
+	db, err := openHistory()
+	if err != nil {
+		return run, err
+	}
+	defer db.Close()
+	if err := saveRun(db, run); err != nil {
+		return run, fmt.Errorf("storing the run: %w", err)
+	}
+	return run, nil
+}
+
+// snapshotArtifacts copies the artifacts of run into its own directory below
+// dir, so later runs overwriting them can still be compared against them.
+// Artifacts that weren't produced are left out.
+func snapshotArtifacts(dir string, run *RunRecord) error {
+	for i, path := range run.Artifacts {
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("snapshotting artifact: %w", err)
+		}
+
+		name := filepath.Join(run.ID, fmt.Sprintf("%d-%s", i, filepath.Base(path)))
+		if err := os.MkdirAll(filepath.Join(dir, run.ID), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return err
+		}
+		if run.Snapshots == nil {
+			run.Snapshots = make(map[string]string)
+		}
+		run.Snapshots[path] = name
+	}
+	return nil
+}
+
+// loadRuns returns the stored runs, most recent first.
+func loadRuns() ([]RunRecord, error) {
+	return loadRunsOf("", 0)
+}
+
+// loadRunsOf returns the stored runs of workflow, or of all workflows when it
+// is empty, most recent first and no more than limit unless it is 0.
+func loadRunsOf(workflow string, limit int) ([]RunRecord, error) {
+	db, err := openHistory()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return queryRuns(db, workflow, limit)
+}
+
+// historyRuns returns the runs of the configured database, or of the local
+// history when there is none or local is set.
+func historyRuns(local bool) ([]RunRecord, error) {
+	if local || databaseURL() == "" {
+		return loadRuns()
+	}
+	db, err := openDatabase(databaseURL())
+	if err != nil {
+		return nil, err
+	}
+	return db.loadRuns()
+}
+
+// runDuration returns how long run took, or "-" while it is still running.
+func runDuration(run RunRecord) string {
+	if run.Finished.IsZero() {
+		return "-"
+	}
+	return run.Finished.Sub(run.Started).Round(time.Second).String()
+}
+
+// findRun returns the run with the given ID, a unique prefix of one, or
+// "last" for the most recent run.
+func findRun(runs []RunRecord, id string) (RunRecord, error) {
+	if id == "last" && len(runs) > 0 {
+		return runs[0], nil
+	}
+
+	var matches []RunRecord
+	for _, run := range runs {
+		if run.ID == id {
+			return run, nil
+		}
+		if strings.HasPrefix(run.ID, id) {
+			matches = append(matches, run)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return RunRecord{}, fmt.Errorf("no run %q in the history", id)
+	case 1:
+		return matches[0], nil
+	}
+	return RunRecord{}, fmt.Errorf("run ID %q is ambiguous, it matches %d runs", id, len(matches))
+}
+
+func runHistoryCommand(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	limit := fs.Int("n", 20, "Number of runs to show, 0 for all")
+	workflow := fs.String("w", "", "Only show runs of this workflow")
+	local := fs.Bool("local", false, "Read the local history even when a database is configured")
+	fs.Parse(args)
+
+	var runs []RunRecord
+	var err error
+	if *local || databaseURL() == "" {
+		runs, err = loadRunsOf(*workflow, *limit)
+	} else {
+		runs, err = historyRuns(false)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	shown := 0
+	for _, run := range runs {
+		if *workflow != "" && !containsString(run.Workflows, *workflow) {
+			continue
+		}
+		if *limit > 0 && shown == *limit {
+			break
+		}
+		shown++
+		fmt.Printf("%s  %s  %-9s  %8s  %s\n", run.ID, run.Started.Format("2006-01-02 15:04:05"), run.Status,
+			runDuration(run), strings.Join(run.Workflows, ","))
+	}
+	if shown == 0 {
+		fmt.Fprintln(os.Stderr, "No runs recorded yet")
+	}
+	return 0
+}
+
+func runShowCommand(args []string) int {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the run record as JSON")
+	local := fs.Bool("local", false, "Read the local history even when a database is configured")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: rayder show [-json] [-local] <run-id|last>")
+		return 2
+	}
+
+	runs, err := historyRuns(*local)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	run, err := findRun(runs, fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		data, _ := json.MarshalIndent(run, "", "  ")
+		fmt.Println(string(data))
+		return 0
+	}
+
+	fmt.Printf("Run:       %s\n", run.ID)
+	fmt.Printf("Workflows: %s\n", strings.Join(run.Workflows, ", "))
+	fmt.Printf("Started:   %s\n", run.Started.Format("2006-01-02 15:04:05"))
+	fmt.Printf("Duration:  %s\n", runDuration(run))
+	fmt.Printf("Status:    %s\n", run.Status)
+
+	var names []string
+	for name := range run.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		fmt.Println("\nVariables:")
+		for _, name := range names {
+			fmt.Printf("  %s=%s\n", name, run.Vars[name])
+		}
+	}
+
+	fmt.Println("\nModules:")
+	for _, module := range run.Modules {
+		fmt.Printf("  %-9s  %8s  %s\n", module.Status, module.Duration.Round(time.Millisecond), module.Name)
+		if module.CPU > 0 || module.PeakMemory > 0 {
+			fmt.Printf("             cpu %s, peak memory %s, written %s\n", module.CPU.Round(time.Millisecond), formatBytes(module.PeakMemory), formatBytes(module.Written))
+		}
+		for _, artifact := range module.Artifacts {
+			fmt.Printf("             %s\n", artifact)
+		}
+	}
+
+	if len(run.Findings) > 0 {
+		fmt.Println("\nFindings:")
+		for _, finding := range run.Findings {
+			fmt.Printf("  %-5s  %s (%s)\n", finding.Kind, finding, finding.Module)
+		}
+	}
+	return 0
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// taskArtifacts resolves the artifact paths a module declares.
+func taskArtifacts(task Task, vars map[string]string) []string {
+	if len(task.Artifacts) == 0 {
+		return nil
+	}
+	vars = taskVars(task, vars)
+	paths := make([]string, len(task.Artifacts))
+	for i, artifact := range task.Artifacts {
+		path := replacePlaceholders(artifact, vars)
+		if !filepath.IsAbs(path) {
+			if abs, err := filepath.Abs(path); err == nil {
+				path = abs
+			}
+		}
+		paths[i] = path
+	}
+	return paths
+}
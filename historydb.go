@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// The local run history is a SQLite database, ~/.rayder/history.db, so past
+// runs can be queried with rayder history or any SQLite client. Artifact
+// snapshots stay files in ~/.rayder/history/<id>/.
+const historySchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id        TEXT PRIMARY KEY,
+	workflows TEXT NOT NULL, -- JSON array
+	vars      TEXT NOT NULL, -- JSON object, secrets masked
+	started   TEXT NOT NULL, -- UTC, RFC 3339 with nanoseconds
+	finished  TEXT,
+	status    TEXT NOT NULL,
+	record    TEXT NOT NULL  -- the whole RunRecord as JSON
+);
+CREATE INDEX IF NOT EXISTS runs_started ON runs (started);
+CREATE TABLE IF NOT EXISTS modules (
+	run_id      TEXT NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+	name        TEXT NOT NULL,
+	status      TEXT NOT NULL,
+	started     TEXT NOT NULL,
+	duration_ms INTEGER NOT NULL,
+	cpu_ms      INTEGER NOT NULL DEFAULT 0,
+	peak_memory INTEGER NOT NULL DEFAULT 0,
+	written     INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS modules_run ON modules (run_id);
+CREATE TABLE IF NOT EXISTS artifacts (
+	run_id   TEXT NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+	module   TEXT NOT NULL,
+	path     TEXT NOT NULL,
+	snapshot TEXT
+);
+CREATE INDEX IF NOT EXISTS artifacts_run ON artifacts (run_id);
+`
+
+// openHistory opens the history database, creating it when needed.
+func openHistory() (*sql.DB, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	// Several runs may finish at once; they wait for each other's writes.
+	db, err := sql.Open("sqlite", filepath.Join(filepath.Dir(dir), "history.db")+"?_pragma=busy_timeout(10000)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening the history: %w", err)
+	}
+	return db, nil
+}
+
+// saveRun stores run, replacing an earlier record of it.
+func saveRun(db *sql.DB, run *RunRecord) error {
+	workflows, _ := json.Marshal(run.Workflows)
+	vars, _ := json.Marshal(run.Vars)
+	record, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	var finished interface{}
+	if !run.Finished.IsZero() {
+		finished = historyTime(run.Finished)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM runs WHERE id = ?`, run.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO runs (id, workflows, vars, started, finished, status, record) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		run.ID, string(workflows), string(vars), historyTime(run.Started), finished, run.Status, string(record)); err != nil {
+		return err
+	}
+	for _, module := range run.Modules {
+		if _, err := tx.Exec(`INSERT INTO modules (run_id, name, status, started, duration_ms, cpu_ms, peak_memory, written) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			run.ID, module.Name, module.Status, historyTime(module.Started), module.Duration.Milliseconds(),
+			module.CPU.Milliseconds(), int64(module.PeakMemory), int64(module.Written)); err != nil {
+			return err
+		}
+		for _, path := range module.Artifacts {
+			var snapshot interface{}
+			if name, ok := run.Snapshots[path]; ok {
+				snapshot = name
+			}
+			if _, err := tx.Exec(`INSERT INTO artifacts (run_id, module, path, snapshot) VALUES (?, ?, ?, ?)`, run.ID, module.Name, path, snapshot); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// historyTime formats t for the history database: in UTC and with a fixed
+// number of digits, so times sort as text.
+func historyTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+// queryRuns returns the stored runs, most recent first: those of workflow
+// when it isn't empty, and no more than limit of them when it is positive.
+func queryRuns(db *sql.DB, workflow string, limit int) ([]RunRecord, error) {
+	query := `SELECT record FROM runs`
+	var args []interface{}
+	if workflow != "" {
+		query += ` WHERE EXISTS (SELECT 1 FROM json_each(runs.workflows) WHERE value = ?)`
+		args = append(args, workflow)
+	}
+	query += ` ORDER BY started DESC`
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("reading the history: %w", err)
+	}
+	defer rows.Close()
+	var runs []RunRecord
+	for rows.Next() {
+		var record string
+		if err := rows.Scan(&record); err != nil {
+			return nil, err
+		}
+		var run RunRecord
+		if err := json.Unmarshal([]byte(record), &run); err != nil {
+			return nil, fmt.Errorf("reading the history: %w", err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
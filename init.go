@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// runInitCommand asks a few questions and writes a starter workflow with
+// variables, usage and a chain of modules.
+func runInitCommand(args []string) int {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	output := fs.String("o", "workflow.yaml", "Path of the workflow file to create")
+	defaults := fs.Bool("y", false, "Accept all defaults without prompting")
+	force := fs.Bool("force", false, "Overwrite an existing file")
+	fs.Parse(args)
+
+	if _, err := os.Stat(*output); err == nil && !*force {
+		fmt.Fprintf(os.Stderr, "Error: %s already exists, use -force to overwrite it\n", *output)
+		return 1
+	}
+
+	p := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stderr, defaults: *defaults}
+
+	description := p.ask("What does this workflow do?", "Recon workflow")
+	varNames := splitList(p.ask("Variables (comma separated)", "DOMAIN,OUTPUT_DIR"))
+	vars := make([][2]string, 0, len(varNames))
+	for _, name := range varNames {
+		def := ""
+		if name == "OUTPUT_DIR" {
+			def = "results"
+		}
+		vars = append(vars, [2]string{name, p.ask(fmt.Sprintf("Default value for %s (empty to require it)", name), def)})
+	}
+
+	moduleNames := splitList(p.ask("Module names (comma separated)", "subdomains,probe"))
+	target := "target"
+	if len(varNames) > 0 {
+		target = "{{" + varNames[0] + "}}"
+	}
+	cmds := make([]string, len(moduleNames))
+	for i, name := range moduleNames {
+		cmds[i] = p.ask(fmt.Sprintf("Command for %s", name), fmt.Sprintf("echo \"%s %s\"", name, target))
+	}
+	chain := p.confirm("Run the modules one after another (each requires the previous)?", true)
+
+	content := scaffoldWorkflow(description, vars, moduleNames, cmds, chain)
+
+	// Never write a file rayder itself would refuse to load.
+	var check Config
+	if err := yaml.UnmarshalStrict([]byte(content), &check); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: generated workflow is invalid: %v\n", err)
+		return 1
+	}
+
+	if err := os.WriteFile(*output, []byte(content), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Created %s, run it with: rayder -w %s%s\n", *output, *output, usageArgs(vars))
+	return 0
+}
+
+func scaffoldWorkflow(description string, vars [][2]string, modules, cmds []string, chain bool) string {
+	var b strings.Builder
+
+	b.WriteString("vars:\n")
+	for _, v := range vars {
+		if v[1] == "" {
+			fmt.Fprintf(&b, "  %s:\n    required: true\n", v[0])
+			continue
+		}
+		fmt.Fprintf(&b, "  %s: %s\n", v[0], yamlQuote(v[1]))
+	}
+	fmt.Fprintf(&b, "\nusage: %s\n", yamlQuote(description))
+
+	b.WriteString("\nmodules:\n")
+	for i, name := range modules {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "  - name: %s\n", name)
+		if chain && i > 0 {
+			fmt.Fprintf(&b, "    required: [%s]\n", modules[i-1])
+		}
+		b.WriteString("    cmds:\n")
+		fmt.Fprintf(&b, "      - %s\n", yamlQuote(cmds[i]))
+	}
+	return b.String()
+}
+
+// usageArgs renders the variable assignments of an example command line.
+func usageArgs(vars [][2]string) string {
+	var b strings.Builder
+	for _, v := range vars {
+		if v[1] == "" {
+			fmt.Fprintf(&b, " %s=<%s>", v[0], strings.ToLower(v[0]))
+		}
+	}
+	return b.String()
+}
+
+// yamlQuote renders s as a single-quoted YAML scalar.
+func yamlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+type prompter struct {
+	in       *bufio.Reader
+	out      io.Writer
+	defaults bool
+}
+
+// ask prints question with its default and returns the answer, or def when
+// the answer is empty, input has ended or defaults were requested.
+func (p *prompter) ask(question, def string) string {
+	if p.defaults {
+		return def
+	}
+
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, err := p.in.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Fprintln(p.out)
+		p.defaults = true
+		return def
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer
+	}
+	return def
+}
+
+func (p *prompter) confirm(question string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	answer := strings.ToLower(p.ask(question+" ("+hint+")", ""))
+	if answer == "" {
+		return def
+	}
+	return answer == "y" || answer == "yes"
+}
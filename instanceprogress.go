@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// instanceProgressInterval is how often at most the progress of a module
+// running several instances is logged.
+const instanceProgressInterval = 5 * time.Second
+
+// instanceProgress follows a module through its instances, counting those
+// that have finished, so a module iterating over a long targets file doesn't
+// look hung. It knows nothing of the tools' own output.
+type instanceProgress struct {
+	mu      sync.Mutex
+	name    string
+	total   int
+	done    int
+	failed  int
+	started time.Time
+	logged  time.Time
+
+	yellow, cyan func(a ...interface{}) string
+}
+
+func newInstanceProgress(name string, total int, yellow, cyan func(a ...interface{}) string) *instanceProgress {
+	now := time.Now()
+	tui.instanceProgress(name, 0, total)
+	return &instanceProgress{name: name, total: total, started: now, logged: now, yellow: yellow, cyan: cyan}
+}
+
+// finished counts an instance that has finished, logging the progress when
+// it wasn't for a while and once all instances have finished.
+func (p *instanceProgress) finished(failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if failed {
+		p.failed++
+	}
+	tui.instanceProgress(p.name, p.done, p.total)
+
+	if p.done < p.total && time.Since(p.logged) < instanceProgressInterval {
+		return
+	}
+	p.logged = time.Now()
+	note := ""
+	if p.failed > 0 {
+		note = fmt.Sprintf(", %d failed", p.failed)
+	}
+	logLifecycle("[%s] [%s] Module '%s' %s %d/%d instances%s%s\n", p.yellow(currentTime()), p.yellow("INFO"), p.cyan(p.name), progressBar(p.done, p.total, 20), p.done, p.total, note, rateNote(p.done, p.total, time.Since(p.started)))
+}
+
+// progressBar draws done out of total as a bar width characters wide.
+func progressBar(done, total, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = done * width / total
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "]"
+}
+
+// rateNote describes how fast the instances finish and when the last one is
+// expected to, assuming the rest take as long as the finished ones did.
+func rateNote(done, total int, elapsed time.Duration) string {
+	if done == 0 || elapsed <= 0 {
+		return ""
+	}
+	rate := float64(done) / elapsed.Seconds()
+	if done >= total {
+		return fmt.Sprintf(" (%.1f/s)", rate)
+	}
+	eta := time.Duration(float64(total-done) / rate * float64(time.Second))
+	return fmt.Sprintf(" (%.1f/s, ETA %s)", rate, roundEstimate(eta))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// instance is a single execution of a module together with the variables it
+// runs with. Modules without a matrix have exactly one unlabelled instance.
+type instance struct {
+	label string
+	vars  map[string]string
+}
+
+// expandInstances returns every instance a module runs as, combining its
+// matrix with the lines (or chunks) of its foreach_file. The returned cleanup
+// function removes any temporary chunk files and must be called once all
+// instances have finished.
+func expandInstances(task Task, vars map[string]string) ([]instance, func(), error) {
+	cleanup := func() {}
+	instances := expandMatrix(task.Matrix, vars)
+	if task.ForeachFile == "" {
+		return instances, cleanup, nil
+	}
+
+	lines, err := readLines(replacePlaceholders(task.ForeachFile, vars))
+	if err != nil {
+		return nil, cleanup, fmt.Errorf("reading foreach_file: %w", err)
+	}
+
+	key, items := "ITEM", lines
+	if task.Chunks > 0 {
+		dir, err := os.MkdirTemp("", "rayder-chunks-")
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("creating chunk directory: %w", err)
+		}
+		cleanup = func() { os.RemoveAll(dir) }
+
+		key = "CHUNK_FILE"
+		items, err = writeChunks(dir, lines, task.Chunks)
+		if err != nil {
+			cleanup()
+			return nil, func() {}, fmt.Errorf("writing chunks: %w", err)
+		}
+	}
+
+	var expanded []instance
+	for _, inst := range instances {
+		for _, item := range items {
+			instVars := make(map[string]string, len(inst.vars)+1)
+			for k, v := range inst.vars {
+				instVars[k] = v
+			}
+			instVars[key] = item
+
+			label := key + "=" + item
+			if task.Chunks > 0 {
+				label = key + "=" + filepath.Base(item)
+			}
+			if inst.label != "" {
+				label = inst.label + "," + label
+			}
+			expanded = append(expanded, instance{label: label, vars: instVars})
+		}
+	}
+	return expanded, cleanup, nil
+}
+
+// expandMatrix returns one instance per combination of matrix values. Keys
+// are expanded in sorted order so the instance order is stable across runs,
+// while values keep the order they were declared in.
+func expandMatrix(matrix map[string][]string, vars map[string]string) []instance {
+	instances := []instance{{vars: vars}}
+	if len(matrix) == 0 {
+		return instances
+	}
+
+	keys := make([]string, 0, len(matrix))
+	for key := range matrix {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var expanded []instance
+		for _, inst := range instances {
+			for _, value := range matrix[key] {
+				instVars := make(map[string]string, len(inst.vars)+1)
+				for k, v := range inst.vars {
+					instVars[k] = v
+				}
+				instVars[key] = replacePlaceholders(value, vars)
+
+				label := key + "=" + instVars[key]
+				if inst.label != "" {
+					label = inst.label + "," + label
+				}
+				expanded = append(expanded, instance{label: label, vars: instVars})
+			}
+		}
+		instances = expanded
+	}
+
+	return instances
+}
+
+// readLines returns the non-empty, trimmed lines of the named file.
+func readLines(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// writeChunks splits lines into at most n contiguous chunk files inside dir
+// and returns their paths. Fewer files are written when there are fewer
+// lines than chunks.
+func writeChunks(dir string, lines []string, n int) ([]string, error) {
+	size := (len(lines) + n - 1) / n
+
+	var files []string
+	for i := 0; i*size < len(lines); i++ {
+		end := (i + 1) * size
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		name := filepath.Join(dir, fmt.Sprintf("chunk-%03d.txt", i+1))
+		content := strings.Join(lines[i*size:end], "\n") + "\n"
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			return nil, err
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// journal logs the lifecycle of the run to the system log, for runs from
+// cron or a scheduler whose stderr nobody reads. It is nil unless
+// -log-target is syslog.
+var journal *runJournal
+
+// syslogWriter is the part of a syslog connection the journal uses.
+type syslogWriter interface {
+	Info(m string) error
+	Notice(m string) error
+	Warning(m string) error
+	Err(m string) error
+	Close() error
+}
+
+type runJournal struct {
+	w     syslogWriter
+	runID string
+}
+
+// openJournal returns a journal for target, the value of -log-target, which
+// is nil when the lifecycle only goes to stderr.
+func openJournal(target string) (*runJournal, error) {
+	switch target {
+	case "", "stderr":
+		return nil, nil
+	case "syslog":
+		w, err := openSyslog()
+		if err != nil {
+			return nil, err
+		}
+		return &runJournal{w: w}, nil
+	}
+	return nil, fmt.Errorf("unknown log target %q, want stderr or syslog", target)
+}
+
+func (j *runJournal) runStarted(runID string, workflows []string) {
+	if j == nil {
+		return
+	}
+	j.runID = runID
+	j.w.Notice(fmt.Sprintf("run %s: started %v", runID, workflows))
+}
+
+func (j *runJournal) moduleStarted(name string) {
+	if j == nil {
+		return
+	}
+	j.w.Info(fmt.Sprintf("run %s: module %s started", j.runID, name))
+}
+
+// moduleFinished logs the outcome of a module with the priority it
+// deserves: errors as errors, cancelled modules as warnings.
+func (j *runJournal) moduleFinished(name, status string, duration time.Duration) {
+	if j == nil {
+		return
+	}
+	msg := fmt.Sprintf("run %s: module %s %s after %s", j.runID, name, status, duration.Round(time.Millisecond))
+	switch status {
+	case statusErrored:
+		j.w.Err(msg)
+	case statusCancelled:
+		j.w.Warning(msg)
+	case statusSkipped:
+		j.w.Notice(msg)
+	default:
+		j.w.Info(msg)
+	}
+}
+
+func (j *runJournal) runFinished(status string, duration time.Duration) {
+	if j == nil {
+		return
+	}
+	msg := fmt.Sprintf("run %s: %s after %s", j.runID, status, duration.Round(time.Second))
+	switch status {
+	case statusErrored:
+		j.w.Err(msg)
+	case statusCancelled:
+		j.w.Warning(msg)
+	default:
+		j.w.Notice(msg)
+	}
+	j.w.Close()
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// junitReport is the file -report-junit writes the run to.
+var junitReport string
+
+// junitOutputLines is how much of its output a module keeps for the report.
+const junitOutputLines = 500
+
+// moduleOutput keeps the end of the output of every module while
+// -report-junit is given.
+var moduleOutput struct {
+	sync.Mutex
+	buffers map[string]*tailBuffer
+}
+
+// outputCapture returns the writer keeping the output of the module name,
+// or nil when no report needs it.
+func outputCapture(name string) io.Writer {
+	if junitReport == "" {
+		return nil
+	}
+	moduleOutput.Lock()
+	defer moduleOutput.Unlock()
+	if moduleOutput.buffers == nil {
+		moduleOutput.buffers = make(map[string]*tailBuffer)
+	}
+	buffer, ok := moduleOutput.buffers[name]
+	if !ok {
+		buffer = newTailBuffer(junitOutputLines)
+		moduleOutput.buffers[name] = buffer
+	}
+	return buffer
+}
+
+func capturedOutput(name string) string {
+	moduleOutput.Lock()
+	buffer := moduleOutput.buffers[name]
+	moduleOutput.Unlock()
+	if buffer == nil {
+		return ""
+	}
+	return strings.Join(buffer.Lines(), "\n")
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes run as a JUnit XML report, for CI systems to show in
+// their test views: one test suite for the run and one test case per module,
+// failed when the module errored and skipped when it didn't run, with the
+// last lines of its output.
+func writeJUnit(path string, run RunRecord) error {
+	names := make([]string, len(run.Workflows))
+	for i, workflow := range run.Workflows {
+		names[i] = strings.TrimSuffix(filepath.Base(workflow), filepath.Ext(workflow))
+	}
+	suite := junitTestSuite{
+		Name:      strings.Join(names, ","),
+		Time:      seconds(run.Finished.Sub(run.Started).Seconds()),
+		Timestamp: run.Started.Format("2006-01-02T15:04:05"),
+	}
+	for _, module := range run.Modules {
+		tc := junitTestCase{
+			ClassName: "rayder." + suite.Name,
+			Name:      module.Name,
+			Time:      seconds(module.Duration.Seconds()),
+			SystemOut: capturedOutput(module.Name),
+		}
+		switch module.Status {
+		case statusErrored:
+			tc.Failure = &junitMessage{Message: "Module '" + module.Name + "' errored", Text: lastLines(tc.SystemOut, 20)}
+			suite.Failures++
+		case statusSkipped, statusCancelled:
+			tc.Skipped = &junitMessage{Message: "Module '" + module.Name + "' " + module.Status}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Tests = len(suite.Cases)
+
+	report := junitTestSuites{
+		Name:     "rayder " + run.ID,
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	}
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
+
+func seconds(s float64) string {
+	return fmt.Sprintf("%.3f", s)
+}
+
+// lastLines returns the last n lines of s.
+func lastLines(s string, n int) string {
+	lines := strings.Split(s, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
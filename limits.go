@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Limits caps the resources of each command of a module, so one greedy tool
+// can't take the machine and the rest of the workflow down with it. On Linux
+// CPU and memory are enforced with a cgroup when rayder may create one, and
+// otherwise memory and open files with rlimits set through prlimit.
+type Limits struct {
+	CPU    float64 `yaml:"cpu"`    // cores
+	Memory string  `yaml:"memory"` // such as 512MiB or 2GiB
+	NoFile uint64  `yaml:"nofile"`
+
+	memory uint64
+}
+
+func (l *Limits) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type limits Limits
+	if err := unmarshal((*limits)(l)); err != nil {
+		return err
+	}
+	if l.CPU < 0 {
+		return fmt.Errorf("invalid cpu limit %v", l.CPU)
+	}
+	if l.Memory != "" {
+		n, err := parseBytes(l.Memory)
+		if err != nil {
+			return fmt.Errorf("invalid memory limit: %w", err)
+		}
+		l.memory = n
+	}
+	return nil
+}
+
+// byteUnits are the suffixes parseBytes understands, longest first.
+var byteUnits = []struct {
+	suffix string
+	size   uint64
+}{
+	{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+	{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// parseBytes parses a size such as 2GiB, 512M or 1.5GB.
+func parseBytes(s string) (uint64, error) {
+	number, size := strings.TrimSpace(s), uint64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(strings.ToUpper(number), strings.ToUpper(unit.suffix)) {
+			number, size = strings.TrimSpace(number[:len(number)-len(unit.suffix)]), unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return uint64(n * float64(size)), nil
+}
@@ -0,0 +1,140 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// cgroupSeq numbers the cgroups created for commands.
+var cgroupSeq int64
+
+// applyLimits prepares cmd, which has not been started, to run within
+// limits, and returns a function to call once it has exited. CPU and memory
+// go into a new cgroup (v2) below rayder's own; that needs the cpu and
+// memory controllers delegated, as systemd does for scopes started with
+// Delegate=yes. Without one, memory is limited as address space, and cpu
+// can't be limited, which the returned error reports. The open file limit is
+// always an rlimit.
+func applyLimits(cmd *exec.Cmd, limits *Limits) (cleanup func(), err error) {
+	cleanup = func() {}
+	rlimitMemory := uint64(0)
+
+	if limits.CPU > 0 || limits.memory > 0 {
+		dir, cgErr := newCgroup(limits)
+		if cgErr == nil {
+			fd, openErr := syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+			if openErr == nil {
+				if cmd.SysProcAttr == nil {
+					cmd.SysProcAttr = &syscall.SysProcAttr{}
+				}
+				cmd.SysProcAttr.UseCgroupFD = true
+				cmd.SysProcAttr.CgroupFD = fd
+				cleanup = func() {
+					syscall.Close(fd)
+					// Fails while processes the command left behind still
+					// live in the cgroup; it is left for them then.
+					os.Remove(dir)
+				}
+			} else {
+				os.Remove(dir)
+				cgErr = openErr
+			}
+		}
+		if cgErr != nil {
+			rlimitMemory = limits.memory
+			if limits.CPU > 0 {
+				err = fmt.Errorf("cpu limit not applied, it needs a cgroup: %v", cgErr)
+			}
+		}
+	}
+
+	if limits.NoFile > 0 || rlimitMemory > 0 {
+		prlimit, lookErr := exec.LookPath("prlimit")
+		if lookErr != nil {
+			cleanup()
+			return func() {}, fmt.Errorf("limits not applied, they need a cgroup or prlimit, which was not found in PATH")
+		}
+		var args []string
+		if limits.NoFile > 0 {
+			args = append(args, fmt.Sprintf("--nofile=%d", limits.NoFile))
+		}
+		if rlimitMemory > 0 {
+			args = append(args, fmt.Sprintf("--as=%d", rlimitMemory))
+		}
+		wrapCommand(cmd, prlimit, args...)
+	}
+	return cleanup, err
+}
+
+// newCgroup creates a cgroup for one command below the cgroup rayder runs
+// in, with the CPU and memory limits set.
+func newCgroup(limits *Limits) (string, error) {
+	base, err := ownCgroup()
+	if err != nil {
+		return "", err
+	}
+
+	var controllers []string
+	if limits.CPU > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	if limits.memory > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	// Usually already enabled where cgroups are delegated; only possible
+	// here when rayder's cgroup holds no processes.
+	os.WriteFile(filepath.Join(base, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0644)
+
+	dir := filepath.Join(base, fmt.Sprintf("rayder-%d-%d", os.Getpid(), atomic.AddInt64(&cgroupSeq, 1)))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", err
+	}
+	settings := map[string]string{}
+	if limits.CPU > 0 {
+		settings["cpu.max"] = fmt.Sprintf("%d 100000", int64(limits.CPU*100000))
+	}
+	if limits.memory > 0 {
+		settings["memory.max"] = strconv.FormatUint(limits.memory, 10)
+	}
+	for file, value := range settings {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+			os.Remove(dir)
+			return "", err
+		}
+	}
+	if limits.memory > 0 {
+		// Without swap the limit ends in the OOM killer rather than in
+		// swapping the box to a crawl. Kernels without swap accounting
+		// don't have the file.
+		os.WriteFile(filepath.Join(dir, "memory.swap.max"), []byte("0"), 0644)
+	}
+	return dir, nil
+}
+
+// ownCgroup returns the directory of the cgroup v2 rayder runs in.
+func ownCgroup() (string, error) {
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil {
+		return "", fmt.Errorf("cgroup v2 is not mounted at /sys/fs/cgroup")
+	}
+	file, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if path := strings.TrimPrefix(scanner.Text(), "0::"); path != scanner.Text() {
+			return filepath.Join("/sys/fs/cgroup", path), nil
+		}
+	}
+	return "", fmt.Errorf("rayder is not in a cgroup v2")
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// applyLimits is only implemented on Linux.
+func applyLimits(cmd *exec.Cmd, limits *Limits) (cleanup func(), err error) {
+	return func() {}, errors.New("limits not applied, they are only supported on Linux")
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// listModules prints the modules of a workflow in the order they are
+// scheduled, followed by the tree of their required dependencies.
+func listModules(w io.Writer, tasks []Task, cyan, magenta, white, yellow func(a ...interface{}) string) {
+	fmt.Fprintln(w, "Modules in order of execution:")
+
+	n := 0
+	for _, b := range stageBatches(tasks) {
+		indent := "  "
+		if b.stage != "" {
+			fmt.Fprintf(w, "  Stage '%s' (started together):\n", magenta(b.stage))
+			indent = "    "
+		}
+		for _, task := range b.tasks {
+			n++
+			fmt.Fprintf(w, "%s%d. %s%s\n", indent, n, cyan(task.Name), yellow(moduleFlags(task)))
+			if task.Description != "" {
+				fmt.Fprintf(w, "%s   %s\n", indent, white(task.Description))
+			}
+			if len(task.Required) > 0 {
+				required := make([]string, len(task.Required))
+				for i, req := range task.Required {
+					required[i] = req.String()
+				}
+				fmt.Fprintf(w, "%s   requires: %s\n", indent, strings.Join(required, ", "))
+			}
+			if len(task.OptionalReq) > 0 {
+				fmt.Fprintf(w, "%s   after, if they run: %s\n", indent, strings.Join(task.OptionalReq, ", "))
+			}
+		}
+	}
+
+	fmt.Fprintln(w, "\nDependency tree:")
+	children := make(map[string][]string)
+	known := make(map[string]bool)
+	for _, task := range tasks {
+		known[task.Name] = true
+	}
+	var roots []string
+	for _, task := range tasks {
+		isRoot := true
+		for _, req := range requiredNames(task) {
+			if known[req] {
+				children[req] = append(children[req], task.Name)
+				isRoot = false
+			}
+		}
+		if isRoot {
+			roots = append(roots, task.Name)
+		}
+	}
+
+	printed := make(map[string]bool)
+	var walk func(name, prefix string, last, top bool)
+	walk = func(name, prefix string, last, top bool) {
+		branch, next := "├── ", "│   "
+		if last {
+			branch, next = "└── ", "    "
+		}
+		if top {
+			branch, next = "", ""
+		}
+
+		if printed[name] && len(children[name]) > 0 {
+			fmt.Fprintf(w, "%s%s%s (see above)\n", prefix, branch, cyan(name))
+			return
+		}
+		printed[name] = true
+		fmt.Fprintf(w, "%s%s%s\n", prefix, branch, cyan(name))
+		for i, child := range children[name] {
+			walk(child, prefix+next, i == len(children[name])-1, false)
+		}
+	}
+	for _, root := range roots {
+		walk(root, "  ", true, true)
+	}
+}
+
+// moduleFlags summarizes the scheduling-relevant settings of a module.
+func moduleFlags(task Task) string {
+	var flags []string
+	if task.Parallel {
+		flags = append(flags, "parallel")
+	}
+	if task.Silent {
+		flags = append(flags, "silent")
+	}
+	if task.Service {
+		flags = append(flags, "service")
+	}
+	if task.AlwaysRun {
+		flags = append(flags, "always_run")
+	}
+	if task.Stream != "" {
+		flags = append(flags, "stream: "+task.Stream)
+	}
+	if task.StdinStream != "" {
+		flags = append(flags, "stdin_stream: "+task.StdinStream)
+	}
+	if task.Workflow != "" {
+		flags = append(flags, "workflow: "+task.Workflow)
+	}
+	if len(task.Tags) > 0 {
+		flags = append(flags, "tags: "+strings.Join(task.Tags, ","))
+	}
+	if len(flags) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(flags, "] [") + "]"
+}
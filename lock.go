@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runLock is the lock file held for the run with -lock. It stays open, and
+// so locked, until rayder exits.
+var runLock *os.File
+
+func lockDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("locating home directory: %w", err)
+	}
+	return filepath.Join(home, ".rayder", "locks"), nil
+}
+
+// acquireRunLock locks the target of the run: the workflows with the value
+// of the variable name. It fails when another run holds the same lock, so a
+// scheduled run that overruns isn't joined by the next one scanning the same
+// target. The lock is released by the system when rayder exits, however it
+// exits.
+func acquireRunLock(workflows []string, name string, vars map[string]string) error {
+	value, ok := vars[name]
+	if !ok {
+		return fmt.Errorf("-lock %s: the variable is not set", name)
+	}
+
+	paths := make([]string, len(workflows))
+	for i, workflow := range workflows {
+		paths[i] = workflow
+		if !isRemoteWorkflow(workflow) {
+			if abs, err := filepath.Abs(workflow); err == nil {
+				paths[i] = abs
+			}
+		}
+	}
+	sum := sha256.Sum256([]byte(strings.Join(paths, "\x00") + "\x00" + name + "=" + value))
+
+	dir, err := lockDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, hex.EncodeToString(sum[:8])+".lock")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		holder, _ := os.ReadFile(path)
+		if len(holder) > 0 {
+			return fmt.Errorf("another run against %s=%s is in progress (%s)", name, value, strings.TrimSpace(string(holder)))
+		}
+		return fmt.Errorf("another run against %s=%s is in progress", name, value)
+	}
+
+	// Describes the holder to runs that find the lock taken.
+	file.Truncate(0)
+	fmt.Fprintf(file, "pid %d, started %s\n", os.Getpid(), time.Now().Format("2006-01-02 15:04:05"))
+	runLock = file
+	return nil
+}
@@ -1,54 +1,305 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/fatih/color"
-	"gopkg.in/yaml.v2"
 )
 
 type Task struct {
-	Name     string   `yaml:"name"`
-	Cmds     []string `yaml:"cmds"`
-	Silent   bool     `yaml:"silent"`
-	Parallel bool     `yaml:"parallel"`
-	Required []string `yaml:"required"`
+	Name         string              `yaml:"name"`
+	Description  string              `yaml:"description"`
+	Cmds         []Command           `yaml:"cmds"`
+	Silent       bool                `yaml:"silent"`
+	Parallel     bool                `yaml:"parallel"`
+	Required     []Requirement       `yaml:"required"`
+	OptionalReq  []string            `yaml:"optional_required"`
+	Stage        string              `yaml:"stage"`
+	Group        string              `yaml:"concurrency_group"`
+	Before       []Command           `yaml:"before"`
+	After        []Command           `yaml:"after"`
+	AlwaysRun    bool                `yaml:"always_run"`
+	Service      bool                `yaml:"service"`
+	Ready        string              `yaml:"ready"`
+	ReadyTimeout string              `yaml:"ready_timeout"`
+	WaitFor      *WaitFor            `yaml:"wait_for"`
+	Env          map[string]string   `yaml:"env"`
+	EnvClean     bool                `yaml:"env_clean"`
+	Shell        string              `yaml:"shell"`
+	ShowCmd      bool                `yaml:"show_cmd"`
+	Tags         []string            `yaml:"tags"`
+	Workflow     string              `yaml:"workflow"`
+	WorkflowSum  string              `yaml:"workflow_sha256"`
+	Vars         map[string]string   `yaml:"vars"`
+	TailLines    int                 `yaml:"tail_lines"`
+	Matrix       map[string][]string `yaml:"matrix"`
+	ForeachFile  string              `yaml:"foreach_file"`
+	Chunks       int                 `yaml:"chunks"`
+	Concurrency  int                 `yaml:"concurrency"`
+	MaxFailures  *int                `yaml:"max_failures"`
+	CmdsParallel CmdsParallel        `yaml:"cmds_parallel"`
+	Stream       string              `yaml:"stream"`
+	StdinStream  string              `yaml:"stdin_stream"`
+	Use          string              `yaml:"use"`
+	With         map[string]string   `yaml:"with"`
+	When         string              `yaml:"when"`
+	SkipIf       string              `yaml:"skip_if"`
+	OnlyIf       string              `yaml:"only_if"`
+	FailIf       string              `yaml:"fail_if"`
+	AllowedExit  []int               `yaml:"allowed_exit_codes"`
+	SkipExit     []int               `yaml:"skip_exit_codes"`
+	Retry        *RetryPolicy        `yaml:"retry"`
+	Pacing       `yaml:",inline"`
+	Window       *Window   `yaml:"allowed_window"`
+	Artifacts    []string  `yaml:"artifacts"`
+	Heartbeat    string    `yaml:"heartbeat"`
+	Limits       *Limits   `yaml:"limits"`
+	Priority     *Priority `yaml:"priority"`
+	Proxy        *Proxy    `yaml:"proxy"`
+	Extract      []Extract `yaml:"extract"`
+	Parser       Parser    `yaml:"parser"`
+
+	dir       string         // directory of the workflow file declaring the module
+	output    *stream        // where stdout goes instead, with stream
+	input     *stream        // what stdin reads, with stdin_stream
+	extracted *extractedVars // where extract stores variables
 }
 
 type Config struct {
-	Vars  map[string]string `yaml:"vars"`
-	Usage string            `yaml:"usage"`
-	Tasks []Task            `yaml:"modules"`
+	Vars         map[string]string            `yaml:"-"`
+	VarSpecs     map[string]VarSpec           `yaml:"vars"`
+	Usage        string                       `yaml:"usage"`
+	Shell        string                       `yaml:"shell"`
+	Pacing       Pacing                       `yaml:"pacing"`
+	Window       *Window                      `yaml:"allowed_window"`
+	Heartbeat    string                       `yaml:"heartbeat"`
+	MinFreeDisk  *DiskGuard                   `yaml:"min_free_disk"`
+	Proxy        *Proxy                       `yaml:"proxy"`
+	Preflight    *Preflight                   `yaml:"preflight"`
+	Tools        []ToolRequirement            `yaml:"requires_tools"`
+	Install      map[string]string            `yaml:"install"`
+	NotifyOnDiff bool                         `yaml:"notify_on_diff"`
+	Sinks        []Sink                       `yaml:"sinks"`
+	Storage      *Storage                     `yaml:"storage"`
+	Secrets      []Secret                     `yaml:"secrets"`
+	SecretsFiles []string                     `yaml:"secrets_files"`
+	Profiles     map[string]map[string]string `yaml:"profiles"`
+	Templates    map[string]Template          `yaml:"templates"`
+	Includes     []Include                    `yaml:"includes"`
+	Groups       map[string]int               `yaml:"concurrency_groups"`
+	MaxFailures  *int                         `yaml:"max_failures"`
+	Before       []Command                    `yaml:"before_all"`
+	After        []Command                    `yaml:"after_all"`
+	Tasks        []Task                       `yaml:"modules"`
+}
+
+// version is the release of rayder, shown in the banner and compared with
+// the latest release by rayder update.
+var version = "v0.0.4"
+
+// subcommands maps the first command line argument to the command it runs.
+// Without a subcommand rayder runs the workflow given with -w.
+var subcommands = map[string]func(args []string) int{
+	"pull":     runPullCommand,
+	"list":     runListCommand,
+	"search":   runSearchCommand,
+	"init":     runInitCommand,
+	"examples": runExamplesCommand,
+	"convert":  runConvertCommand,
+	"export":   runExportCommand,
+	"graph":    runGraphCommand,
+	"vars":     runVarsCommand,
+	"schema":   runSchemaCommand,
+	"history":  runHistoryCommand,
+	"findings": runFindingsCommand,
+	"diff":     runDiffCommand,
+	"serve":    runServeCommand,
+	"show":     runShowCommand,
+	"replay":   runReplayCommand,
+	"update":   runUpdateCommand,
+	"version":  runVersionCommand,
 }
 
 func main() {
+	var err error
+	if userConfig, err = loadUserConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+
 	var (
-		taskFile  string
-		variables map[string]string
-		quietMode bool
+		taskFiles    workflowList
+		variables    map[string]string
+		quietMode    bool
+		quiet2       bool
+		quiet3       bool
+		verbose      bool
+		verbose2     bool
+		refresh      bool
+		verify       verifyOptions
+		noColor      bool
+		themeSpec    string
+		tags         string
+		skipTags     string
+		profile      string
+		install      bool
+		list         bool
+		logDir       string
+		logMaxSize   string
+		logMaxAge    string
+		logKeep      int
+		parallel     int
+		noHistory    bool
+		noPreflight  bool
+		lockVar      string
+		progressFD   int
+		progressFile string
+		auditFile    string
+		logTarget    string
+		showVersion  bool
 	)
 
-	flag.StringVar(&taskFile, "w", "", "Path to the workflow YAML file")
+	flag.BoolVar(&showVersion, "version", false, "Print the version and build information and exit")
+	flag.Var(&taskFiles, "w", "Path to the workflow YAML file (repeat or comma separate to run several)")
 	flag.BoolVar(&quietMode, "q", false, "Suppress banner")
+	flag.BoolVar(&quiet2, "qq", false, "Suppress banner and module lifecycle lines")
+	flag.BoolVar(&quiet3, "qqq", false, "Suppress banner, module lifecycle lines and tool output")
+	flag.BoolVar(&verbose, "v", false, "Echo resolved commands before running them")
+	flag.BoolVar(&verbose2, "vv", false, "Echo resolved commands and log scheduling details")
+	flag.BoolVar(&refresh, "refresh", false, "Re-fetch remote workflows instead of using the cache")
+	flag.StringVar(&verify.sha256, "sha256", "", "Expected SHA-256 checksum of the workflow file")
+	flag.StringVar(&verify.pubkey, "pubkey", "", "Public key to verify the signature of a remote workflow with")
+	flag.StringVar(&verify.sigTool, "sig-tool", "minisign", "Signature tool to verify with: minisign or cosign")
+	flag.Var(&moduleSettings, "set", "Override a variable for a single module, as in module.subenum.THREADS=50 (repeatable)")
+	flag.StringVar(&profile, "profile", "", "Profile from the workflow's profiles section to apply")
+	flag.StringVar(&tags, "tags", "", "Only run modules with one of these comma separated tags")
+	flag.StringVar(&skipTags, "skip-tags", "", "Skip modules with one of these comma separated tags")
+	flag.BoolVar(&list, "list", false, "List the modules of the workflow and their dependencies instead of running it")
+	flag.BoolVar(&install, "install-missing", false, "Run the install commands of missing required tools before starting")
+	flag.BoolVar(&allowUnknownFields, "allow-unknown", false, "Ignore unknown fields in workflow files instead of failing")
+	flag.IntVar(&parallel, "max-parallel", userConfig.MaxParallel, "Maximum number of modules running at the same time (0 for no limit)")
+	flag.StringVar(&lockVar, "lock", "", "Variable identifying the target, such as DOMAIN, to refuse to start while another run of the workflow against the same value is in progress")
+	flag.StringVar(&ageIdentity, "age-identity", os.Getenv("RAYDER_AGE_IDENTITY"), "age identity file to decrypt encrypted variables with")
+	flag.BoolVar(&noPreflight, "skip-preflight", false, "Don't run the workflow's preflight checks")
+	flag.BoolVar(&failFast, "fail-fast", false, "Cancel the run as soon as a module fails, still running always_run modules and cleanup hooks")
+	flag.BoolVar(&noHistory, "no-history", false, "Don't record the run in the run history")
+	flag.BoolVar(&stepMode, "step", false, "Ask before each module whether to run it, skip it or abort the run")
+	flag.BoolVar(&debugOnFail, "debug-on-fail", false, "Open a shell with the module's environment and variables when a module fails")
+	flag.BoolVar(&tuiMode, "tui", false, "Show the run in a terminal UI with the output of each module and keys to skip, cancel and retry modules")
+	flag.IntVar(&progressFD, "progress-fd", 0, "File descriptor to write JSON lines progress events to")
+	flag.StringVar(&progressFile, "progress-file", "", "File to write JSON lines progress events to")
+	flag.StringVar(&logTarget, "log-target", "stderr", "Where else module lifecycle events go besides stderr: stderr or syslog")
+	flag.StringVar(&captureDir, "capture-dir", "", "Directory to write the stdout and stderr of every command to, as separate files")
+	flag.StringVar(&auditFile, "audit-log", "", "File to append a JSON line to for every command run, for rayder replay")
+	flag.DurationVar(&defaultHeartbeat, "heartbeat", 0, "Log a heartbeat when a command has been silent this long, for modules whose workflow sets no heartbeat")
+	flag.Func("proxy", "Proxy URL to export to modules whose workflow sets no proxy", func(value string) error {
+		if _, err := parseProxyURL(value); err != nil {
+			return err
+		}
+		defaultProxy = &Proxy{URL: value}
+		return nil
+	})
+	flag.StringVar(&junitReport, "report-junit", "", "File to write a JUnit XML report of the run to, with a test case per module")
+	flag.StringVar(&sarifReport, "report-sarif", "", "File to write the vulns found by the run to as SARIF, for code scanning")
+	flag.BoolVar(&diffLast, "diff-last", false, "After the run, print how its artifacts changed since the previous run of the same workflows")
+	flag.StringVar(&logDir, "log-dir", userConfig.LogDir, "Directory to write a log of the run to")
+	flag.StringVar(&logMaxSize, "log-max-size", userConfig.LogMaxSize, "Size at which the run log is rotated, such as 100MiB")
+	flag.StringVar(&logMaxAge, "log-max-age", userConfig.LogMaxAge, "Age after which run logs in -log-dir are removed, such as 30d")
+	flag.IntVar(&logKeep, "log-keep", userConfig.LogKeep, "Number of runs whose logs are kept in -log-dir (0 for all)")
+	flag.BoolVar(&noColor, "no-color", userConfig.NoColor, "Disable colored output")
+	themeDefault := os.Getenv("RAYDER_THEME")
+	if themeDefault == "" {
+		themeDefault = userConfig.Theme
+	}
+	flag.StringVar(&themeSpec, "theme", themeDefault, "Color theme: default, light, or slot=color pairs such as yellow=blue,white=black")
 	flag.Parse()
 	log.SetFlags(0)
 
-	cyan := color.New(color.FgCyan).SprintFunc()
-	yellow := color.New(color.FgYellow).SprintFunc()
-	red := color.New(color.FgRed).SprintFunc()
-	green := color.New(color.FgGreen).SprintFunc()
-	white := color.New(color.FgWhite).SprintFunc()
-	magenta := color.New(color.FgMagenta).SprintFunc()
+	if showVersion {
+		printVersion(os.Stdout)
+		return
+	}
+
+	color.NoColor = colorsDisabled(noColor)
+	theme, err := parseTheme(themeSpec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	cyan := themeColor(theme, "cyan")
+	yellow := themeColor(theme, "yellow")
+	red := themeColor(theme, "red")
+	green := themeColor(theme, "green")
+	white := themeColor(theme, "white")
+	magenta := themeColor(theme, "magenta")
+
+	switch {
+	case quiet3:
+		verbosity = levelNoOutput
+	case quiet2:
+		verbosity = levelNoLifecycle
+	case quietMode:
+		verbosity = levelNoBanner
+	case verbose2:
+		verbosity = levelDebug
+	case verbose:
+		verbosity = levelCommands
+	}
+
+	if logDir != "" && len(taskFiles) > 0 && !list {
+		var retention logRetention
+		var err error
+		if logMaxSize != "" {
+			if retention.maxSize, err = parseBytes(logMaxSize); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: -log-max-size: %v\n", err)
+				os.Exit(exitUsage)
+			}
+		}
+		if logMaxAge != "" {
+			if retention.maxAge, err = parseRetentionAge(logMaxAge); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: -log-max-age: %v\n", err)
+				os.Exit(exitUsage)
+			}
+		}
+		retention.keep = logKeep
+		path, stop, err := startRunLog(logDir, retention)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: starting the run log: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		exitHooks = append(exitHooks, stop)
+		runLogPath = path
+		defer runExitHooks()
+		logDebug("[%s] [%s] Logging to %s\n", yellow(currentTime()), yellow("DEBUG"), path)
+	}
+
+	if parallel > 0 {
+		moduleSlots = make(chan struct{}, parallel)
+	}
 
-	if !quietMode {
+	// The user config can replace the banner or turn it off.
+	switch {
+	case verbosity <= levelNoBanner || bannerOff(userConfig.Banner):
+	case userConfig.Banner != "":
+		fmt.Fprintf(os.Stderr, "\n%s\n\n", white(userConfig.Banner))
+	default:
 		fmt.Fprintf(os.Stderr, "\n%s\n\n", white(`
 	                         __         
 	   _____________  ______/ /__  _____
@@ -57,43 +308,167 @@ func main() {
 	/_/   \____/\___ /\____/\___/_/     
 	           /____/                   
 
-	           		- v0.0.4 ⚡
+	           		- `+version+mark("start")+`
 
 `))
 	}
 
-	var defaultVars map[string]string
-	yamlFileContent, err := ioutil.ReadFile(taskFile)
-	if err == nil {
-		var config Config
-		err = yaml.Unmarshal(yamlFileContent, &config)
-		if err == nil {
-			defaultVars = config.Vars
+	var config Config
+	var configErr error
+	if len(taskFiles) > 0 {
+		config, configErr = loadWorkflows(taskFiles, refresh, verify)
+		parentVerify = verify
+		if configErr == nil && profile != "" {
+			configErr = applyProfile(&config, profile)
+		}
+		if configErr == nil && !list {
+			configErr = decryptVars(&config, flag.Args())
+		}
+		if configErr == nil && !list {
+			configErr = fetchSecrets(&config, flag.Args())
 		}
 	}
 
-	variables = parseArgs(defaultVars)
+	variables = withRunVars(parseArgs(config, taskFiles))
 
-	if taskFile == "" {
+	if len(taskFiles) == 0 {
 		fmt.Fprintln(os.Stderr, "Usage: rayder -w workflow.yaml [variable assignments e.g. DOMAIN=example.host]")
+		exit(exitUsage)
+	}
+
+	if configErr != nil {
+		fmt.Fprintf(os.Stderr, "Error loading workflow: %v\n", configErr)
+		exit(exitInvalid)
+	}
+
+	if unknown := unknownSettingModules(config.Tasks); len(unknown) > 0 {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] -set names modules the workflow doesn't define: %s\n", yellow(currentTime()), red("ERROR"), strings.Join(unknown, ", "))
+		exit(exitUsage)
+	}
+	applySettings(config.Tasks)
+
+	if missing := missingVars(config.VarSpecs, variables); len(missing) > 0 {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Missing required variables: %s\n", yellow(currentTime()), red("ERROR"), strings.Join(missing, ", "))
+		fmt.Fprintf(os.Stderr, "Run 'rayder -w %s usage' for details.\n", strings.Join(taskFiles, ","))
+		exit(exitUsage)
+	}
+
+	markSecret(secretNames(config.Secrets)...)
+
+	if tags != "" || skipTags != "" {
+		all := config.Tasks
+		var dropped map[string]string
+		config.Tasks, dropped = selectTasks(all, splitList(tags), splitList(skipTags))
+		for _, task := range all {
+			if reason, ok := dropped[task.Name]; ok {
+				logLifecycle("[%s] [%s] Module '%s' %s (%s)\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), yellow(word("excluded")), reason)
+			}
+		}
+	}
+
+	if list {
+		listModules(os.Stdout, config.Tasks, cyan, magenta, white, yellow)
 		return
 	}
 
-	taskFileContent, err := ioutil.ReadFile(taskFile)
-	if err != nil {
-		log.Fatalf("Error reading workflow file: %v", err)
+	if install {
+		if err := installTools(config.Tools, config.Install, config.Shell, yellow, cyan, red); err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] [%s] %v\n", yellow(currentTime()), red("ERROR"), err)
+			exit(exitMissingTools)
+		}
 	}
 
-	var config Config
-	err = yaml.Unmarshal(taskFileContent, &config)
-	if err != nil {
-		log.Fatalf("Error unmarshaling YAML: %v", err)
+	if missing := checkTools(config.Tools); len(missing) > 0 {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Required tools are missing or do not match:\n", yellow(currentTime()), red("ERROR"))
+		for _, problem := range missing {
+			fmt.Fprintf(os.Stderr, "    %s\n", problem)
+		}
+		if !install && len(config.Install) > 0 {
+			fmt.Fprintln(os.Stderr, "Run with -install-missing to use the workflow's install commands.")
+		}
+		exit(exitMissingTools)
 	}
 
-	runAllTasks(config, variables, cyan, magenta, white, yellow, red, green)
+	if lockVar != "" {
+		if err := acquireRunLock(taskFiles, lockVar, variables); err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Not starting: %v\n", yellow(currentTime()), red("ERROR"), err)
+			exit(exitFailed)
+		}
+	}
+
+	if config.Preflight != nil && !noPreflight {
+		if problems := config.Preflight.check(variables); len(problems) > 0 {
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Preflight checks failed, not starting:\n", yellow(currentTime()), red("ERROR"))
+			for _, problem := range problems {
+				fmt.Fprintf(os.Stderr, "    %s\n", problem)
+			}
+			exit(exitFailed)
+		}
+		logLifecycle("[%s] [%s] Preflight checks passed%s\n", yellow(currentTime()), yellow("INFO"), mark("success"))
+	}
+
+	if tuiMode && (stepMode || debugOnFail) {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] -tui can't be used with -step or -debug-on-fail, which read from the terminal\n", yellow(currentTime()), red("ERROR"))
+		exit(exitUsage)
+	}
+	if progressFD > 0 && progressFile != "" {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] -progress-fd and -progress-file can't be used together\n", yellow(currentTime()), red("ERROR"))
+		exit(exitUsage)
+	}
+	if progressFD > 0 || progressFile != "" {
+		if progress, err = openProgress(progressFD, progressFile); err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Opening the progress stream: %v\n", yellow(currentTime()), red("ERROR"), err)
+			exit(exitUsage)
+		}
+	}
+	if journal, err = openJournal(logTarget); err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Opening the log target: %v\n", yellow(currentTime()), red("ERROR"), err)
+		exit(exitUsage)
+	}
+	if auditFile != "" {
+		if auditLog, err = openAuditLog(auditFile); err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Opening the audit log: %v\n", yellow(currentTime()), red("ERROR"), err)
+			exit(exitUsage)
+		}
+	}
+	if noHistory && (diffLast || config.NotifyOnDiff) {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] -diff-last and notify_on_diff compare against the run history and can't be used with -no-history\n", yellow(currentTime()), red("ERROR"))
+		exit(exitUsage)
+	}
+	if url := databaseURL(); url != "" && !noHistory {
+		if database, err = openDatabase(url); err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Opening the database, the run is only recorded locally: %v\n", yellow(currentTime()), red("ERROR"), err)
+		}
+	}
+	startHistory(taskFiles, variables, !noHistory)
+	if err := loadEstimates(taskFiles); err != nil {
+		logDebug("[%s] [%s] No estimates from the run history: %v\n", yellow(currentTime()), yellow("DEBUG"), err)
+	}
+	printEstimate(yellow, cyan)
+	watchControlSignals(yellow, red)
+	watchInterrupts(yellow, red)
+	if tuiMode {
+		if tui, err = startTUI(taskFiles, config.Tasks, cyan, yellow, red, green); err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] [%s] %v\n", yellow(currentTime()), red("ERROR"), err)
+			exit(exitUsage)
+		}
+		exitHooks = append(exitHooks, tui.stop)
+	}
+	switch {
+	case failFast:
+		failureLimit = 0
+	case config.MaxFailures != nil:
+		failureLimit = *config.MaxFailures
+	}
+	if config.Storage != nil {
+		runStorage = config.Storage
+		storagePrefix = config.Storage.prefix(currentRunID(), variables)
+		storageRoot = variables["OUTPUT_DIR"]
+	}
+	runAllTasks(config, taskFiles, variables, cyan, magenta, white, yellow, red, green)
 }
 
-func parseArgs(defaultVars map[string]string) map[string]string {
+func parseArgs(config Config, files []string) map[string]string {
 	variables := make(map[string]string)
 	usageRequested := false
 
@@ -113,148 +488,916 @@ func parseArgs(defaultVars map[string]string) map[string]string {
 	}
 
 	if usageRequested {
-		fmt.Fprintln(os.Stderr, "Usage:")
-		fmt.Fprintln(os.Stderr, defaultVars["USAGE"])
+		printUsage(os.Stderr, config, files)
+		exit(0)
+	}
+
+	for key, defaultValue := range config.Vars {
+		if _, exists := variables[key]; !exists {
+			variables[key] = defaultValue
+		}
+	}
+
+	return variables
+}
+
+func runAllTasks(config Config, workflows []string, variables map[string]string, cyan, magenta, white, yellow, red, green func(a ...interface{}) string) {
+	started := time.Now()
+	progress.runStarted(currentRunID(), config.Tasks)
+	journal.runStarted(currentRunID(), workflows)
+	stopDiskGuard := watchDisk(config.MinFreeDisk, variables, yellow, cyan, red)
+	ok := runWorkflow(config, variables, cyan, magenta, white, yellow, red, green)
+	stopDiskGuard()
 
-		fmt.Fprintln(os.Stderr, "\nVariables from YAML:")
-		for key, value := range defaultVars {
-			if key != "USAGE" {
-				fmt.Fprintf(os.Stderr, "%s: %s\n", key, value)
+	// Services, including those of sub-workflows, live until the whole run
+	// is over.
+	stopServices(cyan, magenta, white, yellow, red, green)
+	tui.stop()
+
+	var diffs []artifactDiff
+	diffed := false
+	run, err := finishHistory(ok)
+	progress.runFinished(run.ID, run.Status)
+	journal.runFinished(run.Status, time.Since(started))
+	printSummary(*run, yellow)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Recording the run history: %v\n", yellow(currentTime()), red("ERROR"), err)
+	} else if currentRun.save {
+		logLifecycle("[%s] [%s] Run recorded as %s, see: rayder show %s\n", yellow(currentTime()), yellow("INFO"), cyan(run.ID), run.ID)
+		if diffLast || config.NotifyOnDiff {
+			prev, changes, err := changesSincePrevious(*run)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] [%s] Comparing with the previous run: %v\n", yellow(currentTime()), red("ERROR"), err)
 			}
+			if diffLast {
+				printChanges(prev, changes, err, yellow, cyan, red, green)
+			}
+			diffs, diffed = changes, err == nil
 		}
+	}
+
+	uploadRun(*run, yellow, cyan, red)
 
-		os.Exit(0)
+	for _, sink := range config.Sinks {
+		if err := sink.ship(*run, variables); err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Sink %s: %v\n", yellow(currentTime()), red("ERROR"), cyan(sink.Type), err)
+		} else {
+			logLifecycle("[%s] [%s] Results shipped to %s\n", yellow(currentTime()), yellow("INFO"), cyan(sink.Type))
+		}
 	}
 
-	for key, defaultValue := range defaultVars {
-		if _, exists := variables[key]; !exists {
-			variables[key] = defaultValue
+	if junitReport != "" {
+		if err := writeJUnit(junitReport, *run); err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Writing the JUnit report: %v\n", yellow(currentTime()), red("ERROR"), err)
+		} else {
+			logLifecycle("[%s] [%s] JUnit report written to %s\n", yellow(currentTime()), yellow("INFO"), junitReport)
+		}
+	}
+	if sarifReport != "" {
+		if err := writeSARIF(sarifReport, *run); err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Writing the SARIF report: %v\n", yellow(currentTime()), red("ERROR"), err)
+		} else {
+			logLifecycle("[%s] [%s] SARIF report written to %s\n", yellow(currentTime()), yellow("INFO"), sarifReport)
 		}
 	}
 
-	return variables
+	// With notify_on_diff, webhooks only fire when an artifact changed. When
+	// the comparison failed they fire anyway rather than miss a change.
+	if !config.NotifyOnDiff || !diffed || len(diffs) > 0 {
+		notifyWebhooks(userConfig.Webhooks, workflows, started, ok, diffs)
+	}
+
+	if control.isCancelled() && !control.failedFast() {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Run cancelled%s\n", yellow(currentTime()), red("INFO"), mark("failure"))
+		exit(exitInterrupted)
+	}
+	if !ok {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Errors occurred during execution. Exiting program%s\n", yellow(currentTime()), red("INFO"), mark("failure"))
+		exit(exitFailed)
+	}
+
+	logLifecycle("[%s] [%s] All modules completed successfully%s\n", yellow(currentTime()), yellow("INFO"), mark("success"))
 }
 
-func runAllTasks(config Config, variables map[string]string, cyan, magenta, white, yellow, red, green func(a ...interface{}) string) {
+// moduleSlots limits how many modules run at the same time across the whole
+// run, including sub-workflows. It is nil when there is no limit.
+var moduleSlots chan struct{}
+
+// runWorkflow runs the hooks and modules of config and reports whether all of
+// them succeeded.
+func runWorkflow(config Config, variables map[string]string, cyan, magenta, white, yellow, red, green func(a ...interface{}) string) bool {
+	tasks, err := connectStreams(config.Tasks)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] %v\n", yellow(currentTime()), red("ERROR"), err)
+		return false
+	}
+	defer removeStreams(tasks)
+	extracted := &extractedVars{}
+	for i := range tasks {
+		tasks[i].extracted = extracted
+	}
+
 	var wg sync.WaitGroup
-	var errorOccurred bool
-	var errorMutex sync.Mutex
+	var errorOccurred bool // in the hooks
+	var aborted bool
+	var stateMutex sync.Mutex
+
+	// failed tracks the modules that failed, so a module retried from the
+	// terminal UI no longer fails the run once it succeeds.
+	failed := make(map[string]bool)
 
 	// Create a map to track task completion
 	taskCompleted := make(map[string]bool)
+	// and one of how they ended, for requirements with state success
+	taskStatus := make(map[string]string)
 
-	for _, task := range config.Tasks {
-		if len(task.Required) > 0 {
-			// Check if all required tasks are completed before running this task
+	// Modules in optional_required are only waited for when they are part
+	// of the run, not when they were excluded.
+	present := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		present[task.Name] = true
+	}
+
+	waitForRequired := func(task Task) {
+		required := requiredNames(task)
+		for _, req := range task.OptionalReq {
+			if present[req] {
+				required = append(required, req)
+			}
+		}
+		for {
 			allRequiredCompleted := true
-			for _, req := range task.Required {
+			stateMutex.Lock()
+			for _, req := range required {
 				if !taskCompleted[req] {
 					allRequiredCompleted = false
 					break
 				}
 			}
+			stateMutex.Unlock()
 
-			if !allRequiredCompleted {
-				// Wait until all required tasks are completed
-				for {
-					allRequiredCompleted = true
-					for _, req := range task.Required {
-						if !taskCompleted[req] {
-							allRequiredCompleted = false
-							break
-						}
-					}
-
-					if allRequiredCompleted {
-						break
-					}
-
-					time.Sleep(1 * time.Second)
-				}
+			if allRequiredCompleted {
+				return
+			}
+
+			time.Sleep(1 * time.Second)
+		}
+	}
+
+	// Each concurrency group is a semaphore limiting how many of its modules
+	// run at once. Groups without a configured limit run one module at a time.
+	groups := make(map[string]chan struct{})
+	for _, task := range tasks {
+		if task.Group == "" || groups[task.Group] != nil {
+			continue
+		}
+		limit := config.Groups[task.Group]
+		if limit <= 0 {
+			limit = 1
+		}
+		groups[task.Group] = make(chan struct{}, limit)
+	}
+
+	run := func(task Task) {
+		// Readers of the module's stream get to its end however the module
+		// ends, even if it never runs.
+		if task.output != nil {
+			defer task.output.close()
+		}
+		control.waitWhilePaused()
+
+		// Once the run is aborting or cancelled only always_run modules are
+		// executed; the rest are marked completed so nothing waits on them
+		// forever.
+		stateMutex.Lock()
+		if (aborted || control.isCancelled()) && !task.AlwaysRun {
+			taskCompleted[task.Name] = true
+			taskStatus[task.Name] = statusSkipped
+			stateMutex.Unlock()
+			recordModule(task.Name, statusSkipped, time.Now(), nil)
+			logLifecycle("[%s] [%s] Module '%s' %s\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), yellow(word("skipped")))
+			return
+		}
+		if req, status := unmetRequirement(task, taskStatus); req != "" {
+			taskCompleted[task.Name] = true
+			taskStatus[task.Name] = statusSkipped
+			stateMutex.Unlock()
+			recordModule(task.Name, statusSkipped, time.Now(), nil)
+			logLifecycle("[%s] [%s] Module '%s' %s (required module '%s' %s)\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), yellow(word("skipped")), cyan(req), word(status))
+			return
+		}
+		if stopped := tui.stopped(task.Name); stopped != "" {
+			taskCompleted[task.Name] = true
+			taskStatus[task.Name] = stopped
+			failed[task.Name] = stopped == statusCancelled
+			stateMutex.Unlock()
+			recordModule(task.Name, stopped, time.Now(), nil)
+			logLifecycle("[%s] [%s] Module '%s' %s (terminal UI)\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), yellow(word(stopped)))
+			return
+		}
+		stateMutex.Unlock()
+
+		if sem := groups[task.Group]; sem != nil {
+			select {
+			case sem <- struct{}{}:
+			default:
+				logLifecycle("[%s] [%s] Module '%s' waiting for concurrency group '%s'\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), magenta(task.Group))
+				sem <- struct{}{}
+			}
+			defer func() { <-sem }()
+		}
+
+		// Modules running a sub-workflow don't take a slot, their children
+		// do; otherwise a limit of 1 would deadlock.
+		if moduleSlots != nil && task.Workflow == "" {
+			moduleSlots <- struct{}{}
+			defer func() { <-moduleSlots }()
+		}
+
+		started := time.Now()
+		progress.moduleStarted(task.Name)
+		journal.moduleStarted(task.Name)
+		tui.moduleStarted(task.Name)
+		stopWatching := watchOverrun(task.Name, yellow, cyan)
+		err := runTask(task, extracted.merge(variables), cyan, magenta, white, yellow, red, green)
+		stopWatching()
+
+		// Modules skipped from the terminal UI don't fail the run, cancelled
+		// ones do.
+		status := statusCompleted
+		stopped := tui.stopped(task.Name)
+		switch {
+		case stopped == statusSkipped:
+			status, err = stopped, nil
+		case stopped != "":
+			status, err = stopped, errStopped
+		case err != nil:
+			status = statusErrored
+		}
+		artifacts := taskArtifacts(task, variables)
+		recordModule(task.Name, status, started, artifacts)
+		streamArtifacts(task.Name, artifacts, yellow, red)
+
+		stateMutex.Lock()
+		defer stateMutex.Unlock()
+		failed[task.Name] = err != nil
+		switch {
+		case stopped != "":
+			logLifecycle("[%s] [%s] Module '%s' %s (terminal UI)\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), yellow(word(stopped)))
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s' %s%s\n", yellow(currentTime()), red("INFO"), cyan(task.Name), red(word("errored")), mark("failure"))
+			var instErr *instancesError
+			if !errors.As(err, &instErr) {
+				countFailure(task.Name, cyan, yellow, red)
+			}
+		}
+		// Signal the completion of this task
+		taskCompleted[task.Name] = true
+		taskStatus[task.Name] = moduleStatus(task.Name)
+	}
+
+	if len(config.Before) > 0 {
+		logLifecycle("[%s] [%s] Running %s hooks%s\n", yellow(currentTime()), yellow("INFO"), cyan("before_all"), mark("start"))
+		if err := runCommands("before_all", Task{Name: "before_all", Shell: config.Shell}, config.Before, variables, cyan, magenta, white, yellow, red, green); err != nil {
+			errorOccurred = true
+			aborted = true
+		}
+	}
+
+	for _, batch := range stageBatches(tasks) {
+		if batch.stage != "" {
+			// A stage starts once everything before it has finished and
+			// runs all of its modules in parallel.
+			wg.Wait()
+			logLifecycle("[%s] [%s] Stage '%s' %s%s\n", yellow(currentTime()), yellow("INFO"), magenta(batch.stage), yellow(word("running")), mark("start"))
+
+			var stageWg sync.WaitGroup
+			for _, task := range batch.tasks {
+				stageWg.Add(1)
+				go func(task Task) {
+					defer stageWg.Done()
+					waitForRequired(task)
+					run(task)
+				}(task)
 			}
+			stageWg.Wait()
+			continue
 		}
 
-		if task.Parallel {
+		task := batch.tasks[0]
+		waitForRequired(task)
+
+		// A module streaming its output runs in the background, so the
+		// modules reading it can start while it runs. As with a parallel
+		// module, the modules after it start right away unless they
+		// require it.
+		if task.Parallel || task.output != nil {
 			wg.Add(1)
-			go func(name string, cmds []string, silent bool, vars map[string]string) {
+			go func(task Task) {
 				defer wg.Done()
-				err := runTask(name, cmds, silent, vars, cyan, magenta, white, yellow, red, green)
-				if err != nil {
-					errorMutex.Lock()
-					errorOccurred = true
-					fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s' %s ❌\n", yellow(currentTime()), red("INFO"), cyan(name), red("errored"))
-					errorMutex.Unlock()
-				}
-				// Signal the completion of this task
-				taskCompleted[name] = true
-			}(task.Name, task.Cmds, task.Silent, variables)
+				run(task)
+			}(task)
 		} else {
-			err := runTask(task.Name, task.Cmds, task.Silent, variables, cyan, magenta, white, yellow, red, green)
-			if err != nil {
-				errorOccurred = true
-				fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s' %s ❌\n", yellow(currentTime()), red("INFO"), cyan(task.Name), red("errored"))
-			}
-			// Signal the completion of this task
-			taskCompleted[task.Name] = true
+			run(task)
 		}
 	}
 
 	wg.Wait() // Wait for all parallel tasks to finish
 
-	if errorOccurred {
-		fmt.Fprintf(os.Stderr, "[%s] [%s] Errors occurred during execution. Exiting program ❌\n", yellow(currentTime()), red("INFO"))
-		os.Exit(1) // Exit with error code 1
+	// Modules retried from the terminal UI run once everything else has,
+	// before after_all cleans up.
+	if tui.isRoot(tasks) {
+		for {
+			name, ok := tui.nextRetry()
+			if !ok {
+				break
+			}
+			for _, task := range tasks {
+				if task.Name == name {
+					run(task)
+				}
+			}
+		}
 	}
 
-	fmt.Fprintf(os.Stderr, "[%s] [%s] All modules completed successfully ✅\n", yellow(currentTime()), yellow("INFO"))
+	// after_all hooks run regardless of failures so they can tear down
+	// whatever before_all or the modules set up.
+	if len(config.After) > 0 {
+		logLifecycle("[%s] [%s] Running %s hooks%s\n", yellow(currentTime()), yellow("INFO"), cyan("after_all"), mark("start"))
+		if err := runCommands("after_all", Task{Name: "after_all", Shell: config.Shell}, config.After, variables, cyan, magenta, white, yellow, red, green); err != nil {
+			errorOccurred = true
+		}
+	}
+
+	for _, f := range failed {
+		if f {
+			errorOccurred = true
+		}
+	}
+	return !errorOccurred && !control.isCancelled()
 }
 
-func runTask(taskName string, cmds []string, silent bool, vars map[string]string, cyan, magenta, white, yellow, red, green func(a ...interface{}) string) error {
-	currentTime()
-	fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s' %s ⚡\n", yellow(currentTime()), yellow("INFO"), cyan(taskName), yellow("running"))
+// batch is a unit of scheduling: either a single module without a stage, or
+// all modules sharing a stage.
+type batch struct {
+	stage string
+	tasks []Task
+}
 
-	var hasError bool
-	for _, cmd := range cmds {
-		err := executeCommand(cmd, silent, vars)
+// stageBatches groups tasks by stage. A stage is scheduled at the position of
+// its first module; modules without a stage keep their own position.
+func stageBatches(tasks []Task) []batch {
+	var batches []batch
+	stageIndex := make(map[string]int)
+
+	for _, task := range tasks {
+		if task.Stage == "" {
+			batches = append(batches, batch{tasks: []Task{task}})
+			continue
+		}
+
+		if i, ok := stageIndex[task.Stage]; ok {
+			batches[i].tasks = append(batches[i].tasks, task)
+			continue
+		}
+
+		stageIndex[task.Stage] = len(batches)
+		batches = append(batches, batch{stage: task.Stage, tasks: []Task{task}})
+	}
+
+	return batches
+}
+
+func runTask(task Task, vars map[string]string, cyan, magenta, white, yellow, red, green func(a ...interface{}) string) error {
+	logDebug("[%s] [%s] Module '%s' using shell %q\n", yellow(currentTime()), yellow("DEBUG"), cyan(task.Name), strings.Join(shellArgs(task.Shell), " "))
+	vars = taskVars(task, vars)
+
+	if task.When != "" {
+		ok, err := evalCondition(task.When, vars)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s': when: %v\n", yellow(currentTime()), red("ERROR"), cyan(task.Name), err)
+			return err
+		}
+		if !ok {
+			recordModule(task.Name, statusSkipped, time.Now(), nil)
+			logLifecycle("[%s] [%s] Module '%s' %s (when: %s)\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), yellow(word("skipped")), task.When)
+			return nil
+		}
+	}
+
+	if task.SkipIf != "" || task.OnlyIf != "" {
+		reason, err := probeSkip(task, vars)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s': %v\n", yellow(currentTime()), red("ERROR"), cyan(task.Name), err)
+			return err
+		}
+		if reason != "" {
+			recordModule(task.Name, statusSkipped, time.Now(), nil)
+			logLifecycle("[%s] [%s] Module '%s' %s (%s)\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), yellow(word("skipped")), reason)
+			return nil
+		}
+		logDebug("[%s] [%s] Module '%s' runs, its skip_if/only_if probes allow it\n", yellow(currentTime()), yellow("DEBUG"), cyan(task.Name))
+	}
+
+	// The window is checked again after waiting, in case the clock jumped.
+	for w := task.Window; w != nil && !w.contains(time.Now()); {
+		if w.Outside == "skip" {
+			recordModule(task.Name, statusSkipped, time.Now(), nil)
+			logLifecycle("[%s] [%s] Module '%s' %s (outside allowed window %s)\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), yellow(word("skipped")), w)
+			return nil
+		}
+		d := w.untilOpen(time.Now())
+		logLifecycle("[%s] [%s] Module '%s' waiting %s for allowed window %s\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), d.Round(time.Second), w)
+		if !control.sleep(d) {
+			recordModule(task.Name, statusSkipped, time.Now(), nil)
+			logLifecycle("[%s] [%s] Module '%s' %s (run cancelled)\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), yellow(word("skipped")))
+			return nil
+		}
+	}
+
+	// Once the run is aborted, the always_run modules left run without asking.
+	if stepMode && !control.isCancelled() {
+		switch stepPrompt(task, vars, cyan, yellow) {
+		case stepSkip:
+			recordModule(task.Name, statusSkipped, time.Now(), nil)
+			logLifecycle("[%s] [%s] Module '%s' %s (step mode)\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), yellow(word("skipped")))
+			return nil
+		case stepAbort:
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Aborting the run, remaining modules are skipped and cleanup hooks run\n", yellow(currentTime()), red("INFO"))
+			control.cancel()
+			recordModule(task.Name, statusSkipped, time.Now(), nil)
+			return nil
+		}
+	}
+
+	d, err := task.pause(task.DelayBefore, vars)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s': delay_before: %v\n", yellow(currentTime()), red("ERROR"), cyan(task.Name), err)
+		return err
+	}
+	if d > 0 {
+		logLifecycle("[%s] [%s] Module '%s' delaying %s\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), d.Round(time.Millisecond))
+		time.Sleep(d)
+	}
+
+	// Hooks don't take part in the module's streams.
+	hooks := task
+	hooks.output, hooks.input = nil, nil
+
+	if len(task.Before) > 0 {
+		err = runCommands(task.Name+" (before)", hooks, task.Before, vars, cyan, magenta, white, yellow, red, green)
+	}
+	if err == nil && task.Service {
+		err = startService(task, vars, cyan, magenta, white, yellow, red, green)
 		if err != nil {
-			hasError = true
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Service '%s': %v\n", yellow(currentTime()), red("ERROR"), cyan(task.Name), err)
+		}
+	} else if err == nil {
+		if task.WaitFor != nil {
+			logLifecycle("[%s] [%s] Module '%s' waiting for %s\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), task.WaitFor.resolve(vars))
+			err = waitForConditions(task.WaitFor, vars)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s': %v\n", yellow(currentTime()), red("ERROR"), cyan(task.Name), err)
+			}
+		}
+		if err == nil {
+			err = runInstances(task, vars, cyan, magenta, white, yellow, red, green)
+		}
+		if err == nil && task.FailIf != "" {
+			err = checkFailIf(task, vars)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s': %v\n", yellow(currentTime()), red("ERROR"), cyan(task.Name), err)
+			}
+		}
+		if err == nil && task.Parser != "" {
+			err = parseTaskFindings(task, vars)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s': %v\n", yellow(currentTime()), red("ERROR"), cyan(task.Name), err)
+			}
+		}
+		if err == nil && len(task.Extract) > 0 {
+			err = runExtracts(task, vars, cyan, yellow)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s': %v\n", yellow(currentTime()), red("ERROR"), cyan(task.Name), err)
+			}
+		}
+	}
+
+	// after hooks run even when the module failed.
+	if len(task.After) > 0 {
+		afterErr := runCommands(task.Name+" (after)", hooks, task.After, vars, cyan, magenta, white, yellow, red, green)
+		if err == nil {
+			err = afterErr
+		}
+	}
+
+	if d, pauseErr := task.pause(task.DelayAfter, vars); pauseErr != nil {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s': delay_after: %v\n", yellow(currentTime()), red("ERROR"), cyan(task.Name), pauseErr)
+		if err == nil {
+			err = pauseErr
+		}
+	} else if d > 0 {
+		logLifecycle("[%s] [%s] Module '%s' cooling down for %s\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), d.Round(time.Millisecond))
+		time.Sleep(d)
+	}
+
+	if errors.Is(err, errCancelled) {
+		recordModule(task.Name, statusSkipped, time.Now(), nil)
+		logLifecycle("[%s] [%s] Module '%s' %s (run cancelled)\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), yellow(word("skipped")))
+		return nil
+	}
+
+	// A hook exiting with a skip code skips the whole module.
+	var skipErr *skipError
+	if errors.As(err, &skipErr) {
+		recordModule(task.Name, statusSkipped, time.Now(), nil)
+		logLifecycle("[%s] [%s] Module '%s' %s (exit code %d)\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), yellow(word("skipped")), skipErr.code)
+		return nil
+	}
+	return err
+}
+
+func runInstances(task Task, vars map[string]string, cyan, magenta, white, yellow, red, green func(a ...interface{}) string) error {
+	instances, cleanup, err := expandInstances(task, vars)
+	defer cleanup()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s': %v\n", yellow(currentTime()), red("ERROR"), cyan(task.Name), err)
+		return err
+	}
+	if len(instances) == 1 && instances[0].label == "" {
+		return runInstance(task.Name, task, instances[0].vars, cyan, magenta, white, yellow, red, green)
+	}
+
+	limit := task.Concurrency
+	if limit <= 0 {
+		limit = 1
+		if task.Chunks > 0 {
+			limit = len(instances)
+		}
+	}
+	logDebug("[%s] [%s] Module '%s' expanded into %d instances, running %d at a time\n", yellow(currentTime()), yellow("DEBUG"), cyan(task.Name), len(instances), limit)
+
+	var wg sync.WaitGroup
+	var failed int
+	var failedMutex sync.Mutex
+	sem := make(chan struct{}, limit)
+	counter := newInstanceProgress(task.Name, len(instances), yellow, cyan)
+
+	started := 0
+	for _, inst := range instances {
+		// Once more instances failed than max_failures allows, or the run
+		// is cancelled, the rest don't start.
+		sem <- struct{}{}
+		failedMutex.Lock()
+		exceeded := task.MaxFailures != nil && failed > *task.MaxFailures
+		failedMutex.Unlock()
+		if exceeded || (control.isCancelled() && !task.AlwaysRun) {
+			<-sem
 			break
 		}
+		started++
+		wg.Add(1)
+		go func(inst instance) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			name := fmt.Sprintf("%s [%s]", task.Name, inst.label)
+			err := runInstance(name, task, inst.vars, cyan, magenta, white, yellow, red, green)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s' %s%s\n", yellow(currentTime()), red("INFO"), cyan(name), red(word("errored")), mark("failure"))
+				failedMutex.Lock()
+				failed++
+				failedMutex.Unlock()
+				countFailure(name, cyan, yellow, red)
+			}
+			counter.finished(err != nil)
+		}(inst)
 	}
+	wg.Wait()
+
+	exceeded := task.MaxFailures != nil && failed > *task.MaxFailures
+	switch {
+	case failed == 0 && started < len(instances):
+		return errCancelled
+	case failed == 0:
+		return nil
+	case exceeded && started < len(instances):
+		return &instancesError{fmt.Sprintf("Module '%s' %s%s (%d of %d instances failed, more than max_failures %d; %d not started)", task.Name, red(word("errored")), mark("failure"), failed, len(instances), *task.MaxFailures, len(instances)-started)}
+	case task.MaxFailures != nil && !exceeded && started == len(instances):
+		logLifecycle("[%s] [%s] Module '%s' tolerated %d failed instances of %d (max_failures: %d)\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), failed, len(instances), *task.MaxFailures)
+		return nil
+	}
+	return &instancesError{fmt.Sprintf("Module '%s' %s%s (%d of %d instances failed)", task.Name, red(word("errored")), mark("failure"), failed, len(instances))}
+}
 
-	if hasError {
-		return fmt.Errorf("Module '%s' %s ❌", taskName, red("errored"))
+func runInstance(taskName string, task Task, vars map[string]string, cyan, magenta, white, yellow, red, green func(a ...interface{}) string) error {
+	if control.isCancelled() && !task.AlwaysRun {
+		return errCancelled
 	}
+	logLifecycle("[%s] [%s] Module '%s' %s%s%s\n", yellow(currentTime()), yellow("INFO"), cyan(taskName), yellow(word("running")), mark("start"), estimateNote(taskName))
 
-	fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s' %s ✅\n", yellow(currentTime()), yellow("INFO"), cyan(taskName), green("completed"))
+	var err error
+	if task.Workflow != "" {
+		err = runSubWorkflow(taskName, task, vars, cyan, magenta, white, yellow, red, green)
+	} else if task.CmdsParallel != 0 {
+		err = runParallelCommands(taskName, task, task.Cmds, vars, cyan, yellow, red)
+	} else {
+		err = runCommands(taskName, task, task.Cmds, vars, cyan, magenta, white, yellow, red, green)
+	}
+	var skipErr *skipError
+	if errors.As(err, &skipErr) {
+		if taskName == task.Name {
+			recordModule(task.Name, statusSkipped, time.Now(), nil)
+		}
+		logLifecycle("[%s] [%s] Module '%s' %s (exit code %d)\n", yellow(currentTime()), yellow("INFO"), cyan(taskName), yellow(word("skipped")), skipErr.code)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	logLifecycle("[%s] [%s] Module '%s' %s%s\n", yellow(currentTime()), yellow("INFO"), cyan(taskName), green(word("completed")), mark("success"))
 	return nil
 }
 
-func executeCommand(cmdStr string, silent bool, vars map[string]string) error {
-	cmdStr = replacePlaceholders(cmdStr, vars)
-	execCmd := exec.Command("sh", "-c", cmdStr)
+// runCommands executes cmds in order, stopping at the first one that fails.
+func runCommands(taskName string, task Task, cmds []Command, vars map[string]string, cyan, magenta, white, yellow, red, green func(a ...interface{}) string) error {
+	for _, cmd := range cmds {
+		if err := runCommand(taskName, task, cmd, vars, cyan, yellow, red); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCommand executes cmd, with retries, and reports its failure.
+func runCommand(taskName string, task Task, cmd Command, vars map[string]string, cyan, yellow, red func(a ...interface{}) string) error {
+	vars, release := pinRenders(vars)
+	defer release()
 
-	if silent {
-		execCmd.Stdout = nil
-		execCmd.Stderr = nil
+	if verbosity >= levelCommands || (task.ShowCmd && verbosity > levelNoLifecycle) {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s' $ %s\n", yellow(currentTime()), yellow("CMD"), cyan(taskName), maskSecrets(describeCommand(cmd, vars), vars))
+	}
+
+	err := executeCommand(taskName, cmd, task, vars, cyan, yellow)
+	for attempt := 1; err != nil && task.Retry.shouldRetry(err, attempt) && !control.isCancelled() && !errors.Is(err, errStopped); attempt++ {
+		logLifecycle("[%s] [%s] Module '%s' %s (attempt %d/%d): %v\n", yellow(currentTime()), yellow("INFO"), cyan(taskName), yellow(word("retrying")), attempt+1, task.Retry.Attempts, err)
+		time.Sleep(task.Retry.delay())
+		err = executeCommand(taskName, cmd, task, vars, cyan, yellow)
+	}
+	var skipErr *skipError
+	if errors.As(err, &skipErr) || errors.Is(err, errStopped) {
+		return err
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s': %v\n", yellow(currentTime()), red("ERROR"), cyan(taskName), err)
+
+		var cmdErr *commandError
+		if errors.As(err, &cmdErr) && len(cmdErr.output) > 0 {
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s': last %d lines of output:\n", yellow(currentTime()), red("ERROR"), cyan(taskName), len(cmdErr.output))
+			for _, line := range cmdErr.output {
+				fmt.Fprintf(os.Stderr, "    %s\n", line)
+			}
+		}
+		if debugOnFail && !control.isCancelled() {
+			debugShell(taskName, task, cmd, vars, cyan, yellow, red)
+		}
+		return fmt.Errorf("Module '%s' %s%s", taskName, red(word("errored")), mark("failure"))
+	}
+	return nil
+}
+
+func executeCommand(taskName string, cmd Command, task Task, vars map[string]string, cyan, yellow func(a ...interface{}) string) (err error) {
+	if cmd.isBuiltin() {
+		started := time.Now()
+		err = runBuiltinStep(cmd, task, vars)
+		auditLog.recordStep(taskName, cmd, vars, time.Since(started), err)
+		return err
+	}
+
+	execCmd := buildCommand(cmd, task, vars)
+	// The audit log gets the command as built, without wrappers.
+	argv := append([]string(nil), execCmd.Args...)
+
+	// Hidden output is kept in a small ring buffer so the end of it can be
+	// shown if the command fails.
+	var tail *tailBuffer
+	var stdout, stderr io.Writer
+	if showToolOutput(task.Silent) {
+		stdout, stderr = os.Stdout, os.Stderr
+		if pane := tui.output(task.Name); pane != nil {
+			stdout, stderr = pane, pane
+		}
 	} else {
-		execCmd.Stdout = os.Stdout
-		execCmd.Stderr = os.Stderr
+		lines := task.TailLines
+		if lines == 0 {
+			lines = defaultTailLines
+		}
+		if lines > 0 {
+			tail = newTailBuffer(lines)
+			stdout, stderr = tail, tail
+		}
+	}
+	if task.output != nil {
+		stdout = task.output
+	}
+	if capture := outputCapture(task.Name); capture != nil {
+		if stdout == nil {
+			stdout, stderr = capture, capture
+		} else if stdout == stderr {
+			stdout = io.MultiWriter(stdout, capture)
+			stderr = stdout
+		} else {
+			stdout, stderr = io.MultiWriter(stdout, capture), io.MultiWriter(stderr, capture)
+		}
+	}
+	// Captured stdout and stderr go to files of their own, so a tool's
+	// results aren't mixed with its warnings.
+	capture, err := openCapture(taskName)
+	if err != nil {
+		return fmt.Errorf("capturing output: %w", err)
+	}
+	defer capture.close()
+	if capture != nil {
+		if stdout == nil {
+			stdout, stderr = capture.stdout, capture.stderr
+		} else {
+			stdout, stderr = io.MultiWriter(stdout, capture.stdout), io.MultiWriter(stderr, capture.stderr)
+		}
+	}
+	if cmd.Stdin != nil {
+		input, closeInput, err := cmd.Stdin.open(vars)
+		defer closeInput()
+		if err != nil {
+			return err
+		}
+		execCmd.Stdin = input
+	} else if task.input != nil {
+		execCmd.Stdin = task.input.reader()
 	}
 
-	err := execCmd.Run()
+	// Output is watched for retry patterns alongside wherever it goes.
+	var watcher *patternWatcher
+	if task.Retry != nil && len(task.Retry.patterns) > 0 {
+		watcher = &patternWatcher{policy: task.Retry}
+		if stdout == stderr {
+			stdout = teeWriter(stdout, watcher)
+			stderr = stdout
+		} else {
+			stdout, stderr = teeWriter(stdout, watcher), teeWriter(stderr, watcher)
+		}
+	}
+	interval, err := task.heartbeatInterval(vars)
 	if err != nil {
-		return fmt.Errorf("command execution failed: %w", err)
+		return err
+	}
+	if interval > 0 {
+		beat := newHeartbeat(taskName, interval)
+		if stdout == stderr {
+			stdout = beat.writer(stdout)
+			stderr = stdout
+		} else {
+			stdout, stderr = beat.writer(stdout), beat.writer(stderr)
+		}
+		go beat.watch(execCmd, yellow, cyan)
+		defer beat.stop()
+	}
+	execCmd.Stdout, execCmd.Stderr = stdout, stderr
+
+	// proxychains wraps the command first, as it only passes its own
+	// arguments through to the command.
+	if proxy := task.proxy(); proxy != nil && proxy.Proxychains {
+		cleanup, err := applyProxychains(execCmd, proxy, vars)
+		defer cleanup()
+		if err != nil {
+			// Running without it would send the traffic around the proxy.
+			return fmt.Errorf("command not run through the proxy: %w", err)
+		}
+	}
+
+	// The priority wraps the command before the limits do, so prlimit
+	// applies to nice and ionice and, through them, to the command.
+	if task.Priority != nil {
+		if err := applyPriority(execCmd, task.Priority); err != nil {
+			warnOnce(task.Name, err, yellow, cyan)
+		}
+	}
+	if task.Limits != nil {
+		cleanup, err := applyLimits(execCmd, task.Limits)
+		defer cleanup()
+		if err != nil {
+			warnOnce(task.Name, err, yellow, cyan)
+		}
+	}
+
+	// Modules stopped from the terminal UI run no more commands, except
+	// their after hooks.
+	if !tui.track(task.Name, execCmd) && taskName != task.Name+" (after)" {
+		return errStopped
+	}
+	defer tui.untrack(task.Name, execCmd)
+
+	started := time.Now()
+	err = control.run(execCmd)
+	duration := time.Since(started)
+	if err != nil && tui.stopped(task.Name) != "" {
+		err = errStopped
+	}
+	addUsage(task.Name, execCmd.ProcessState)
+	defer func() {
+		auditLog.record(taskName, task, argv, cmd.Stdin, vars, execCmd.ProcessState, duration, err, capture)
+	}()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code := exitErr.ExitCode()
+		switch {
+		case containsInt(task.AllowedExit, code):
+			return nil
+		case containsInt(task.SkipExit, code):
+			return &skipError{code: code}
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("command execution failed: %w", err)
+		if tail != nil {
+			err = &commandError{err: err, output: tail.Lines()}
+		}
+		if watcher != nil && watcher.Matched() {
+			err = &retryableError{err}
+		}
+		return err
 	}
 	return nil
 }
 
-func replacePlaceholders(input string, vars map[string]string) string {
-	for key, value := range vars {
-		placeholder := fmt.Sprintf("{{%s}}", key)
-		input = strings.ReplaceAll(input, placeholder, value)
+// buildCommand prepares cmd for execution. Argv commands are run directly,
+// with placeholders substituted per argument, so variable values can never be
+// interpreted by a shell.
+func buildCommand(cmd Command, task Task, vars map[string]string) *exec.Cmd {
+	var execCmd *exec.Cmd
+	if len(cmd.Argv) > 0 {
+		argv := make([]string, len(cmd.Argv))
+		for i, arg := range cmd.Argv {
+			argv[i] = replacePlaceholders(arg, vars)
+		}
+		execCmd = exec.Command(argv[0], argv[1:]...)
+	} else {
+		execCmd = shellCommand(task.Shell, replacePlaceholders(cmd.Shell, vars))
 	}
-	return input
+
+	execCmd.Env = commandEnv(task, vars)
+	return execCmd
+}
+
+// commandEnv returns the environment for the commands of task: the task's
+// env entries and proxy variables merged over rayder's own environment, or
+// only those when env_clean is set. A nil result inherits the environment.
+func commandEnv(task Task, vars map[string]string) []string {
+	return mergeEnv(taskEnv(task, vars), task.EnvClean)
+}
+
+// taskEnv returns the variables task sets for its commands: its proxy
+// variables and env entries, resolved.
+func taskEnv(task Task, vars map[string]string) map[string]string {
+	entries := task.proxy().env(vars)
+	for key, value := range task.Env {
+		entries[key] = replacePlaceholders(value, vars)
+	}
+	return entries
+}
+
+// mergeEnv returns entries merged over rayder's own environment, or only
+// entries when clean is set. A nil result inherits the environment.
+func mergeEnv(entries map[string]string, clean bool) []string {
+	if len(entries) == 0 && !clean {
+		return nil
+	}
+
+	// Start from a non-nil slice so env_clean without entries really runs
+	// with an empty environment.
+	env := []string{}
+	if !clean {
+		for _, kv := range os.Environ() {
+			key, _, _ := strings.Cut(kv, "=")
+			if _, overridden := entries[key]; !overridden {
+				env = append(env, kv)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		env = append(env, key+"="+entries[key])
+	}
+	return env
+}
+
+func replacePlaceholders(input string, vars map[string]string) string {
+	return renderTemplate(input, vars)
 }
 
 func currentTime() string {
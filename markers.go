@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// markers end the lifecycle lines: start for modules, stages and hooks
+// starting, success and failure for their outcome. Some log pipelines choke
+// on emoji, so they can be changed or turned off in the user config.
+var markers = map[string]string{
+	"start":   "⚡",
+	"success": "✅",
+	"failure": "❌",
+}
+
+// statusWords maps the status words of the lifecycle lines to what is shown
+// instead, as set in the user config. The run history keeps the originals.
+var statusWords = map[string]string{}
+
+// knownStatusWords are the words status_words can replace.
+var knownStatusWords = []string{"cancelled", "completed", "errored", "excluded", "ready", "retrying", "running", "skipped", "starting"}
+
+// mark returns the marker of kind preceded by a space, or "" when it is
+// turned off.
+func mark(kind string) string {
+	if markers[kind] == "" {
+		return ""
+	}
+	return " " + markers[kind]
+}
+
+// word returns how status is shown in lifecycle lines.
+func word(status string) string {
+	if w, ok := statusWords[status]; ok {
+		return w
+	}
+	return status
+}
+
+// bannerOff reports whether banner, the banner setting of the user config,
+// turns the banner off rather than replacing it.
+func bannerOff(banner string) bool {
+	switch strings.ToLower(banner) {
+	case "off", "false", "no", "none":
+		return true
+	}
+	return false
+}
+
+// applyMarkers applies the marker and status word settings of config.
+func applyMarkers(config UserConfig) error {
+	for kind, marker := range config.Markers {
+		if _, ok := markers[kind]; !ok {
+			return fmt.Errorf("unknown marker %q, want start, success or failure", kind)
+		}
+		markers[kind] = marker
+	}
+	if config.NoEmoji {
+		for kind := range markers {
+			if _, set := config.Markers[kind]; !set {
+				markers[kind] = ""
+			}
+		}
+	}
+	for status, w := range config.StatusWords {
+		i := sort.SearchStrings(knownStatusWords, status)
+		if i == len(knownStatusWords) || knownStatusWords[i] != status {
+			return fmt.Errorf("unknown status word %q, want one of %s", status, strings.Join(knownStatusWords, ", "))
+		}
+		statusWords[status] = w
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MergeStep combines the outputs of several modules or instances into one
+// file, the fan-in at the end of most recon pipelines. With sort and unique
+// set it does what `cat ... | sort -u` does, without depending on either.
+type MergeStep struct {
+	Inputs []string `yaml:"inputs"`
+	Output string   `yaml:"output"`
+	Sort   bool     `yaml:"sort"`
+	Unique bool     `yaml:"unique"`
+}
+
+// describe renders the step with placeholders substituted.
+func (m *MergeStep) describe(vars map[string]string) string {
+	inputs := make([]string, len(m.Inputs))
+	for i, input := range m.Inputs {
+		inputs[i] = replacePlaceholders(input, vars)
+	}
+	var opts string
+	if m.Sort {
+		opts += " sorted"
+	}
+	if m.Unique {
+		opts += " unique"
+	}
+	return fmt.Sprintf("%s -> %s%s", strings.Join(inputs, " "), replacePlaceholders(m.Output, vars), opts)
+}
+
+// runMergeStep concatenates the non-empty, trimmed lines of the inputs, in the order
+// given and with glob patterns expanded in sorted order, into the output.
+// Patterns matching nothing are fine, as instances that found nothing may
+// not have written a file, but a plain path must exist.
+func runMergeStep(m *MergeStep, vars map[string]string) error {
+	if len(m.Inputs) == 0 || m.Output == "" {
+		return fmt.Errorf("merge step needs inputs and output")
+	}
+	output := replacePlaceholders(m.Output, vars)
+	outputAbs, _ := filepath.Abs(output)
+
+	var files []string
+	for _, input := range m.Inputs {
+		pattern := replacePlaceholders(input, vars)
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("merge failed: %w", err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return fmt.Errorf("merge failed: %s does not exist", pattern)
+		}
+		for _, match := range matches {
+			// The output of an earlier run isn't merged into itself.
+			if abs, _ := filepath.Abs(match); abs != outputAbs {
+				files = append(files, match)
+			}
+		}
+	}
+
+	var lines []string
+	seen := make(map[string]bool)
+	for _, file := range files {
+		fileLines, err := readLines(file)
+		if err != nil {
+			return fmt.Errorf("merge failed: %w", err)
+		}
+		for _, line := range fileLines {
+			if m.Unique && seen[line] {
+				continue
+			}
+			seen[line] = true
+			lines = append(lines, line)
+		}
+	}
+	if m.Sort {
+		sort.Strings(lines)
+	}
+
+	out, err := createFile(output)
+	if err != nil {
+		return fmt.Errorf("merge failed: %w", err)
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	for _, line := range lines {
+		w.WriteString(line)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("merge failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Output levels selected with -q/-qq/-qqq and -v/-vv. Each level keeps
+// everything shown by the levels above it.
+const (
+	levelNoOutput    = -3 // -qqq: tool output is discarded too
+	levelNoLifecycle = -2 // -qq: module lifecycle lines are hidden
+	levelNoBanner    = -1 // -q: the banner is hidden
+	levelDefault     = 0
+	levelCommands    = 1 // -v: resolved commands are echoed
+	levelDebug       = 2 // -vv: scheduling details are logged
+)
+
+// verbosity is the output level of the current run.
+var verbosity = levelDefault
+
+// logLifecycle prints module lifecycle lines (running, completed, skipped,
+// ...) unless they were silenced with -qq. Errors are always printed
+// directly.
+func logLifecycle(format string, a ...interface{}) {
+	if verbosity > levelNoLifecycle {
+		fmt.Fprintf(os.Stderr, format, a...)
+	}
+}
+
+// logDebug prints details only shown with -vv.
+func logDebug(format string, a ...interface{}) {
+	if verbosity >= levelDebug {
+		fmt.Fprintf(os.Stderr, format, a...)
+	}
+}
+
+// moduleWarnings remembers the warnings given about each module.
+var moduleWarnings sync.Map
+
+// warnOnce warns about err in module, unless the same warning was given
+// before. Settings that can't be honoured are reported like this rather
+// than for every command.
+func warnOnce(module string, err error, yellow, cyan func(a ...interface{}) string) {
+	if _, warned := moduleWarnings.LoadOrStore(module+"\x00"+err.Error(), true); !warned {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s': %v\n", yellow(currentTime()), yellow("WARN"), cyan(module), err)
+	}
+}
+
+// showToolOutput reports whether the output of commands is passed through
+// for a module with the given silent setting.
+func showToolOutput(silent bool) bool {
+	return !silent && verbosity > levelNoOutput
+}
+
+// defaultTailLines is how many lines of a silenced command's output are
+// shown when it fails.
+const defaultTailLines = 20
+
+// tailBuffer is an io.Writer keeping only the last n lines written to it.
+type tailBuffer struct {
+	mu      sync.Mutex
+	n       int
+	lines   []string
+	partial []byte
+}
+
+func newTailBuffer(n int) *tailBuffer {
+	return &tailBuffer{n: n}
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data := append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		t.push(string(data[:i]))
+		data = data[i+1:]
+	}
+	t.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+func (t *tailBuffer) push(line string) {
+	t.lines = append(t.lines, strings.TrimRight(line, "\r"))
+	if len(t.lines) > t.n {
+		t.lines = t.lines[len(t.lines)-t.n:]
+	}
+}
+
+// Lines returns the buffered lines, including a trailing unterminated one.
+func (t *tailBuffer) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := append([]string(nil), t.lines...)
+	if len(t.partial) > 0 {
+		lines = append(lines, string(t.partial))
+		if len(lines) > t.n {
+			lines = lines[1:]
+		}
+	}
+	return lines
+}
+
+// commandError is returned for a failed command whose output was captured
+// instead of shown, carrying the last lines of that output.
+type commandError struct {
+	err    error
+	output []string
+}
+
+func (e *commandError) Error() string { return e.err.Error() }
+func (e *commandError) Unwrap() error { return e.err }
+
+// skipError stops a module whose command exited with one of its
+// skip_exit_codes. The module is reported as skipped rather than failed.
+type skipError struct {
+	code int
+}
+
+func (e *skipError) Error() string {
+	return fmt.Sprintf("skipped with exit code %d", e.code)
+}
+
+// instancesError fails a module because of its failed instances, which
+// already counted toward max_failures on their own.
+type instancesError struct {
+	msg string
+}
+
+func (e *instancesError) Error() string { return e.msg }
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// overrideFile holds local changes to a shared workflow: variables, and
+// fields of existing modules identified by name.
+type overrideFile struct {
+	Vars    map[string]VarSpec `yaml:"vars"`
+	Modules []yaml.MapSlice    `yaml:"modules"`
+}
+
+// overridePath returns the override file belonging to a workflow file:
+// recon.yaml is overridden by recon.override.yaml next to it.
+func overridePath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + ".override" + ext
+}
+
+// applyOverrides merges the override file of the workflow at path into
+// config, if there is one. Module fields given in the override replace those
+// of the workflow; fields it doesn't mention are kept.
+func applyOverrides(config *Config, path string) error {
+	overPath := overridePath(path)
+	content, err := ioutil.ReadFile(overPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var override overrideFile
+	if content, err = workflowYAML(overPath, content); err != nil {
+		return fmt.Errorf("parsing %s: %w", overPath, err)
+	}
+	if err := yaml.UnmarshalStrict(content, &override); err != nil {
+		return fmt.Errorf("parsing %s: %w", overPath, err)
+	}
+
+	for name, spec := range override.Vars {
+		if config.VarSpecs == nil {
+			config.VarSpecs = make(map[string]VarSpec)
+		}
+		config.VarSpecs[name] = spec
+		if !spec.Required || spec.Default != "" {
+			config.Vars[name] = spec.Default
+		}
+	}
+
+	for _, fields := range override.Modules {
+		name := ""
+		for _, field := range fields {
+			if field.Key == "name" {
+				name = fmt.Sprint(field.Value)
+			}
+		}
+
+		i := taskIndex(config.Tasks, name)
+		if i < 0 {
+			return fmt.Errorf("%s: module %q is not defined by the workflow", overPath, name)
+		}
+
+		// Decoding the override on top of the module only sets the fields
+		// it mentions.
+		raw, err := yaml.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		task := config.Tasks[i]
+		if err := yaml.UnmarshalStrict(raw, &task); err != nil {
+			return fmt.Errorf("%s: module %q: %w", overPath, name, err)
+		}
+		config.Tasks[i] = task
+	}
+	return nil
+}
+
+func taskIndex(tasks []Task, name string) int {
+	for i, task := range tasks {
+		if task.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// moduleSettings are the -set assignments, variables overridden for a single
+// module, as in -set module.subenum.THREADS=50.
+var moduleSettings settingList
+
+type moduleSetting struct {
+	module, name, value string
+}
+
+// settingList collects the -set flag, which can be repeated.
+type settingList []moduleSetting
+
+func (l *settingList) String() string {
+	var parts []string
+	for _, s := range *l {
+		parts = append(parts, "module."+s.module+"."+s.name+"="+s.value)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set parses module.NAME.VAR=value. Module names may contain dots, so the
+// variable is what follows the last one.
+func (l *settingList) Set(value string) error {
+	key, val, ok := strings.Cut(strings.TrimPrefix(value, "module."), "=")
+	dot := strings.LastIndex(key, ".")
+	if !strings.HasPrefix(value, "module.") || !ok || dot <= 0 || dot == len(key)-1 {
+		return fmt.Errorf("want module.NAME.VAR=value, got %q", value)
+	}
+	*l = append(*l, moduleSetting{module: key[:dot], name: key[dot+1:], value: val})
+	return nil
+}
+
+// applySettings sets the -set variables of the modules among tasks as with
+// values, which take precedence over the workflow's and the command line's
+// variables for that module alone.
+func applySettings(tasks []Task) {
+	for _, s := range moduleSettings {
+		i := taskIndex(tasks, s.module)
+		if i < 0 {
+			continue
+		}
+		// with maps can be shared with a template, so they are copied.
+		with := make(map[string]string, len(tasks[i].With)+1)
+		for key, value := range tasks[i].With {
+			with[key] = value
+		}
+		with[s.name] = s.value
+		tasks[i].With = with
+	}
+}
+
+// unknownSettingModules returns the modules given to -set that are neither
+// among tasks nor in the sub-workflow of one of them.
+func unknownSettingModules(tasks []Task) []string {
+	var unknown []string
+	for _, s := range moduleSettings {
+		found := taskIndex(tasks, s.module) >= 0
+		for _, task := range tasks {
+			if task.Workflow != "" && strings.HasPrefix(s.module, task.Name+":") {
+				found = true
+			}
+		}
+		if !found && !containsString(unknown, s.module) {
+			unknown = append(unknown, s.module)
+		}
+	}
+	return unknown
+}
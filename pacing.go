@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Pacing throttles modules: DelayBefore and DelayAfter are waited before and
+// after a module runs, each extended by a random amount up to Jitter. The
+// workflow-level pacing applies to modules that don't set their own.
+type Pacing struct {
+	DelayBefore string `yaml:"delay_before"`
+	DelayAfter  string `yaml:"delay_after"`
+	Jitter      string `yaml:"jitter"`
+}
+
+// withDefaults fills the fields p leaves empty from def.
+func (p Pacing) withDefaults(def Pacing) Pacing {
+	if p.DelayBefore == "" {
+		p.DelayBefore = def.DelayBefore
+	}
+	if p.DelayAfter == "" {
+		p.DelayAfter = def.DelayAfter
+	}
+	if p.Jitter == "" {
+		p.Jitter = def.Jitter
+	}
+	return p
+}
+
+// check rejects delays and jitter that aren't durations, so a typo doesn't
+// silently remove the throttling. Values with placeholders are checked when
+// the module runs.
+func (p Pacing) check() error {
+	for _, field := range []struct{ name, value string }{
+		{"delay_before", p.DelayBefore},
+		{"delay_after", p.DelayAfter},
+		{"jitter", p.Jitter},
+	} {
+		if field.value == "" || strings.Contains(field.value, "{{") {
+			continue
+		}
+		if d, err := parseDuration(field.value); err != nil || d < 0 {
+			return fmt.Errorf("invalid %s %q, expected a duration such as 2s", field.name, field.value)
+		}
+	}
+	return nil
+}
+
+// pause returns how long to wait for the given delay, with jitter added. A
+// zero delay is not jittered, so jitter alone does not slow modules down.
+func (p Pacing) pause(delay string, vars map[string]string) (time.Duration, error) {
+	if delay == "" {
+		return 0, nil
+	}
+	d, err := parseDuration(replacePlaceholders(delay, vars))
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid delay %q", replacePlaceholders(delay, vars))
+	}
+	if p.Jitter != "" && d > 0 {
+		j, err := parseDuration(replacePlaceholders(p.Jitter, vars))
+		if err != nil || j < 0 {
+			return 0, fmt.Errorf("invalid jitter %q", replacePlaceholders(p.Jitter, vars))
+		}
+		if j > 0 {
+			d += time.Duration(rand.Int63n(int64(j)))
+		}
+	}
+	return d, nil
+}
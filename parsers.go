@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of findings.
+const (
+	findingHost = "host"
+	findingPort = "port"
+	findingURL  = "url"
+	findingVuln = "vuln"
+)
+
+// Finding is a result of a scan in a form independent of the tool that
+// produced it, so reports and sinks can work with the results of any
+// module that has a parser.
+type Finding struct {
+	Kind     string `json:"kind"`
+	Module   string `json:"module"`
+	Source   string `json:"source"` // the parser
+	Host     string `json:"host,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	Service  string `json:"service,omitempty"`
+	Product  string `json:"product,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Name     string `json:"name,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Severity string `json:"severity,omitempty"`
+}
+
+// String describes the finding on one line.
+func (f Finding) String() string {
+	target := f.Host
+	if target == "" {
+		target = f.IP
+	}
+	switch f.Kind {
+	case findingPort:
+		s := fmt.Sprintf("%s:%d/%s", target, f.Port, f.Protocol)
+		for _, detail := range []string{f.Service, f.Product} {
+			if detail != "" {
+				s += " " + detail
+			}
+		}
+		return s
+	case findingURL:
+		return f.URL
+	case findingVuln:
+		s := fmt.Sprintf("[%s] %s", f.Severity, f.Name)
+		if at := firstNonEmpty(f.URL, target); at != "" {
+			s += " at " + at
+		}
+		return s
+	}
+	return target
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// parsers maps the names of the parser field to the functions turning an
+// output file of the tool into findings.
+var parsers = map[string]func(data []byte) ([]Finding, error){
+	"nmap-xml":     parseNmapXML,
+	"masscan-json": parseMasscanJSON,
+	"nuclei-jsonl": parseNucleiJSONL,
+}
+
+// Parser names the parser of a module's artifacts.
+type Parser string
+
+func (p *Parser) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err != nil {
+		return err
+	}
+	if _, ok := parsers[name]; !ok {
+		return fmt.Errorf("unknown parser %q, expected one of %s", name, strings.Join(parserNames(), ", "))
+	}
+	*p = Parser(name)
+	return nil
+}
+
+func parserNames() []string {
+	names := make([]string, 0, len(parsers))
+	for name := range parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseTaskFindings runs the parser of a completed task over its artifacts
+// and records the findings with the run. Artifacts that weren't written are
+// left out, as a scan finding nothing may not write its output.
+func parseTaskFindings(task Task, vars map[string]string) error {
+	parse := parsers[string(task.Parser)]
+	var findings []Finding
+	for _, path := range taskArtifacts(task, vars) {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		parsed, err := parse(data)
+		if err != nil {
+			return fmt.Errorf("parsing %s as %s: %w", path, task.Parser, err)
+		}
+		for i := range parsed {
+			parsed[i].Module = task.Name
+			parsed[i].Source = string(task.Parser)
+		}
+		findings = append(findings, parsed...)
+	}
+	recordFindings(findings)
+	return nil
+}
+
+type nmapRun struct {
+	Hosts []struct {
+		Status struct {
+			State string `xml:"state,attr"`
+		} `xml:"status"`
+		Addresses []struct {
+			Addr     string `xml:"addr,attr"`
+			AddrType string `xml:"addrtype,attr"`
+		} `xml:"address"`
+		Hostnames []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"hostnames>hostname"`
+		Ports []struct {
+			Protocol string `xml:"protocol,attr"`
+			Port     int    `xml:"portid,attr"`
+			State    struct {
+				State string `xml:"state,attr"`
+			} `xml:"state"`
+			Service struct {
+				Name    string `xml:"name,attr"`
+				Product string `xml:"product,attr"`
+				Version string `xml:"version,attr"`
+			} `xml:"service"`
+		} `xml:"ports>port"`
+	} `xml:"host"`
+}
+
+// parseNmapXML parses the output of nmap -oX: a host finding for every host
+// that is up and a port finding for every open port.
+func parseNmapXML(data []byte) ([]Finding, error) {
+	var run nmapRun
+	if err := xml.Unmarshal(data, &run); err != nil {
+		return nil, err
+	}
+	var findings []Finding
+	for _, host := range run.Hosts {
+		if host.Status.State != "" && host.Status.State != "up" {
+			continue
+		}
+		var ip, name string
+		for _, addr := range host.Addresses {
+			if addr.AddrType == "ipv4" || addr.AddrType == "ipv6" {
+				ip = addr.Addr
+				break
+			}
+		}
+		if len(host.Hostnames) > 0 {
+			name = host.Hostnames[0].Name
+		}
+		findings = append(findings, Finding{Kind: findingHost, Host: name, IP: ip})
+		for _, port := range host.Ports {
+			if port.State.State != "open" {
+				continue
+			}
+			product := strings.TrimSpace(port.Service.Product + " " + port.Service.Version)
+			findings = append(findings, Finding{
+				Kind:     findingPort,
+				Host:     name,
+				IP:       ip,
+				Port:     port.Port,
+				Protocol: port.Protocol,
+				Service:  port.Service.Name,
+				Product:  product,
+			})
+		}
+	}
+	return findings, nil
+}
+
+type masscanHost struct {
+	IP    string `json:"ip"`
+	Ports []struct {
+		Port   int    `json:"port"`
+		Proto  string `json:"proto"`
+		Status string `json:"status"`
+	} `json:"ports"`
+}
+
+// parseMasscanJSON parses the output of masscan -oJ: a port finding for
+// every open port. Older masscan versions leave a trailing comma before the
+// closing bracket, so the records are read one per line if the file isn't
+// valid JSON.
+func parseMasscanJSON(data []byte) ([]Finding, error) {
+	var hosts []masscanHost
+	if err := json.Unmarshal(data, &hosts); err != nil {
+		hosts = nil
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSuffix(strings.TrimSpace(scanner.Text()), ",")
+			if line == "" || line == "[" || line == "]" {
+				continue
+			}
+			var host masscanHost
+			if err := json.Unmarshal([]byte(line), &host); err != nil {
+				return nil, err
+			}
+			hosts = append(hosts, host)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var findings []Finding
+	for _, host := range hosts {
+		for _, port := range host.Ports {
+			if port.Status != "" && port.Status != "open" {
+				continue
+			}
+			findings = append(findings, Finding{Kind: findingPort, IP: host.IP, Port: port.Port, Protocol: port.Proto})
+		}
+	}
+	return findings, nil
+}
+
+// parseNucleiJSONL parses the output of nuclei -jsonl: a vuln finding for
+// every result.
+func parseNucleiJSONL(data []byte) ([]Finding, error) {
+	var findings []Finding
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var result struct {
+			TemplateID string `json:"template-id"`
+			Info       struct {
+				Name     string `json:"name"`
+				Severity string `json:"severity"`
+			} `json:"info"`
+			Host      string      `json:"host"`
+			IP        string      `json:"ip"`
+			Port      interface{} `json:"port"` // a string in some versions
+			MatchedAt string      `json:"matched-at"`
+		}
+		if err := json.Unmarshal([]byte(line), &result); err != nil {
+			return nil, err
+		}
+		var port int
+		if result.Port != nil {
+			port, _ = strconv.Atoi(fmt.Sprint(result.Port))
+		}
+		findings = append(findings, Finding{
+			Kind:     findingVuln,
+			Host:     result.Host,
+			IP:       result.IP,
+			Port:     port,
+			URL:      result.MatchedAt,
+			Name:     result.Info.Name,
+			ID:       result.TemplateID,
+			Severity: result.Info.Severity,
+		})
+	}
+	return findings, scanner.Err()
+}
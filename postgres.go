@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// database is the PostgreSQL database runs are recorded in besides the local
+// history, so several rayder instances share one view of their runs. It is
+// nil unless database_url is configured.
+var database *postgresDB
+
+// databaseURL returns the connection string of the database, from
+// RAYDER_DATABASE_URL or the user config.
+func databaseURL() string {
+	if url := os.Getenv("RAYDER_DATABASE_URL"); url != "" {
+		return url
+	}
+	return userConfig.DatabaseURL
+}
+
+// databaseTimeout bounds each statement, so an unreachable database doesn't
+// hold up the run.
+const databaseTimeout = 30 * time.Second
+
+// postgresDB records runs in PostgreSQL. The connection string is a URL or
+// key=value pairs, as for libpq, and passwords can live in ~/.pgpass.
+type postgresDB struct {
+	db *sql.DB
+
+	mu     sync.Mutex
+	failed bool // set after the first error, which stops further recording
+}
+
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS rayder_runs (
+	id        text PRIMARY KEY,
+	workflows jsonb NOT NULL,
+	vars      jsonb NOT NULL,
+	host      text,
+	started   timestamptz NOT NULL,
+	finished  timestamptz,
+	status    text NOT NULL
+);
+CREATE TABLE IF NOT EXISTS rayder_modules (
+	run_id      text NOT NULL REFERENCES rayder_runs (id) ON DELETE CASCADE,
+	name        text NOT NULL,
+	status      text NOT NULL,
+	started     timestamptz NOT NULL,
+	duration_ms bigint NOT NULL,
+	artifacts   jsonb NOT NULL,
+	PRIMARY KEY (run_id, name)
+);
+ALTER TABLE rayder_modules
+	ADD COLUMN IF NOT EXISTS cpu_ms bigint NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS peak_memory bigint NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS written bigint NOT NULL DEFAULT 0;
+`
+
+// openDatabase connects to the database and creates the tables rayder uses
+// when they don't exist yet.
+func openDatabase(url string) (*postgresDB, error) {
+	conn, err := sql.Open("pgx", url)
+	if err != nil {
+		return nil, fmt.Errorf("database_url: %w", err)
+	}
+	db := &postgresDB{db: conn}
+	if err := db.exec(postgresSchema); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// exec executes query with args.
+func (db *postgresDB) exec(query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), databaseTimeout)
+	defer cancel()
+	_, err := db.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// record executes query for recording a run. Recording never fails the run:
+// the first error is reported and the database is left alone afterwards.
+func (db *postgresDB) record(query string, args ...interface{}) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.failed {
+		return
+	}
+	if err := db.exec(query, args...); err != nil {
+		db.failed = true
+		fmt.Fprintf(os.Stderr, "Error: recording the run in the database, giving up for this run: %v\n", err)
+	}
+}
+
+func (db *postgresDB) recordRun(run RunRecord) {
+	workflows, _ := json.Marshal(run.Workflows)
+	vars, _ := json.Marshal(run.Vars)
+	host, _ := os.Hostname()
+
+	status, finished := "running", interface{}(nil)
+	if !run.Finished.IsZero() {
+		status, finished = run.Status, run.Finished
+	}
+	db.record(`INSERT INTO rayder_runs (id, workflows, vars, host, started, finished, status)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (id) DO UPDATE SET finished = EXCLUDED.finished, status = EXCLUDED.status`,
+		run.ID, string(workflows), string(vars), host, run.Started, finished, status)
+}
+
+// recordModule stores the outcome of a module. Like in the local history, a
+// module recorded as skipped stays skipped.
+func (db *postgresDB) recordModule(runID string, module ModuleRecord) {
+	artifacts, _ := json.Marshal(module.Artifacts)
+	if module.Artifacts == nil {
+		artifacts = []byte("[]")
+	}
+	db.record(`INSERT INTO rayder_modules (run_id, name, status, started, duration_ms, artifacts, cpu_ms, peak_memory, written)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (run_id, name) DO UPDATE SET status = EXCLUDED.status, duration_ms = EXCLUDED.duration_ms,
+	cpu_ms = EXCLUDED.cpu_ms, peak_memory = EXCLUDED.peak_memory, written = EXCLUDED.written
+WHERE rayder_modules.status <> 'skipped'`,
+		runID, module.Name, module.Status, module.Started, module.Duration.Milliseconds(), string(artifacts),
+		module.CPU.Milliseconds(), int64(module.PeakMemory), int64(module.Written))
+}
+
+// loadRuns returns the runs recorded in the database, most recent first, in
+// the form of the local history.
+func (db *postgresDB) loadRuns() ([]RunRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), databaseTimeout)
+	defer cancel()
+	var out string
+	err := db.db.QueryRowContext(ctx, `SELECT coalesce(json_agg(r ORDER BY r.started DESC), '[]') FROM (
+	SELECT runs.id, runs.workflows, runs.vars, runs.started, runs.finished, runs.status,
+		coalesce((SELECT json_agg(json_build_object(
+			'name', m.name, 'status', m.status, 'started', m.started,
+			'duration', m.duration_ms * 1000000, 'artifacts', m.artifacts,
+			'cpu', m.cpu_ms * 1000000, 'peak_memory', m.peak_memory, 'written', m.written) ORDER BY m.started)
+			FROM rayder_modules m WHERE m.run_id = runs.id), '[]') AS modules
+	FROM rayder_runs runs
+) r`).Scan(&out)
+	if err != nil {
+		return nil, fmt.Errorf("reading runs from the database: %w", err)
+	}
+
+	var runs []RunRecord
+	if err := json.Unmarshal([]byte(out), &runs); err != nil {
+		return nil, fmt.Errorf("reading runs from the database: %w", err)
+	}
+	return runs, nil
+}
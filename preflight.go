@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultEgressURL answers with the public IP address requests come from.
+const defaultEgressURL = "https://api.ipify.org"
+
+// Preflight lists network conditions checked before anything runs, so a
+// workflow never scans from the wrong place: without a working resolver,
+// with the VPN down, or from an unexpected public IP.
+type Preflight struct {
+	DNS       []string `yaml:"dns"`        // names that must resolve
+	URLs      []string `yaml:"urls"`       // URLs that must answer
+	Interface string   `yaml:"interface"`  // network interface that must be up, such as tun0
+	EgressIP  []string `yaml:"egress_ip"`  // addresses or CIDR ranges the public IP must be in
+	EgressURL string   `yaml:"egress_url"` // where the public IP is learnt from
+}
+
+func (p *Preflight) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type preflight Preflight
+	if err := unmarshal((*preflight)(p)); err != nil {
+		return err
+	}
+	for _, allowed := range p.EgressIP {
+		if strings.Contains(allowed, "{{") {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(allowed); err != nil && net.ParseIP(allowed) == nil {
+			return fmt.Errorf("invalid egress_ip %q, expected an address or a CIDR range", allowed)
+		}
+	}
+	return nil
+}
+
+// check runs the checks and returns the problems found, one per failed
+// check.
+func (p *Preflight) check(vars map[string]string) []string {
+	var problems []string
+
+	for _, name := range p.DNS {
+		name = replacePlaceholders(name, vars)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := net.DefaultResolver.LookupHost(ctx, name)
+		cancel()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("dns: %v", err))
+		}
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	for _, url := range p.URLs {
+		url = replacePlaceholders(url, vars)
+		resp, err := client.Get(url)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("url: %v", err))
+			continue
+		}
+		resp.Body.Close()
+	}
+
+	if p.Interface != "" {
+		name := replacePlaceholders(p.Interface, vars)
+		iface, err := net.InterfaceByName(name)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("interface: %s: %v", name, err))
+		case iface.Flags&net.FlagUp == 0:
+			problems = append(problems, fmt.Sprintf("interface: %s is down", name))
+		}
+	}
+
+	if len(p.EgressIP) > 0 {
+		if problem := p.checkEgress(client, vars); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+// checkEgress checks the public IP against egress_ip.
+func (p *Preflight) checkEgress(client *http.Client, vars map[string]string) string {
+	url := replacePlaceholders(p.EgressURL, vars)
+	if url == "" {
+		url = defaultEgressURL
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Sprintf("egress_ip: looking up the public IP: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return fmt.Sprintf("egress_ip: looking up the public IP: %v", err)
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return fmt.Sprintf("egress_ip: %s did not answer with an IP address", url)
+	}
+
+	expected := make([]string, len(p.EgressIP))
+	for i, allowed := range p.EgressIP {
+		allowed = replacePlaceholders(allowed, vars)
+		expected[i] = allowed
+		if _, network, err := net.ParseCIDR(allowed); err == nil && network.Contains(ip) {
+			return ""
+		}
+		if allowedIP := net.ParseIP(allowed); allowedIP != nil && allowedIP.Equal(ip) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("egress_ip: the public IP is %s, expected %s", ip, strings.Join(expected, ", "))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Priority lowers (or raises) the CPU and IO priority of a module's
+// processes, so background modules don't starve the ones that matter on a
+// shared machine.
+type Priority struct {
+	Nice int    `yaml:"nice"` // -20 (highest) to 19 (lowest)
+	IO   string `yaml:"io"`   // idle, best-effort or realtime, optionally with a level, as in best-effort:7
+
+	ioClass int // as ionice numbers them: 1 realtime, 2 best-effort, 3 idle
+	ioLevel int // 0 (highest) to 7, -1 for the default
+}
+
+// ioClasses maps the io names to ionice's class numbers.
+var ioClasses = map[string]int{"realtime": 1, "best-effort": 2, "idle": 3}
+
+func (p *Priority) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type priority Priority
+	if err := unmarshal((*priority)(p)); err != nil {
+		return err
+	}
+	if p.Nice < -20 || p.Nice > 19 {
+		return fmt.Errorf("invalid nice value %d, expected -20 to 19", p.Nice)
+	}
+
+	p.ioLevel = -1
+	if p.IO == "" {
+		return nil
+	}
+	name, level, hasLevel := strings.Cut(p.IO, ":")
+	class, ok := ioClasses[name]
+	if !ok {
+		return fmt.Errorf("invalid io priority %q, expected idle, best-effort or realtime", p.IO)
+	}
+	p.ioClass = class
+	if hasLevel {
+		n, err := strconv.Atoi(level)
+		if err != nil || n < 0 || n > 7 || class == ioClasses["idle"] {
+			return fmt.Errorf("invalid io priority %q, levels go from 0 to 7 and idle has none", p.IO)
+		}
+		p.ioLevel = n
+	}
+	return nil
+}
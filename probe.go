@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// probeSkip runs the skip_if and only_if probes of task, shell commands
+// whose exit status decides whether it runs, and returns why it is skipped,
+// or "" when it runs. Their output is discarded.
+func probeSkip(task Task, vars map[string]string) (string, error) {
+	if task.SkipIf != "" {
+		ok, err := runProbe(task, task.SkipIf, vars)
+		if err != nil {
+			return "", fmt.Errorf("skip_if: %w", err)
+		}
+		if ok {
+			return "skip_if succeeded: " + task.SkipIf, nil
+		}
+	}
+	if task.OnlyIf != "" {
+		ok, err := runProbe(task, task.OnlyIf, vars)
+		if err != nil {
+			return "", fmt.Errorf("only_if: %w", err)
+		}
+		if !ok {
+			return "only_if failed: " + task.OnlyIf, nil
+		}
+	}
+	return "", nil
+}
+
+// runProbe runs probe with the shell and environment of task and reports
+// whether it exited with 0. An error means it couldn't be run at all.
+func runProbe(task Task, probe string, vars map[string]string) (bool, error) {
+	cmd := buildCommand(Command{Shell: probe}, task, vars)
+	err := control.run(cmd)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return false, nil
+	}
+	return err == nil, err
+}
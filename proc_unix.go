@@ -0,0 +1,197 @@
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// setProcessGroup starts cmd in its own process group so the whole tree it
+// spawns can be signalled at once.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// signalProcessGroup sends sig to the process group of a command started
+// with setProcessGroup. kill selects SIGKILL instead of SIGTERM.
+func signalProcessGroup(cmd *exec.Cmd, kill bool) error {
+	sig := syscall.SIGTERM
+	if kill {
+		sig = syscall.SIGKILL
+	}
+	return syscall.Kill(-cmd.Process.Pid, sig)
+}
+
+// terminateTree sends SIGTERM, or SIGKILL when kill is set, to the process
+// pid, started with setProcessGroup, and its descendants. The process group
+// holds the descendants on every system; those that left it, such as
+// daemons, are found through /proc where there is one.
+func terminateTree(pid int, kill bool) {
+	sig := syscall.SIGTERM
+	if kill {
+		sig = syscall.SIGKILL
+	}
+	// The tree is read before anything is signalled, and the group goes
+	// first so a shell can't carry on with its script once its current
+	// command died.
+	tree := descendants(pid)
+	if syscall.Kill(-pid, sig) != nil {
+		syscall.Kill(pid, sig)
+	}
+	for _, p := range tree {
+		syscall.Kill(p, sig)
+	}
+}
+
+// descendants returns the descendants of pid, read from /proc.
+func descendants(pid int) []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	children := make(map[int][]int)
+	for _, entry := range entries {
+		child, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile("/proc/" + entry.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		// The command name in parentheses may contain spaces; the parent
+		// PID is the second field after it.
+		fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+		if len(fields) < 2 {
+			continue
+		}
+		if parent, err := strconv.Atoi(fields[1]); err == nil {
+			children[parent] = append(children[parent], child)
+		}
+	}
+
+	var result []int
+	queue := children[pid]
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		result = append(result, p)
+		queue = append(queue, children[p]...)
+	}
+	return result
+}
+
+// processUsage returns the CPU time and resident memory of pid and its
+// descendants, read from /proc.
+func processUsage(pid int) (cpu time.Duration, rss uint64, ok bool) {
+	// Kernel clock ticks are 100 per second on every common architecture.
+	const ticks = 100
+	for _, p := range append(descendants(pid), pid) {
+		stat, err := os.ReadFile("/proc/" + strconv.Itoa(p) + "/stat")
+		if err != nil {
+			continue
+		}
+		// utime and stime are the 12th and 13th fields after the command
+		// name, and rss (in pages) the 22nd.
+		fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+		if len(fields) < 22 {
+			continue
+		}
+		utime, _ := strconv.ParseUint(fields[11], 10, 64)
+		stime, _ := strconv.ParseUint(fields[12], 10, 64)
+		pages, _ := strconv.ParseUint(fields[21], 10, 64)
+		cpu += time.Duration(utime+stime) * time.Second / ticks
+		rss += pages * uint64(os.Getpagesize())
+		ok = true
+	}
+	return cpu, rss, ok
+}
+
+// commandUsage returns the peak resident memory and the bytes written to
+// storage of a finished command, from its rusage.
+func commandUsage(state *os.ProcessState) (peakMemory, written uint64) {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0, 0
+	}
+	// ru_maxrss is in kilobytes, except on macOS where it is in bytes.
+	peakMemory = uint64(rusage.Maxrss)
+	if runtime.GOOS != "darwin" {
+		peakMemory *= 1024
+	}
+	return peakMemory, uint64(rusage.Oublock) * 512
+}
+
+// applyPriority makes cmd, which has not been started, run with the
+// niceness and IO class of p, through nice and ionice. IO classes are only
+// supported on Linux.
+func applyPriority(cmd *exec.Cmd, p *Priority) error {
+	var err error
+	if p.ioClass != 0 {
+		if ionice, lookErr := exec.LookPath("ionice"); lookErr == nil && runtime.GOOS == "linux" {
+			args := []string{"-c", strconv.Itoa(p.ioClass)}
+			if p.ioLevel >= 0 {
+				args = append(args, "-n", strconv.Itoa(p.ioLevel))
+			}
+			wrapCommand(cmd, ionice, args...)
+		} else {
+			err = errors.New("io priority not applied, it needs ionice on Linux")
+		}
+	}
+	if p.Nice != 0 {
+		nice, lookErr := exec.LookPath("nice")
+		if lookErr != nil {
+			return errors.New("priority not applied, nice was not found in PATH")
+		}
+		wrapCommand(cmd, nice, "-n", strconv.Itoa(p.Nice))
+	}
+	return err
+}
+
+// freeDiskSpace returns the space available to unprivileged users on the
+// filesystem of path.
+func freeDiskSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
+
+// watchControlSignals pauses and resumes the run on SIGUSR1 and cancels it
+// on SIGUSR2.
+func watchControlSignals(yellow, red func(a ...interface{}) string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			handleControlSignal(sig == syscall.SIGUSR2, yellow, red)
+		}
+	}()
+}
+
+// sendControlSignal pauses or resumes (SIGUSR1), or cancels (SIGUSR2) the
+// run of another rayder process.
+func sendControlSignal(process *os.Process, cancel bool) error {
+	if cancel {
+		return process.Signal(syscall.SIGUSR2)
+	}
+	return process.Signal(syscall.SIGUSR1)
+}
+
+// lockFile takes an exclusive lock on file without waiting for it.
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
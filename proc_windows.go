@@ -0,0 +1,112 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalProcessGroup terminates the process. Windows has no SIGTERM, so both
+// modes kill it immediately.
+func signalProcessGroup(cmd *exec.Cmd, kill bool) error {
+	return cmd.Process.Kill()
+}
+
+// terminateTree terminates the process pid. Its descendants are left alone.
+func terminateTree(pid int, kill bool) {
+	if p, err := os.FindProcess(pid); err == nil {
+		p.Kill()
+	}
+}
+
+// processUsage is not implemented on Windows.
+func processUsage(pid int) (cpu time.Duration, rss uint64, ok bool) {
+	return 0, 0, false
+}
+
+// commandUsage is not implemented on Windows; only the CPU time of commands
+// is known there.
+func commandUsage(state *os.ProcessState) (peakMemory, written uint64) {
+	return 0, 0
+}
+
+// Windows priority classes, from the lowest.
+const (
+	idlePriorityClass        = 0x40
+	belowNormalPriorityClass = 0x4000
+	aboveNormalPriorityClass = 0x8000
+	highPriorityClass        = 0x80
+)
+
+// applyPriority maps the niceness of p to a priority class for cmd. Windows
+// has no IO classes like Linux.
+func applyPriority(cmd *exec.Cmd, p *Priority) error {
+	class := uint32(0)
+	switch {
+	case p.Nice >= 15:
+		class = idlePriorityClass
+	case p.Nice > 0:
+		class = belowNormalPriorityClass
+	case p.Nice <= -15:
+		class = highPriorityClass
+	case p.Nice < 0:
+		class = aboveNormalPriorityClass
+	}
+	if class != 0 {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.CreationFlags |= class
+	}
+	if p.ioClass != 0 {
+		return errors.New("io priority not applied, it is only supported on Linux")
+	}
+	return nil
+}
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// freeDiskSpace returns the space available to the user on the volume of
+// path.
+func freeDiskSpace(path string) (uint64, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if r, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&free)), 0, 0); r == 0 {
+		return 0, err
+	}
+	return free, nil
+}
+
+// watchControlSignals does nothing: Windows has no SIGUSR1 and SIGUSR2.
+func watchControlSignals(yellow, red func(a ...interface{}) string) {}
+
+func sendControlSignal(process *os.Process, cancel bool) error {
+	return errors.New("pausing and cancelling runs is not supported on Windows")
+}
+
+var lockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+)
+
+// lockFile takes an exclusive lock on file without waiting for it.
+func lockFile(file *os.File) error {
+	var overlapped syscall.Overlapped
+	r, _, err := lockFileEx.Call(file.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 0xFFFFFFFF, 0xFFFFFFFF, uintptr(unsafe.Pointer(&overlapped)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
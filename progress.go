@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// progress reports the progress of the run as JSON lines on a separate file
+// descriptor or file, for wrappers and GUIs that shouldn't have to parse the
+// human readable output. It is nil unless -progress-fd or -progress-file is
+// given.
+var progress *progressReporter
+
+type progressReporter struct {
+	mu       sync.Mutex
+	out      *os.File
+	started  time.Time
+	modules  map[string]bool // the workflow's own modules, which progress counts
+	finished map[string]bool
+}
+
+// progressEvent is one line of the progress stream.
+type progressEvent struct {
+	Event      string    `json:"event"` // run_started, module_started, module_finished or run_finished
+	Time       time.Time `json:"time"`
+	RunID      string    `json:"run_id,omitempty"`
+	Module     string    `json:"module,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	DurationMS int64     `json:"duration_ms,omitempty"`
+	Done       int       `json:"done"`
+	Total      int       `json:"total"`
+	Percent    float64   `json:"percent"`
+	ETASeconds *float64  `json:"eta_seconds,omitempty"`
+}
+
+// openProgress returns a reporter writing to the inherited descriptor fd, or
+// else to the file at path.
+func openProgress(fd int, path string) (*progressReporter, error) {
+	var out *os.File
+	if fd > 0 {
+		out = os.NewFile(uintptr(fd), "progress")
+		if _, err := out.Stat(); err != nil {
+			return nil, fmt.Errorf("descriptor %d is not open", fd)
+		}
+	} else {
+		var err error
+		if out, err = os.Create(path); err != nil {
+			return nil, err
+		}
+	}
+	return &progressReporter{out: out, finished: make(map[string]bool)}, nil
+}
+
+// runStarted starts counting the modules of tasks.
+func (p *progressReporter) runStarted(runID string, tasks []Task) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.started = time.Now()
+	p.modules = make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		p.modules[task.Name] = true
+	}
+	p.emit(progressEvent{Event: "run_started", RunID: runID})
+}
+
+func (p *progressReporter) moduleStarted(name string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.emit(progressEvent{Event: "module_started", Module: name})
+}
+
+// moduleFinished reports the outcome of a module. Only the first outcome of
+// a module counts, like in the run history.
+func (p *progressReporter) moduleFinished(name, status string, duration time.Duration) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.finished[name] {
+		return
+	}
+	p.finished[name] = true
+	p.emit(progressEvent{Event: "module_finished", Module: name, Status: status, DurationMS: duration.Milliseconds()})
+}
+
+func (p *progressReporter) runFinished(runID, status string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.emit(progressEvent{Event: "run_finished", RunID: runID, Status: status, DurationMS: time.Since(p.started).Milliseconds()})
+	p.out.Close()
+}
+
+// emit fills in the counts and writes event. Modules of sub-workflows are
+// reported but not counted, as their number isn't known up front. The ETA
+// comes from the durations of previous runs when there are any, and
+// otherwise assumes the remaining modules take as long as the finished ones
+// did on average.
+func (p *progressReporter) emit(event progressEvent) {
+	event.Time = time.Now()
+	event.Total = len(p.modules)
+	for name := range p.finished {
+		if p.modules[name] {
+			event.Done++
+		}
+	}
+	if event.Total > 0 {
+		event.Percent = math.Round(float64(event.Done*1000)/float64(event.Total)) / 10
+	}
+	if event.Event != "run_finished" {
+		elapsed := time.Since(p.started)
+		remaining := time.Duration(-1)
+		switch {
+		case estimates.runs > 0:
+			remaining = estimates.total - elapsed
+			if remaining < 0 {
+				remaining = 0
+			}
+		case event.Done > 0:
+			remaining = elapsed / time.Duration(event.Done) * time.Duration(event.Total-event.Done)
+		}
+		if remaining >= 0 {
+			eta := math.Round(remaining.Seconds()*10) / 10
+			event.ETASeconds = &eta
+		}
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	// A reader that went away must not stop the run.
+	p.out.Write(append(line, '\n'))
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// defaultProxy applies to modules whose workflow sets no proxy; set with
+// -proxy.
+var defaultProxy *Proxy
+
+// proxyTypes maps proxy URL schemes to proxychains' proxy types.
+var proxyTypes = map[string]string{
+	"http": "http", "https": "http",
+	"socks4": "socks4", "socks4a": "socks4",
+	"socks5": "socks5", "socks5h": "socks5",
+}
+
+// Proxy routes the traffic of a module's commands through a proxy, such as
+// Burp or a SOCKS tunnel. The proxy is exported in the environment variables
+// most tools honour and, for tools that ignore them, commands can be run
+// through proxychains.
+type Proxy struct {
+	URL             string `yaml:"url"`              // such as http://127.0.0.1:8080 or socks5://127.0.0.1:9050
+	NoProxy         string `yaml:"no_proxy"`         // hosts not to proxy, as in NO_PROXY
+	Proxychains     bool   `yaml:"proxychains"`      // run commands through proxychains
+	ProxychainsConf string `yaml:"proxychains_conf"` // proxychains config to use instead of one made from url
+
+	off bool
+}
+
+// UnmarshalYAML accepts a URL alone, as in proxy: http://127.0.0.1:8080,
+// off to disable the workflow's proxy for a module, or the full form.
+func (p *Proxy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var scalar string
+	if err := unmarshal(&scalar); err == nil {
+		if scalar == "off" || scalar == "none" {
+			p.off = true
+			return nil
+		}
+		p.URL = scalar
+	} else {
+		type proxy Proxy
+		if err := unmarshal((*proxy)(p)); err != nil {
+			return err
+		}
+	}
+	if p.URL == "" && p.ProxychainsConf == "" {
+		return fmt.Errorf("proxy needs a url")
+	}
+	if p.URL != "" && !strings.Contains(p.URL, "{{") {
+		if _, err := parseProxyURL(p.URL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseProxyURL parses a proxy URL, which must have a scheme proxychains
+// knows and a host.
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid proxy url %q", raw)
+	}
+	if _, ok := proxyTypes[u.Scheme]; !ok {
+		return nil, fmt.Errorf("invalid proxy url %q, expected an http, https, socks4 or socks5 url", raw)
+	}
+	return u, nil
+}
+
+// proxy returns the proxy of task's commands, or nil for none.
+func (t Task) proxy() *Proxy {
+	p := t.Proxy
+	if p == nil {
+		p = defaultProxy
+	}
+	if p == nil || p.off {
+		return nil
+	}
+	return p
+}
+
+// env returns the environment variables exporting the proxy, in both the
+// upper and lower case spellings tools look for.
+func (p *Proxy) env(vars map[string]string) map[string]string {
+	env := map[string]string{}
+	if p == nil {
+		return env
+	}
+	if proxyURL := replacePlaceholders(p.URL, vars); proxyURL != "" {
+		for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY"} {
+			env[name] = proxyURL
+			env[strings.ToLower(name)] = proxyURL
+		}
+	}
+	if noProxy := replacePlaceholders(p.NoProxy, vars); noProxy != "" {
+		env["NO_PROXY"] = noProxy
+		env["no_proxy"] = noProxy
+	}
+	return env
+}
+
+// bypassProxy reports whether host is matched by noProxy, a comma separated
+// list as in NO_PROXY: "*", host names, which also match their subdomains,
+// IP addresses and CIDR ranges.
+func bypassProxy(host, noProxy string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(entry, ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyProxychains makes cmd, which has not been started, run through
+// proxychains, and returns a function to call once it has exited. Without a
+// proxychains_conf, a config chaining only the proxy's url is written to a
+// temporary file.
+func applyProxychains(cmd *exec.Cmd, p *Proxy, vars map[string]string) (cleanup func(), err error) {
+	cleanup = func() {}
+	path, err := exec.LookPath("proxychains4")
+	if err != nil {
+		if path, err = exec.LookPath("proxychains"); err != nil {
+			return cleanup, fmt.Errorf("proxychains was not found in PATH")
+		}
+	}
+
+	conf := replacePlaceholders(p.ProxychainsConf, vars)
+	if conf == "" {
+		file, err := writeProxychainsConf(replacePlaceholders(p.URL, vars))
+		if err != nil {
+			return cleanup, err
+		}
+		conf = file
+		cleanup = func() { os.Remove(file) }
+	}
+
+	// proxychains takes no "--", so it can't go through wrapCommand.
+	cmd.Args = append([]string{filepath.Base(path), "-q", "-f", conf, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = path
+	return cleanup, nil
+}
+
+// writeProxychainsConf writes a proxychains config for the proxy at
+// proxyURL and returns its path.
+func writeProxychainsConf(proxyURL string) (string, error) {
+	u, err := parseProxyURL(proxyURL)
+	if err != nil {
+		return "", err
+	}
+	// proxychains wants the proxy as an address.
+	host := u.Hostname()
+	if net.ParseIP(host) == nil {
+		addrs, err := net.LookupHost(host)
+		if err != nil {
+			return "", fmt.Errorf("resolving the proxy: %w", err)
+		}
+		host = addrs[0]
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	if port == "" {
+		port = "1080"
+	}
+	entry := fmt.Sprintf("%s %s %s", proxyTypes[u.Scheme], host, port)
+	if user := u.User.Username(); user != "" {
+		password, _ := u.User.Password()
+		entry += " " + user + " " + password
+	}
+
+	file, err := os.CreateTemp("", "rayder-proxychains-*.conf")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	_, err = fmt.Fprintf(file, "strict_chain\nproxy_dns\nquiet_mode\n\n[ProxyList]\n%s\n", entry)
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// defaultRegistry is used when neither -registry nor RAYDER_REGISTRY is set.
+const defaultRegistry = "github.com/devanshbatham/rayder-workflows"
+
+// RegistryEntry describes a workflow published in a registry. Index files
+// list entries explicitly; git registries expose every workflow file in the
+// repository, named after its path.
+type RegistryEntry struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Tags        []string `yaml:"tags"`
+	URL         string   `yaml:"url"`
+	SHA256      string   `yaml:"sha256"`
+
+	// path is set for entries of git registries instead of URL.
+	path string
+}
+
+type registryIndex struct {
+	Workflows []RegistryEntry `yaml:"workflows"`
+}
+
+// workflowsDir is where pulled workflows are stored.
+func workflowsDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("locating home directory: %w", err)
+	}
+	return filepath.Join(home, ".rayder", "workflows"), nil
+}
+
+// installedWorkflowPath returns the path of a pulled workflow if name refers
+// to one, so `-w subdomain-enum` or `-w team/recon` works for workflows
+// fetched with `pull`.
+func installedWorkflowPath(name string) (string, bool) {
+	if !validWorkflowName(name) || filepath.Ext(name) != "" {
+		return "", false
+	}
+	if _, err := os.Stat(name); err == nil {
+		return "", false
+	}
+
+	dir, err := workflowsDir()
+	if err != nil {
+		return "", false
+	}
+
+	path := filepath.Join(dir, filepath.FromSlash(name)+".yaml")
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// validWorkflowName reports whether name, a registry name such as
+// subdomain-enum or team/recon, can be installed below the workflows
+// directory without leaving it.
+func validWorkflowName(name string) bool {
+	if name == "" || strings.ContainsRune(name, '\\') || path.IsAbs(name) {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func registryFlags(name string) (*flag.FlagSet, *string, *bool) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	registry := os.Getenv("RAYDER_REGISTRY")
+	if registry == "" {
+		registry = userConfig.Registry
+	}
+	if registry == "" {
+		registry = defaultRegistry
+	}
+	reg := fs.String("registry", registry, "Registry to use: a git repository (host/org/repo) or an index URL")
+	refresh := fs.Bool("refresh", false, "Re-fetch the registry instead of using the cache")
+	return fs, reg, refresh
+}
+
+// loadRegistry returns the entries of registry. Registries ending in .yaml,
+// .yml or .json are index files fetched over HTTP(S); anything else is
+// treated as a git repository of workflows.
+func loadRegistry(registry string, refresh bool) ([]RegistryEntry, error) {
+	switch strings.ToLower(filepath.Ext(registry)) {
+	case ".yaml", ".yml", ".json":
+		return loadRegistryIndex(registry, refresh)
+	}
+
+	cacheDir, err := workflowCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	repo, version, _ := strings.Cut(registry, "@")
+	clone, err := cloneRepo(repo, version, filepath.Join(cacheDir, "registry"), refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []RegistryEntry
+	err = filepath.Walk(clone, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		ext := filepath.Ext(path)
+		if ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+
+		var config Config
+		content, err := os.ReadFile(path)
+		if err != nil || yaml.Unmarshal(content, &config) != nil || len(config.Tasks) == 0 {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(clone, path)
+		entries = append(entries, RegistryEntry{
+			Name:        strings.TrimSuffix(filepath.ToSlash(rel), ext),
+			Description: strings.TrimSpace(config.Usage),
+			path:        path,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+func loadRegistryIndex(url string, refresh bool) ([]RegistryEntry, error) {
+	cacheDir, err := workflowCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := fetchHTTPWorkflow(url, filepath.Join(cacheDir, "registry"), refresh)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is a subset of YAML, so one decoder handles both index formats.
+	var index registryIndex
+	if err := yaml.Unmarshal(content, &index); err != nil {
+		return nil, fmt.Errorf("parsing registry index: %w", err)
+	}
+	return index.Workflows, nil
+}
+
+func runPullCommand(args []string) int {
+	fs, registry, refresh := registryFlags("pull")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: rayder pull [-registry REGISTRY] name...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	entries, err := loadRegistry(*registry, *refresh)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
+		return 1
+	}
+
+	dir, err := workflowsDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	status := 0
+	for _, name := range fs.Args() {
+		if err := pullWorkflow(entries, name, dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error pulling %s: %v\n", name, err)
+			status = 1
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Pulled %s, run it with: rayder -w %s\n", name, name)
+	}
+	return status
+}
+
+func pullWorkflow(entries []RegistryEntry, name, dir string) error {
+	for _, entry := range entries {
+		if entry.Name != name {
+			continue
+		}
+
+		var content []byte
+		var err error
+		if entry.path != "" {
+			content, err = os.ReadFile(entry.path)
+		} else {
+			content, err = fetchWorkflowURL(entry.URL)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Index entries can pin a checksum; verify it before anything is
+		// written where -w would pick it up.
+		if entry.SHA256 != "" {
+			sum := sha256.Sum256(content)
+			want := strings.ToLower(strings.TrimPrefix(entry.SHA256, "sha256:"))
+			if got := hex.EncodeToString(sum[:]); got != want {
+				return fmt.Errorf("checksum mismatch: expected sha256 %s, got %s", want, got)
+			}
+		}
+
+		// Namespaces are kept as directories, so team-a/recon and
+		// team-b/recon don't overwrite each other.
+		if !validWorkflowName(name) {
+			return fmt.Errorf("invalid workflow name %q", name)
+		}
+		dest := filepath.Join(dir, filepath.FromSlash(name)+".yaml")
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(dest, content, 0644)
+	}
+	return fmt.Errorf("no workflow named %q in registry", name)
+}
+
+func runListCommand(args []string) int {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs.Parse(args)
+
+	dir, err := workflowsDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	var names []string
+	err = filepath.WalkDir(dir, func(file string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && file == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || filepath.Ext(file) != ".yaml" {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(strings.TrimSuffix(rel, ".yaml")))
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(names) == 0 {
+		fmt.Fprintln(os.Stderr, "No workflows installed, fetch one with: rayder pull <name>")
+		return 0
+	}
+
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return 0
+}
+
+func runSearchCommand(args []string) int {
+	fs, registry, refresh := registryFlags("search")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: rayder search [-registry REGISTRY] [term]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	entries, err := loadRegistry(*registry, *refresh)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading registry: %v\n", err)
+		return 1
+	}
+
+	term := strings.ToLower(strings.Join(fs.Args(), " "))
+	for _, entry := range entries {
+		haystack := strings.ToLower(entry.Name + " " + entry.Description + " " + strings.Join(entry.Tags, " "))
+		if !strings.Contains(haystack, term) {
+			continue
+		}
+
+		fmt.Print(entry.Name)
+		if len(entry.Tags) > 0 {
+			fmt.Printf(" [%s]", strings.Join(entry.Tags, ", "))
+		}
+		if entry.Description != "" {
+			fmt.Printf(" - %s", strings.SplitN(entry.Description, "\n", 2)[0])
+		}
+		fmt.Println()
+	}
+	return 0
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// remoteCacheTTL is how long a downloaded workflow is reused before it is
+// fetched again.
+const remoteCacheTTL = time.Hour
+
+// maxWorkflowSize bounds the size of a workflow fetched over HTTP(S).
+const maxWorkflowSize = 10 << 20
+
+// isRemoteWorkflow reports whether ref points at a workflow that has to be
+// fetched, either over HTTP(S) or from a git repository in the
+// host/org/repo//path/to/workflow.yaml@ref form. A local file is never
+// remote, even if its path contains //.
+func isRemoteWorkflow(ref string) bool {
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		return true
+	}
+
+	repo, _, found := strings.Cut(ref, "//")
+	if !found || !looksLikeRepo(repo) {
+		return false
+	}
+	_, err := os.Stat(ref)
+	return err != nil
+}
+
+// looksLikeRepo reports whether repo is a git repository reference:
+// host/org/repo, with a host name containing a dot, or git@host:org/repo.
+func looksLikeRepo(repo string) bool {
+	if strings.HasPrefix(repo, "git@") {
+		return strings.Contains(repo, ":")
+	}
+	parts := strings.Split(repo, "/")
+	if len(parts) < 3 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return strings.Contains(parts[0], ".") && !strings.HasPrefix(parts[0], ".")
+}
+
+// fetchWorkflow makes the remote workflow ref available locally and returns
+// the path to the cached copy. When refresh is set any cached copy is
+// discarded first.
+func fetchWorkflow(ref string, refresh bool) (string, error) {
+	cacheDir, err := workflowCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		return fetchHTTPWorkflow(ref, filepath.Join(cacheDir, "http"), refresh)
+	}
+	return fetchGitWorkflow(ref, filepath.Join(cacheDir, "git"), refresh)
+}
+
+func workflowCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("locating cache directory: %w", err)
+	}
+	return filepath.Join(dir, "rayder", "workflows"), nil
+}
+
+func cacheKey(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func fetchHTTPWorkflow(url, dir string, refresh bool) (string, error) {
+	cached := filepath.Join(dir, cacheKey(url)+filepath.Ext(url))
+
+	info, statErr := os.Stat(cached)
+	if statErr == nil && !refresh && time.Since(info.ModTime()) < remoteCacheTTL {
+		return cached, nil
+	}
+
+	err := downloadWorkflow(url, cached)
+	if err != nil && statErr == nil && !refresh {
+		fmt.Fprintf(os.Stderr, "Warning: %v, using cached copy from %s\n", err, info.ModTime().Format("2006-01-02 15:04:05"))
+		return cached, nil
+	}
+	return cached, err
+}
+
+func downloadWorkflow(url, dest string) error {
+	content, err := fetchWorkflowURL(url)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dest, content, 0644)
+}
+
+// fetchWorkflowURL downloads the workflow at url, giving up after 30 seconds
+// or when it is larger than maxWorkflowSize.
+func fetchWorkflowURL(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxWorkflowSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", url, err)
+	}
+	if len(content) > maxWorkflowSize {
+		return nil, fmt.Errorf("fetching %s: larger than %d MiB", url, maxWorkflowSize>>20)
+	}
+	return content, nil
+}
+
+// fetchGitWorkflow clones the repository part of ref (host/org/repo) into the
+// cache and returns the path of the workflow inside it.
+func fetchGitWorkflow(ref, dir string, refresh bool) (string, error) {
+	repo, path, _ := strings.Cut(ref, "//")
+	path, version, _ := strings.Cut(path, "@")
+	if repo == "" || path == "" {
+		return "", fmt.Errorf("invalid git workflow reference %q, expected host/org/repo//path@ref", ref)
+	}
+
+	// The path is read from the clone, so it must not leave it.
+	if filepath.IsAbs(path) || strings.HasPrefix(path, "/") {
+		return "", fmt.Errorf("invalid git workflow reference %q, the path must be relative to the repository", ref)
+	}
+
+	clone, err := cloneRepo(repo, version, dir, refresh)
+	if err != nil {
+		return "", err
+	}
+	workflow := filepath.Join(clone, filepath.FromSlash(path))
+	if rel, err := filepath.Rel(clone, workflow); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid git workflow reference %q, the path leaves the repository", ref)
+	}
+	return workflow, nil
+}
+
+// cloneRepo shallow clones repo (host/org/repo or a full git URL) at version
+// into the cache directory dir and returns the clone's path. Clones of a
+// pinned version are reused as is; clones of the default branch are pulled
+// once the cache TTL has passed.
+func cloneRepo(repo, version, dir string, refresh bool) (string, error) {
+	url := repo
+	if !strings.Contains(url, "://") && !strings.HasPrefix(url, "git@") {
+		url = "https://" + url
+	}
+
+	clone := filepath.Join(dir, cacheKey(repo+"@"+version))
+	if refresh {
+		os.RemoveAll(clone)
+	}
+
+	info, err := os.Stat(clone)
+	switch {
+	case err != nil:
+		args := []string{"clone", "--quiet", "--depth", "1"}
+		if version != "" {
+			args = append(args, "--branch", version)
+		}
+		args = append(args, url, clone)
+		if err := runGit(args...); err != nil {
+			os.RemoveAll(clone)
+			return "", fmt.Errorf("cloning %s: %w", url, err)
+		}
+	case version == "" && time.Since(info.ModTime()) >= remoteCacheTTL:
+		if err := runGit("-C", clone, "pull", "--quiet", "--ff-only"); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: updating %s failed (%v), using cached copy\n", url, err)
+		} else {
+			now := time.Now()
+			os.Chtimes(clone, now, now)
+		}
+	}
+
+	return clone, nil
+}
+
+func runGit(args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
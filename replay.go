@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// runReplayCommand runs the commands of a run recorded in an audit log
+// again, exactly as they were resolved then. Commands run one after the
+// other, in the order they finished.
+func runReplayCommand(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	runID := fs.String("run", "", "ID of the run to replay, the last one of the log by default")
+	failed := fs.Bool("failed", false, "Only replay the commands that failed")
+	module := fs.String("module", "", "Only replay the commands of this module")
+	dryRun := fs.Bool("n", false, "Print the commands instead of running them")
+	skipSteps := fs.Bool("skip-steps", false, "Replay the commands even though built-in steps of the run can't be replayed")
+	noColor := fs.Bool("no-color", false, "Disable colored output")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: rayder replay [options] audit.jsonl [SECRET=value ...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	color.NoColor = colorsDisabled(*noColor)
+	cyan, yellow, red := color.New(color.FgCyan).SprintFunc(), color.New(color.FgYellow).SprintFunc(), color.New(color.FgRed).SprintFunc()
+
+	entries, err := readAuditLog(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if *runID == "" && len(entries) > 0 {
+		*runID = entries[len(entries)-1].RunID
+	}
+	secrets := make(map[string]string)
+	for _, arg := range fs.Args()[1:] {
+		if name, value, ok := strings.Cut(arg, "="); ok {
+			secrets[name] = value
+		}
+	}
+
+	var selected []auditEntry
+	var steps []string
+	missing := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.RunID != *runID || (*failed && entry.Status != statusErrored) || (*module != "" && entry.Module != *module) {
+			continue
+		}
+		selected = append(selected, entry)
+		if entry.Step != "" {
+			steps = append(steps, fmt.Sprintf("%s step of module '%s'", entry.Step, entry.Module))
+			continue
+		}
+		for _, name := range entry.Secrets {
+			if _, ok := secrets[name]; !ok {
+				missing[name] = true
+			}
+		}
+	}
+	if len(steps) > 0 && !*dryRun {
+		if !*skipSteps {
+			fmt.Fprintf(os.Stderr, "Error: built-in steps can't be replayed, rerun the workflow or pass -skip-steps to replay the commands without them:\n")
+			for _, step := range steps {
+				fmt.Fprintf(os.Stderr, "    %s\n", step)
+			}
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Not replaying %d built-in steps\n", yellow(currentTime()), yellow("WARN"), len(steps))
+	}
+	if len(selected) == 0 {
+		fmt.Fprintf(os.Stderr, "No commands of run %s to replay\n", *runID)
+		return 1
+	}
+	if len(missing) > 0 && !*dryRun {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(os.Stderr, "Error: the log leaves out secret variables, give them as NAME=value: %s\n", strings.Join(names, ", "))
+		return 1
+	}
+
+	failures := 0
+	streams := make(map[string]*bytes.Buffer)
+	for _, entry := range selected {
+		if entry.Step != "" {
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s' %s (built-in step, not replayed)\n", yellow(currentTime()), yellow("REPLAY"), cyan(entry.Module), strings.Join(entry.Argv, " "))
+			continue
+		}
+		// The placeholders are shown, not the secrets.
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s' $ %s%s\n", yellow(currentTime()), yellow("REPLAY"), cyan(entry.Module), quoteArgv(entry.Argv), describeAuditStdin(entry.Stdin))
+		if *dryRun {
+			continue
+		}
+		if err := replayEntry(entry, secrets, streams); err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s': %v\n", yellow(currentTime()), red("ERROR"), cyan(entry.Module), err)
+			failures++
+		}
+	}
+	if failures > 0 {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] %d of %d commands failed%s\n", yellow(currentTime()), yellow("INFO"), failures, len(selected), mark("failure"))
+		return 1
+	}
+	return 0
+}
+
+// quoteArgv joins argv for display, quoting the arguments that need it.
+func quoteArgv(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = arg
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"") {
+			quoted[i] = strconv.Quote(arg)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// readAuditLog reads the entries of the audit log at path.
+func readAuditLog(path string) ([]auditEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []auditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// describeAuditStdin renders the input of a command the way a shell
+// redirection would show it.
+func describeAuditStdin(stdin *auditStdin) string {
+	switch {
+	case stdin == nil:
+		return ""
+	case stdin.File != "":
+		return " < " + stdin.File
+	case stdin.Stream != "":
+		return " < stream " + stdin.Stream
+	}
+	return " <<< " + strconv.Quote(stdin.Text)
+}
+
+// replayEntry runs the command of entry in its directory and environment,
+// with the secrets' placeholders filled in. Output written to a stream is
+// kept in streams for the commands reading it, which finished later.
+func replayEntry(entry auditEntry, secrets map[string]string, streams map[string]*bytes.Buffer) error {
+	if len(entry.Argv) == 0 {
+		return fmt.Errorf("no command recorded")
+	}
+	fill := func(s string) string {
+		for _, name := range entry.Secrets {
+			s = strings.ReplaceAll(s, "{{"+name+"}}", secrets[name])
+		}
+		return s
+	}
+
+	argv := make([]string, len(entry.Argv))
+	for i, arg := range entry.Argv {
+		argv[i] = fill(arg)
+	}
+	env := make(map[string]string, len(entry.Env))
+	for key, value := range entry.Env {
+		env[key] = fill(value)
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = entry.Dir
+	cmd.Env = mergeEnv(env, entry.EnvClean)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if entry.Stream != "" {
+		if streams[entry.Stream] == nil {
+			streams[entry.Stream] = new(bytes.Buffer)
+		}
+		cmd.Stdout = streams[entry.Stream]
+	}
+	if stdin := entry.Stdin; stdin != nil {
+		switch {
+		case stdin.File != "":
+			file, err := os.Open(fill(stdin.File))
+			if err != nil {
+				return fmt.Errorf("reading stdin: %w", err)
+			}
+			defer file.Close()
+			cmd.Stdin = file
+		case stdin.Stream != "":
+			data := streams[stdin.Stream]
+			if data == nil {
+				return fmt.Errorf("reads stream %s, which no replayed command wrote", stdin.Stream)
+			}
+			cmd.Stdin = bytes.NewReader(data.Bytes())
+		default:
+			text := fill(stdin.Text)
+			if text != "" && !strings.HasSuffix(text, "\n") {
+				text += "\n"
+			}
+			cmd.Stdin = strings.NewReader(text)
+		}
+	}
+	err := cmd.Run()
+
+	// Exit codes the module allowed or skipped on are not failures.
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && entry.Status != statusErrored && exitErr.ExitCode() == entry.ExitCode {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package main
+
+import "fmt"
+
+// States a required module must end in for the modules requiring it to run.
+const (
+	stateFinished = "finished" // any outcome, the default
+	stateSuccess  = "success"  // completed, neither errored nor skipped
+)
+
+// Requirement is an entry of a module's required list: a module that has to
+// end first. A module requiring another one with state success is skipped
+// when that one errored or was skipped, so strict chains stop at the first
+// failure while a module reporting on them runs regardless.
+type Requirement struct {
+	Module string `yaml:"module"`
+	State  string `yaml:"state"`
+}
+
+// UnmarshalYAML accepts a module name alone, as in required: [scan], or the
+// full form.
+func (r *Requirement) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		r.Module = name
+		return nil
+	}
+
+	type requirement Requirement
+	if err := unmarshal((*requirement)(r)); err != nil {
+		return err
+	}
+	if r.Module == "" {
+		return fmt.Errorf("required entry needs a module")
+	}
+	switch r.State {
+	case "", stateFinished, stateSuccess:
+		return nil
+	}
+	return fmt.Errorf("required module %s: unknown state %q, want success or finished", r.Module, r.State)
+}
+
+func (r Requirement) String() string {
+	if r.State == "" || r.State == stateFinished {
+		return r.Module
+	}
+	return r.Module + " (" + r.State + ")"
+}
+
+// requiredNames returns the names of the modules task requires.
+func requiredNames(task Task) []string {
+	names := make([]string, len(task.Required))
+	for i, req := range task.Required {
+		names[i] = req.Module
+	}
+	return names
+}
+
+// unmetRequirement returns the first module task requires with state success
+// that ended otherwise, along with how it ended, given the statuses modules
+// ended with. It returns "" when task can run.
+func unmetRequirement(task Task, statuses map[string]string) (string, string) {
+	for _, req := range task.Required {
+		if req.State == stateSuccess && statuses[req.Module] != statusCompleted {
+			return req.Module, statuses[req.Module]
+		}
+	}
+	return "", ""
+}
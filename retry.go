@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// RetryPolicy re-runs failed commands of a module. Given as a number it is
+// the attempt count; as a map it can add a delay between attempts and limit
+// retries to failures whose output matches one of the On patterns.
+type RetryPolicy struct {
+	Attempts int      `yaml:"attempts"`
+	Delay    string   `yaml:"delay"`
+	On       []string `yaml:"on"`
+
+	patterns []*regexp.Regexp
+}
+
+func (r *RetryPolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var attempts int
+	if err := unmarshal(&attempts); err == nil {
+		r.Attempts = attempts
+		return nil
+	}
+
+	type retry RetryPolicy
+	if err := unmarshal((*retry)(r)); err != nil {
+		return err
+	}
+
+	for _, pattern := range r.On {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return fmt.Errorf("invalid retry pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return nil
+}
+
+func (r *RetryPolicy) delay() time.Duration {
+	if r == nil || r.Delay == "" {
+		return 0
+	}
+	d, err := parseDuration(r.Delay)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// shouldRetry reports whether a command that failed with err on the given
+// attempt (counting from 1) is tried again.
+func (r *RetryPolicy) shouldRetry(err error, attempt int) bool {
+	var skipErr *skipError
+	if r == nil || attempt >= r.Attempts || errors.As(err, &skipErr) {
+		return false
+	}
+	if len(r.patterns) == 0 {
+		return true
+	}
+	if r.matches([]byte(err.Error())) {
+		return true
+	}
+	var matched *retryableError
+	return errors.As(err, &matched)
+}
+
+func (r *RetryPolicy) matches(b []byte) bool {
+	for _, re := range r.patterns {
+		if re.Match(b) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryableError marks a failure whose output matched a retry pattern.
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// patternWatcher scans command output line by line for retry patterns
+// without keeping more than the current line.
+type patternWatcher struct {
+	mu      sync.Mutex
+	policy  *RetryPolicy
+	line    []byte
+	matched bool
+}
+
+func (w *patternWatcher) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.line = append(w.line, p...)
+	for {
+		i := bytes.IndexByte(w.line, '\n')
+		if i < 0 {
+			break
+		}
+		if !w.matched && w.policy.matches(w.line[:i]) {
+			w.matched = true
+		}
+		w.line = w.line[i+1:]
+	}
+	return len(p), nil
+}
+
+// teeWriter duplicates writes to w and watcher; a nil w discards.
+func teeWriter(w io.Writer, watcher *patternWatcher) io.Writer {
+	if w == nil {
+		return watcher
+	}
+	return io.MultiWriter(w, watcher)
+}
+
+func (w *patternWatcher) Matched() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.matched || w.policy.matches(w.line)
+}
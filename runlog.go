@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// exitHooks run before the process exits through exit, so output captured
+// for the run log is flushed even on failure.
+var exitHooks []func()
+
+// runLogPath is the path of the run log, empty when none is written.
+var runLogPath string
+
+// runLogWriter writes to the run log file alone, for output that doesn't go
+// to stdout or stderr. It is nil when no run log is written.
+var runLogWriter io.Writer
+
+// Exit codes, so wrappers and CI can tell kinds of failure apart.
+const (
+	exitFailed       = 1   // a module failed, or the run could not start
+	exitUsage        = 2   // bad flags, arguments or variables
+	exitInvalid      = 3   // the workflow could not be loaded or is invalid
+	exitMissingTools = 4   // required tools are missing
+	exitInterrupted  = 130 // the run was interrupted or cancelled
+)
+
+func exit(code int) {
+	runExitHooks()
+	os.Exit(code)
+}
+
+func runExitHooks() {
+	for i := len(exitHooks) - 1; i >= 0; i-- {
+		exitHooks[i]()
+	}
+	exitHooks = nil
+}
+
+// logRetention bounds the disk space taken by run logs. Zero values don't
+// limit anything.
+type logRetention struct {
+	maxSize uint64        // size at which a run log is rotated
+	maxAge  time.Duration // age after which the logs of a run are removed
+	keep    int           // number of runs whose logs are kept
+}
+
+// maxLogRotations is the number of rotated pieces kept of a run log, older
+// ones are removed as the log is rotated again.
+const maxLogRotations = 5
+
+// startRunLog copies everything written to stdout and stderr, including the
+// output of tools, to a new log file in dir. Colors are stripped from the
+// copy. The logs of earlier runs are pruned according to retention first.
+// The returned function stops copying and closes the file.
+func startRunLog(dir string, retention logRetention) (string, func(), error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", nil, err
+	}
+	if err := pruneRunLogs(dir, retention, time.Now()); err != nil {
+		return "", nil, fmt.Errorf("pruning old run logs: %w", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("rayder-%s.log", time.Now().Format("20060102-150405")))
+	file, err := openRotatingFile(path, retention.maxSize)
+	if err != nil {
+		return "", nil, err
+	}
+
+	logFile := &ansiStripper{w: file}
+	runLogWriter = logFile
+	var wg sync.WaitGroup
+	redirect := func(target **os.File) (restore func(), err error) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		orig := *target
+		*target = w
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			io.Copy(io.MultiWriter(orig, logFile), r)
+			r.Close()
+		}()
+		return func() {
+			*target = orig
+			w.Close()
+		}, nil
+	}
+
+	restoreStdout, err := redirect(&os.Stdout)
+	if err != nil {
+		file.Close()
+		return "", nil, err
+	}
+	restoreStderr, err := redirect(&os.Stderr)
+	if err != nil {
+		restoreStdout()
+		file.Close()
+		return "", nil, err
+	}
+	log.SetOutput(os.Stderr)
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			restoreStdout()
+			restoreStderr()
+			log.SetOutput(os.Stderr)
+			wg.Wait()
+			runLogWriter = nil
+			file.Close()
+		})
+	}
+	return path, stop, nil
+}
+
+// ansiStripper removes color escape sequences before writing. Writes from
+// stdout and stderr are serialized so lines don't interleave mid-write.
+type ansiStripper struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (a *ansiStripper) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.w.Write(ansiPattern.ReplaceAll(p, nil)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// rotatingFile is a log file that is moved aside to path.1, path.2 and so
+// on once it grows past maxSize, so a single long run can't fill the disk.
+// Writes are serialized by ansiStripper.
+type rotatingFile struct {
+	path    string
+	maxSize uint64
+	size    uint64
+	file    *os.File
+}
+
+func openRotatingFile(path string, maxSize uint64) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r.file, r.size = file, 0
+	if info, err := file.Stat(); err == nil {
+		r.size = uint64(info.Size())
+	}
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+uint64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += uint64(n)
+	return n, err
+}
+
+// rotate shifts the rotated pieces up by one, dropping the oldest, and
+// starts the log afresh.
+func (r *rotatingFile) rotate() error {
+	r.file.Close()
+	os.Remove(fmt.Sprintf("%s.%d", r.path, maxLogRotations))
+	for i := maxLogRotations - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	return r.file.Close()
+}
+
+// pruneRunLogs removes the logs of runs in dir beyond the retention: those
+// last written to before maxAge ago, and all but the newest keep-1 runs, as
+// the run about to start makes keep. The rotated pieces of a run go with it.
+func pruneRunLogs(dir string, retention logRetention, now time.Time) error {
+	if retention.keep <= 0 && retention.maxAge <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	files := make(map[string][]string) // run log name -> its files
+	written := make(map[string]time.Time)
+	for _, entry := range entries {
+		name := entry.Name()
+		run := name
+		if i := strings.LastIndex(name, ".log."); i >= 0 {
+			if _, err := strconv.Atoi(name[i+len(".log."):]); err == nil {
+				run = name[:i+len(".log")]
+			}
+		}
+		if entry.IsDir() || !strings.HasPrefix(run, "rayder-") || !strings.HasSuffix(run, ".log") {
+			continue
+		}
+		files[run] = append(files[run], name)
+		if info, err := entry.Info(); err == nil && info.ModTime().After(written[run]) {
+			written[run] = info.ModTime()
+		}
+	}
+
+	runs := make([]string, 0, len(files))
+	for run := range files {
+		runs = append(runs, run)
+	}
+	// The timestamps in the names sort the runs newest first.
+	sort.Sort(sort.Reverse(sort.StringSlice(runs)))
+	for i, run := range runs {
+		tooMany := retention.keep > 0 && i >= retention.keep-1
+		tooOld := retention.maxAge > 0 && now.Sub(written[run]) > retention.maxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		for _, name := range files[run] {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseRetentionAge parses the age of log_max_age and -log-max-age, a
+// duration that may also be given in days, as in 30d.
+func parseRetentionAge(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(strings.TrimSpace(s), "d"); days != strings.TrimSpace(s) {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return parseDuration(s)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+)
+
+// sarifReport is the file -report-sarif writes the findings of the run to.
+var sarifReport string
+
+// sarifLevels maps the severity of a vuln to a SARIF level and the
+// security-severity score GitHub code scanning ranks alerts by.
+var sarifLevels = map[string]struct {
+	level string
+	score string
+}{
+	"critical": {"error", "9.5"},
+	"high":     {"error", "8.0"},
+	"medium":   {"warning", "5.5"},
+	"low":      {"note", "2.0"},
+}
+
+// writeSARIF writes the vulns found in run as a SARIF 2.1.0 log, for GitHub
+// code scanning and other security dashboards. Every vuln template becomes
+// a rule and every vuln a result. SARIF locates results in files, so they
+// are located in the first workflow of the run. Hosts, ports and URLs are
+// inventory rather than issues and are left out.
+func writeSARIF(path string, run RunRecord) error {
+	type message struct {
+		Text string `json:"text"`
+	}
+	type rule struct {
+		ID               string                 `json:"id"`
+		Name             string                 `json:"name,omitempty"`
+		ShortDescription message                `json:"shortDescription"`
+		DefaultConfig    map[string]string      `json:"defaultConfiguration"`
+		Properties       map[string]interface{} `json:"properties"`
+	}
+	type result struct {
+		RuleID              string                   `json:"ruleId"`
+		Level               string                   `json:"level"`
+		Message             message                  `json:"message"`
+		Locations           []map[string]interface{} `json:"locations,omitempty"`
+		PartialFingerprints map[string]string        `json:"partialFingerprints"`
+		Properties          map[string]interface{}   `json:"properties"`
+	}
+
+	var location []map[string]interface{}
+	if len(run.Workflows) > 0 && !isRemoteWorkflow(run.Workflows[0]) {
+		location = []map[string]interface{}{{
+			"physicalLocation": map[string]interface{}{
+				"artifactLocation": map[string]string{"uri": strings.TrimPrefix(run.Workflows[0], "./")},
+				"region":           map[string]int{"startLine": 1},
+			},
+		}}
+	}
+
+	rules := make(map[string]rule)
+	results := []result{}
+	for _, f := range run.Findings {
+		if f.Kind != findingVuln {
+			continue
+		}
+		level, ok := sarifLevels[strings.ToLower(f.Severity)]
+		if !ok {
+			level.level, level.score = "note", "0.0"
+		}
+		id := firstNonEmpty(f.ID, f.Name)
+		if _, ok := rules[id]; !ok {
+			rules[id] = rule{
+				ID:               id,
+				Name:             f.Name,
+				ShortDescription: message{Text: firstNonEmpty(f.Name, id)},
+				DefaultConfig:    map[string]string{"level": level.level},
+				Properties: map[string]interface{}{
+					"tags":              []string{"security", f.Source},
+					"security-severity": level.score,
+				},
+			}
+		}
+		sum := sha256.Sum256([]byte(findingKey(f)))
+		results = append(results, result{
+			RuleID:              id,
+			Level:               level.level,
+			Message:             message{Text: f.String()},
+			Locations:           location,
+			PartialFingerprints: map[string]string{"rayderFinding/v1": hex.EncodeToString(sum[:])},
+			Properties: map[string]interface{}{
+				"module":   f.Module,
+				"host":     firstNonEmpty(f.Host, f.IP),
+				"url":      f.URL,
+				"severity": f.Severity,
+			},
+		})
+	}
+	ids := make([]string, 0, len(rules))
+	for id := range rules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	ruleList := make([]rule, len(ids))
+	for i, id := range ids {
+		ruleList[i] = rules[id]
+	}
+
+	log := map[string]interface{}{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []map[string]interface{}{{
+			"tool": map[string]interface{}{
+				"driver": map[string]interface{}{
+					"name":           "rayder",
+					"version":        version,
+					"informationUri": "https://github.com/" + releaseRepo,
+					"rules":          ruleList,
+				},
+			},
+			"automationDetails": map[string]string{"id": "rayder/" + run.ID},
+			"results":           results,
+		}},
+	}
+	data, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	_ "embed"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// workflowSchema is the JSON Schema of the workflow format, for editors
+// using yaml-language-server. It must be kept in sync with Config and Task.
+//
+//go:embed workflow.schema.json
+var workflowSchema []byte
+
+func runSchemaCommand(args []string) int {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	output := fs.String("o", "", "Write the schema to this file instead of stdout")
+	fs.Parse(args)
+
+	if *output == "" {
+		os.Stdout.Write(workflowSchema)
+		return 0
+	}
+	if err := os.WriteFile(*output, workflowSchema, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// secretNameHints mark variables as secret by name, so common credentials
+// are masked without having to list them under secrets.
+var secretNameHints = []string{"TOKEN", "SECRET", "PASSWORD", "PASSWD", "API_KEY", "APIKEY", "PRIVATE_KEY", "CREDENTIAL", "AUTH"}
+
+// Secret is an entry of secrets: a variable to mask, given by name alone or
+// with where its value is fetched from at startup.
+type Secret struct {
+	Name  string `yaml:"name"`
+	Vault string `yaml:"vault"` // KV v2 secret, as mount/path#field
+}
+
+func (s *Secret) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&s.Name); err == nil {
+		return nil
+	}
+	type secret Secret
+	if err := unmarshal((*secret)(s)); err != nil {
+		return err
+	}
+	if s.Name == "" {
+		return fmt.Errorf("secret without a name")
+	}
+	return nil
+}
+
+// secretNames returns the names of secrets.
+func secretNames(secrets []Secret) []string {
+	names := make([]string, len(secrets))
+	for i, secret := range secrets {
+		names[i] = secret.Name
+	}
+	return names
+}
+
+// secretVars holds the variables declared secret for the current run.
+var secretVars = struct {
+	sync.RWMutex
+	names map[string]bool
+}{names: make(map[string]bool)}
+
+// markSecret declares the named variables secret.
+func markSecret(names ...string) {
+	secretVars.Lock()
+	defer secretVars.Unlock()
+	for _, name := range names {
+		secretVars.names[name] = true
+	}
+}
+
+func isSecretVar(name string) bool {
+	secretVars.RLock()
+	declared := secretVars.names[name]
+	secretVars.RUnlock()
+	if declared {
+		return true
+	}
+
+	upper := strings.ToUpper(name)
+	for _, hint := range secretNameHints {
+		if strings.Contains(upper, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskSecrets replaces the values of secret variables in s with asterisks.
+// Longer values are replaced first so a secret containing another one is
+// masked as a whole.
+func maskSecrets(s string, vars map[string]string) string {
+	var values []string
+	for name, value := range vars {
+		if value != "" && isSecretVar(name) {
+			values = append(values, value)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	for _, value := range values {
+		s = strings.ReplaceAll(s, value, "****")
+	}
+	return s
+}
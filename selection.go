@@ -0,0 +1,79 @@
+package main
+
+import "strings"
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func hasAnyTag(task Task, tags []string) bool {
+	for _, tag := range tags {
+		for _, t := range task.Tags {
+			if t == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selectTasks keeps the tasks matching the -tags/-skip-tags selection. Tasks
+// that require a deselected task or read its stream can't run either and are
+// dropped as well; the returned map gives the reason for every task that was
+// dropped.
+func selectTasks(tasks []Task, tags, skipTags []string) ([]Task, map[string]string) {
+	dropped := make(map[string]string)
+	for _, task := range tasks {
+		switch {
+		case len(tags) > 0 && !hasAnyTag(task, tags):
+			dropped[task.Name] = "not tagged " + strings.Join(tags, " or ")
+		case hasAnyTag(task, skipTags):
+			dropped[task.Name] = "tagged " + strings.Join(skipTags, " or ")
+		}
+	}
+
+	producers := make(map[string]string)
+	for _, task := range tasks {
+		if task.Stream != "" {
+			producers[task.Stream] = task.Name
+		}
+	}
+
+	// Drop dependents of dropped tasks until nothing changes.
+	for changed := true; changed; {
+		changed = false
+		for _, task := range tasks {
+			if _, ok := dropped[task.Name]; ok {
+				continue
+			}
+			for _, req := range requiredNames(task) {
+				if _, ok := dropped[req]; ok {
+					dropped[task.Name] = "requires excluded module '" + req + "'"
+					changed = true
+					break
+				}
+			}
+			if producer, ok := producers[task.StdinStream]; ok && dropped[task.Name] == "" {
+				if _, ok := dropped[producer]; ok {
+					dropped[task.Name] = "reads the stream of excluded module '" + producer + "'"
+					changed = true
+				}
+			}
+		}
+	}
+
+	var selected []Task
+	for _, task := range tasks {
+		if _, ok := dropped[task.Name]; !ok {
+			selected = append(selected, task)
+		}
+	}
+	return selected, dropped
+}
@@ -0,0 +1,805 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uiPage is the single page of the web UI served by rayder serve.
+//
+//go:embed ui.html
+var uiPage []byte
+
+// uiServer launches the workflows of a directory on behalf of the web UI
+// and keeps the output of the runs it started.
+type uiServer struct {
+	dir      string
+	token    string
+	loopback bool // listening on a loopback address only
+
+	mu     sync.Mutex
+	runs   []*uiRun
+	nextID int
+}
+
+// uiRun is a run started from the web UI, executed by a child rayder
+// process.
+type uiRun struct {
+	ID       int
+	Workflow string
+	Vars     map[string]string
+	Started  time.Time
+	Status   string
+
+	mu          sync.Mutex
+	process     *os.Process
+	cancelled   bool
+	output      []byte
+	dropped     int // lines dropped from the start of output
+	subscribers map[chan []byte]bool
+}
+
+// maxRunOutput caps the output kept of a run for clients that join later.
+// Beyond it the oldest lines are dropped, a quarter at a time.
+const maxRunOutput = 4 << 20
+
+func runServeCommand(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "Address to listen on")
+	dir := fs.String("dir", ".", "Directory with the workflows that can be launched")
+	token := fs.String("token", os.Getenv("RAYDER_SERVE_TOKEN"), "Token clients must present, as a bearer token or a token query parameter (generated when not given)")
+	fs.Parse(args)
+
+	abs, err := filepath.Abs(*dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	// Any web page the user visits can send requests to the server, so
+	// there is always a token.
+	if *token == "" {
+		secret := make([]byte, 16)
+		if _, err := rand.Read(secret); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: generating a token: %v\n", err)
+			return 1
+		}
+		*token = hex.EncodeToString(secret)
+	}
+	s := &uiServer{dir: abs, token: *token}
+	if host, _, err := net.SplitHostPort(*addr); err == nil {
+		ip := net.ParseIP(host)
+		s.loopback = host == "localhost" || (ip != nil && ip.IsLoopback())
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/api/workflows", s.handleWorkflows)
+	mux.HandleFunc("/api/runs", s.handleRuns)
+	mux.HandleFunc("/api/runs/", s.handleRun)
+	mux.HandleFunc("/api/history", s.handleHistory)
+	mux.HandleFunc("/api/history/", s.handleHistoryRun)
+
+	fmt.Fprintf(os.Stderr, "Serving the workflows of %s on http://%s/?token=%s\n", abs, *addr, url.QueryEscape(*token))
+	if err := http.ListenAndServe(*addr, s.authorize(mux)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// authorize rejects cross-origin requests and requests without the token.
+// Browsers can't set headers on EventSource and WebSocket requests, so the
+// token is also accepted as a query parameter.
+func (s *uiServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.checkOrigin(r); err != nil {
+			writeJSONError(w, http.StatusForbidden, err)
+			return
+		}
+		given := r.URL.Query().Get("token")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			given = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, fmt.Errorf("missing or wrong token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkOrigin guards against other sites driving the server through the
+// browser, WebSocket handshakes included: a request sent by a page must
+// come from the UI's own origin, and a server on a loopback address only
+// answers to loopback host names, which defeats DNS rebinding.
+func (s *uiServer) checkOrigin(r *http.Request) error {
+	if s.loopback {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		ip := net.ParseIP(strings.Trim(host, "[]"))
+		if host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return fmt.Errorf("unexpected host %q", r.Host)
+		}
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			return fmt.Errorf("cross-origin request from %q", origin)
+		}
+	}
+	return nil
+}
+
+func (s *uiServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(uiPage)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// uiWorkflow describes a launchable workflow and the variables of its form.
+type uiWorkflow struct {
+	Path  string  `json:"path"`
+	Usage string  `json:"usage,omitempty"`
+	Vars  []uiVar `json:"vars"`
+	Error string  `json:"error,omitempty"`
+}
+
+type uiVar struct {
+	Name        string `json:"name"`
+	Default     string `json:"default"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required"`
+	Secret      bool   `json:"secret"`
+}
+
+// workflows returns the workflow files below the served directory, skipping
+// hidden directories and local override files.
+func (s *uiServer) workflows() []uiWorkflow {
+	var workflows []uiWorkflow
+	filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if path != s.dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml", ".json", ".toml":
+		default:
+			return nil
+		}
+		if strings.Contains(info.Name(), ".override.") {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(s.dir, path)
+		workflow := uiWorkflow{Path: filepath.ToSlash(rel), Vars: []uiVar{}}
+		config, err := loadWorkflow(path, false, verifyOptions{})
+		if err != nil {
+			workflow.Error = err.Error()
+		}
+		workflow.Usage = config.Usage
+		secrets := make(map[string]bool)
+		for _, secret := range config.Secrets {
+			secrets[secret.Name] = true
+		}
+		for name, spec := range config.VarSpecs {
+			workflow.Vars = append(workflow.Vars, uiVar{
+				Name:        name,
+				Default:     spec.Default,
+				Description: spec.Description,
+				Required:    spec.Required,
+				Secret:      secrets[name] || isSecretVar(name),
+			})
+		}
+		sort.Slice(workflow.Vars, func(i, j int) bool { return workflow.Vars[i].Name < workflow.Vars[j].Name })
+		workflows = append(workflows, workflow)
+		return nil
+	})
+	return workflows
+}
+
+func (s *uiServer) handleWorkflows(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.workflows())
+}
+
+var varNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (s *uiServer) handleRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.mu.Lock()
+		runs := make([]map[string]interface{}, 0, len(s.runs))
+		for i := len(s.runs) - 1; i >= 0; i-- {
+			runs = append(runs, s.runs[i].summary())
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, runs)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+
+	// Requiring JSON keeps plain HTML forms of other sites out, as they
+	// can't send it.
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeJSONError(w, http.StatusUnsupportedMediaType, fmt.Errorf("expected Content-Type application/json"))
+		return
+	}
+	var req struct {
+		Workflow string            `json:"workflow"`
+		Vars     map[string]string `json:"vars"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Only workflows of the served directory can be launched.
+	known := false
+	for _, workflow := range s.workflows() {
+		if workflow.Path == req.Workflow && workflow.Error == "" {
+			known = true
+			break
+		}
+	}
+	if !known {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("unknown workflow %q", req.Workflow))
+		return
+	}
+	for name := range req.Vars {
+		if !varNamePattern.MatchString(name) {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid variable name %q", name))
+			return
+		}
+	}
+
+	run, err := s.start(req.Workflow, req.Vars)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, run.summary())
+}
+
+// start launches workflow in a child rayder process.
+func (s *uiServer) start(workflow string, vars map[string]string) (*uiRun, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"-q", "-no-color", "-w", filepath.Join(s.dir, filepath.FromSlash(workflow))}
+	for name, value := range vars {
+		args = append(args, name+"="+value)
+	}
+	cmd := exec.Command(self, args...)
+	cmd.Dir = s.dir
+	cmd.Env = append(os.Environ(), "NO_COLOR=1")
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+
+	s.mu.Lock()
+	s.nextID++
+	run := &uiRun{
+		ID:          s.nextID,
+		Workflow:    workflow,
+		Vars:        vars,
+		Started:     time.Now(),
+		Status:      "running",
+		subscribers: make(map[chan []byte]bool),
+	}
+	s.runs = append(s.runs, run)
+	s.mu.Unlock()
+
+	if err := cmd.Start(); err != nil {
+		run.finish(err)
+		return nil, err
+	}
+	run.mu.Lock()
+	run.process = cmd.Process
+	run.mu.Unlock()
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		done <- err
+	}()
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := pr.Read(buf)
+			if n > 0 {
+				run.write(buf[:n])
+			}
+			if err != nil {
+				// All output has been passed on; only now may the
+				// subscribers go.
+				run.finish(<-done)
+				return
+			}
+		}
+	}()
+	return run, nil
+}
+
+// summary returns the run for the API, with the values of secret variables
+// masked.
+func (run *uiRun) summary() map[string]interface{} {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	vars := make(map[string]string, len(run.Vars))
+	for name, value := range run.Vars {
+		if isSecretVar(name) {
+			value = "****"
+		}
+		vars[name] = value
+	}
+	return map[string]interface{}{
+		"id":       run.ID,
+		"workflow": run.Workflow,
+		"vars":     vars,
+		"started":  run.Started,
+		"status":   run.Status,
+	}
+}
+
+func (run *uiRun) status() string {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	return run.Status
+}
+
+// finished reports whether run has ended; a paused run hasn't.
+func (run *uiRun) finished() bool {
+	switch run.status() {
+	case statusCompleted, statusErrored, statusCancelled:
+		return true
+	}
+	return false
+}
+
+func (run *uiRun) write(p []byte) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	chunk := append([]byte(nil), p...)
+	run.output = append(run.output, chunk...)
+	if len(run.output) > maxRunOutput {
+		cut := len(run.output) - maxRunOutput*3/4
+		if i := bytes.IndexByte(run.output[cut:], '\n'); i >= 0 {
+			cut += i + 1
+		}
+		run.dropped += bytes.Count(run.output[:cut], []byte("\n"))
+		run.output = append([]byte(nil), run.output[cut:]...)
+	}
+	for ch := range run.subscribers {
+		select {
+		case ch <- chunk:
+		default:
+			// A subscriber that can't keep up is dropped rather than
+			// blocking the run.
+			delete(run.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (run *uiRun) finish(err error) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	switch {
+	case run.cancelled:
+		run.Status = statusCancelled
+	case err != nil:
+		run.Status = statusErrored
+	default:
+		run.Status = statusCompleted
+	}
+	for ch := range run.subscribers {
+		close(ch)
+	}
+	run.subscribers = nil
+}
+
+// control pauses, resumes or cancels the run by signalling its process.
+// Pausing a paused run or resuming a running one does nothing.
+func (run *uiRun) control(action string) error {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if run.subscribers == nil || run.process == nil {
+		return fmt.Errorf("run #%d is not running", run.ID)
+	}
+	if run.cancelled {
+		return fmt.Errorf("run #%d is being cancelled", run.ID)
+	}
+
+	switch action {
+	case "pause", "resume":
+		paused := action == "pause"
+		if (run.Status == "paused") == paused {
+			return nil
+		}
+		if err := sendControlSignal(run.process, false); err != nil {
+			return err
+		}
+		run.Status = "running"
+		if paused {
+			run.Status = "paused"
+		}
+	case "cancel":
+		if err := sendControlSignal(run.process, true); err != nil {
+			return err
+		}
+		run.cancelled = true
+	}
+	return nil
+}
+
+// subscribe returns the output kept so far, the number of lines dropped
+// before it and, while the run is going, a channel receiving the rest.
+func (run *uiRun) subscribe() ([]byte, int, chan []byte) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	output := append([]byte(nil), run.output...)
+	if run.subscribers == nil {
+		return output, run.dropped, nil
+	}
+	ch := make(chan []byte, 256)
+	run.subscribers[ch] = true
+	return output, run.dropped, ch
+}
+
+func (run *uiRun) unsubscribe(ch chan []byte) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if run.subscribers[ch] {
+		delete(run.subscribers, ch)
+		close(ch)
+	}
+}
+
+// handleRun serves the output of a run: over a WebSocket at
+// /api/runs/<id>/log, as the UI uses it, and as server-sent events at
+// /api/runs/<id>/events. Posting to /api/runs/<id>/pause, resume or cancel
+// controls the run.
+func (s *uiServer) handleRun(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/runs/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	var run *uiRun
+	for _, candidate := range s.runs {
+		if candidate.ID == id {
+			run = candidate
+		}
+	}
+	s.mu.Unlock()
+	if run == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch parts[1] {
+	case "log":
+		streamWebSocket(w, r, run)
+	case "events":
+		streamEvents(w, r, run)
+	case "pause", "resume", "cancel":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+			return
+		}
+		if err := run.control(parts[1]); err != nil {
+			writeJSONError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, run.summary())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// streamWebSocket sends the raw output of run as binary WebSocket frames.
+func streamWebSocket(w http.ResponseWriter, r *http.Request, run *uiRun) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	output, _, ch := run.subscribe()
+	if ch != nil {
+		defer run.unsubscribe(ch)
+	}
+	if len(output) > 0 && conn.send(output) != nil {
+		return
+	}
+	if ch == nil {
+		conn.close()
+		return
+	}
+	for {
+		select {
+		case chunk, ok := <-ch:
+			if !ok {
+				conn.close()
+				return
+			}
+			if conn.send(chunk) != nil {
+				return
+			}
+		case <-conn.closed:
+			return
+		}
+	}
+}
+
+// streamEvents sends the output of run as server-sent events: a log event
+// per line, with the line number as its ID, and an end event carrying the
+// status once the run is over. A client reconnecting with Last-Event-ID
+// resumes after that line.
+func streamEvents(w http.ResponseWriter, r *http.Request, run *uiRun) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	skip, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	output, dropped, ch := run.subscribe()
+	if ch != nil {
+		defer run.unsubscribe(ch)
+	}
+
+	line := dropped
+	var partial []byte
+	emit := func(chunk []byte) {
+		partial = append(partial, chunk...)
+		for {
+			i := bytes.IndexByte(partial, '\n')
+			if i < 0 {
+				break
+			}
+			text := strings.TrimSuffix(string(partial[:i]), "\r")
+			partial = partial[i+1:]
+			line++
+			if line > skip {
+				fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", line, text)
+			}
+		}
+		flusher.Flush()
+	}
+	end := func() {
+		if len(partial) > 0 {
+			emit([]byte("\n"))
+		}
+		data, _ := json.Marshal(map[string]string{"status": run.status()})
+		fmt.Fprintf(w, "event: end\ndata: %s\n\n", data)
+		flusher.Flush()
+	}
+
+	emit(output)
+	if ch == nil {
+		end()
+		return
+	}
+
+	// Comments keep idle connections from being closed by proxies.
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case chunk, ok := <-ch:
+			if !ok {
+				// The channel also closes when the client couldn't keep
+				// up; it then reconnects with Last-Event-ID.
+				if run.finished() {
+					end()
+				}
+				return
+			}
+			emit(chunk)
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *uiServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	runs, err := historyRuns(false)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(runs) > 100 {
+		runs = runs[:100]
+	}
+	if runs == nil {
+		runs = []RunRecord{}
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+func (s *uiServer) handleHistoryRun(w http.ResponseWriter, r *http.Request) {
+	runs, err := historyRuns(false)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	run, err := findRun(runs, strings.TrimPrefix(r.URL.Path, "/api/history/"))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+// webSocket is the server side of a WebSocket connection that only sends.
+// Incoming frames are read and discarded until the client closes.
+type webSocket struct {
+	conn   net.Conn
+	mu     sync.Mutex
+	closed chan struct{}
+}
+
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// upgradeWebSocket performs the RFC 6455 opening handshake.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*webSocket, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket request", http.StatusBadRequest)
+		return nil, fmt.Errorf("not a WebSocket request")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSockets are not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("connection can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	ws := &webSocket{conn: conn, closed: make(chan struct{})}
+	go ws.drain(rw.Reader)
+	return ws, nil
+}
+
+// drain reads frames until the client closes the connection.
+func (ws *webSocket) drain(r *bufio.Reader) {
+	defer close(ws.closed)
+	header := make([]byte, 2)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+		opcode := header[0] & 0x0f
+		length := uint64(header[1] & 0x7f)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		if header[1]&0x80 != 0 {
+			length += 4 // masking key
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil || opcode == 0x8 {
+			return
+		}
+	}
+}
+
+// send writes p as a binary frame; the UI decodes it as UTF-8, which may be
+// split across frames.
+func (ws *webSocket) send(p []byte) error {
+	return ws.writeFrame(0x2, p)
+}
+
+// close sends a close frame.
+func (ws *webSocket) close() {
+	ws.writeFrame(0x8, nil)
+}
+
+func (ws *webSocket) writeFrame(opcode byte, p []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch n := len(p); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xffff:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	ws.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := ws.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := ws.conn.Write(p)
+	return err
+}
+
+func (ws *webSocket) Close() error {
+	return ws.conn.Close()
+}
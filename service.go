@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// defaultReadyTimeout bounds how long a service may take to become ready.
+const defaultReadyTimeout = 30 * time.Second
+
+// serviceStopTimeout is how long a service gets to exit after SIGTERM before
+// it is killed.
+const serviceStopTimeout = 5 * time.Second
+
+type service struct {
+	name string
+	cmd  *exec.Cmd
+	done chan struct{}
+}
+
+// runningServices holds every service started during the run so they can be
+// terminated once the run ends.
+var runningServices struct {
+	sync.Mutex
+	list []*service
+}
+
+// startService starts the commands of a service module in the background and
+// waits for its readiness probe, if any. The module counts as completed once
+// the service is ready, which releases the modules that require it.
+func startService(task Task, vars map[string]string, cyan, magenta, white, yellow, red, green func(a ...interface{}) string) error {
+	if len(task.Matrix) > 0 || task.ForeachFile != "" {
+		return fmt.Errorf("service modules do not support matrix or foreach_file")
+	}
+
+	logLifecycle("[%s] [%s] Service '%s' %s%s\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), yellow(word("starting")), mark("start"))
+
+	var started []*service
+	for _, cmd := range task.Cmds {
+		if cmd.isBuiltin() {
+			return fmt.Errorf("service modules only support shell commands")
+		}
+
+		execCmd := buildCommand(cmd, task, vars)
+		if !task.Silent {
+			execCmd.Stdout = os.Stdout
+			execCmd.Stderr = os.Stderr
+		}
+		setProcessGroup(execCmd)
+
+		if err := execCmd.Start(); err != nil {
+			return fmt.Errorf("starting service: %w", err)
+		}
+
+		svc := &service{name: task.Name, cmd: execCmd, done: make(chan struct{})}
+		go func() {
+			svc.cmd.Wait()
+			close(svc.done)
+		}()
+
+		runningServices.Lock()
+		runningServices.list = append(runningServices.list, svc)
+		runningServices.Unlock()
+		started = append(started, svc)
+	}
+
+	if task.Ready != "" || task.WaitFor != nil {
+		timeout := defaultReadyTimeout
+		if task.ReadyTimeout != "" {
+			d, err := parseDuration(task.ReadyTimeout)
+			if err != nil {
+				return err
+			}
+			timeout = d
+		}
+
+		probe := replacePlaceholders(task.Ready, vars)
+		var wait *WaitFor
+		if task.WaitFor != nil {
+			wait = task.WaitFor.resolve(vars)
+			if wait.Timeout != "" {
+				d, err := wait.timeout()
+				if err != nil {
+					return err
+				}
+				timeout = d
+			}
+		}
+
+		// The probe sees the same environment and proxy as the service.
+		env := commandEnv(task, vars)
+		ready := func() bool {
+			if probe != "" {
+				probeCmd := shellCommand(task.Shell, probe)
+				probeCmd.Env = env
+				if probeCmd.Run() != nil {
+					return false
+				}
+			}
+			return wait == nil || wait.satisfied()
+		}
+		if err := waitUntil(ready, timeout, started); err != nil {
+			return err
+		}
+	}
+
+	logLifecycle("[%s] [%s] Service '%s' %s%s\n", yellow(currentTime()), yellow("INFO"), cyan(task.Name), green(word("ready")), mark("success"))
+	return nil
+}
+
+// stopServices terminates all services started during the run.
+func stopServices(cyan, magenta, white, yellow, red, green func(a ...interface{}) string) {
+	runningServices.Lock()
+	defer runningServices.Unlock()
+
+	for _, svc := range runningServices.list {
+		select {
+		case <-svc.done:
+			continue
+		default:
+		}
+
+		logLifecycle("[%s] [%s] Service '%s' %s\n", yellow(currentTime()), yellow("INFO"), cyan(svc.name), yellow("stopping"))
+		signalProcessGroup(svc.cmd, false)
+
+		select {
+		case <-svc.done:
+		case <-time.After(serviceStopTimeout):
+			signalProcessGroup(svc.cmd, true)
+			<-svc.done
+		}
+	}
+	runningServices.list = nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// defaultShell is used when neither the workflow nor the module sets one.
+func defaultShell() string {
+	if runtime.GOOS == "windows" {
+		return "cmd"
+	}
+	return "sh"
+}
+
+// shellArgs returns the argv prefix used to run a command string with shell.
+// Well known shells get their usual flags; anything else is split on spaces
+// and used as is, so "bash -euo pipefail -c" works too.
+func shellArgs(shell string) []string {
+	if shell == "" {
+		shell = defaultShell()
+	}
+
+	fields := strings.Fields(shell)
+	if len(fields) > 1 {
+		return fields
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(filepath.Base(shell), ".exe"))
+	switch name {
+	case "cmd":
+		return []string{shell, "/C"}
+	case "powershell", "pwsh":
+		return []string{shell, "-NoProfile", "-NonInteractive", "-Command"}
+	default:
+		return []string{shell, "-c"}
+	}
+}
+
+// wrapCommand makes cmd run through the wrapper at path, such as nice or
+// prlimit, which takes args and then executes the original command.
+func wrapCommand(cmd *exec.Cmd, path string, args ...string) {
+	wrapped := append([]string{filepath.Base(path)}, args...)
+	cmd.Args = append(append(wrapped, "--", cmd.Path), cmd.Args[1:]...)
+	cmd.Path = path
+}
+
+// shellCommand returns a command running cmdStr through shell.
+func shellCommand(shell, cmdStr string) *exec.Cmd {
+	args := append(shellArgs(shell), cmdStr)
+	cmd := exec.Command(args[0], args[1:]...)
+	quoteForCmd(cmd, args)
+	return cmd
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+import "os/exec"
+
+// quoteForCmd only matters on Windows, where cmd.exe parses its own command
+// line.
+func quoteForCmd(cmd *exec.Cmd, args []string) {}
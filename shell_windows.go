@@ -0,0 +1,25 @@
+//go:build windows
+
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// quoteForCmd makes cmd.exe receive the command string of a cmd /C command
+// as it was written. Go escapes quotes in arguments with backslashes, which
+// cmd.exe doesn't understand, so the command line is set verbatim instead,
+// with /S so that cmd.exe strips exactly the outer quotes.
+func quoteForCmd(cmd *exec.Cmd, args []string) {
+	name := strings.ToLower(strings.TrimSuffix(filepath.Base(args[0]), ".exe"))
+	if name != "cmd" || len(args) != 3 || !strings.EqualFold(args[1], "/C") {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CmdLine = syscall.EscapeArg(args[0]) + ` /S /C "` + args[2] + `"`
+}
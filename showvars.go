@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+// resolvedVar is a variable as a run would get it, for rayder vars.
+type resolvedVar struct {
+	Name   string `json:"name"`
+	Module string `json:"module,omitempty"` // for -set overrides
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// runVarsCommand prints the variables a run of the workflows would get, in
+// the order a run applies them: workflow defaults (with their includes and
+// override files), the profile, decrypted and Vault secrets, the command
+// line, the automatic variables, and -set overrides per module. Each value
+// comes with where it was last set; secrets are masked.
+func runVarsCommand(args []string) int {
+	fs := flag.NewFlagSet("vars", flag.ExitOnError)
+	var files workflowList
+	fs.Var(&files, "w", "Path to the workflow YAML file (repeat or comma separate for several)")
+	profile := fs.String("profile", "", "Profile from the workflow's profiles section to apply")
+	fs.Var(&moduleSettings, "set", "Override a variable for a single module, as in module.subenum.THREADS=50 (repeatable)")
+	fs.StringVar(&ageIdentity, "age-identity", os.Getenv("RAYDER_AGE_IDENTITY"), "age identity file to decrypt encrypted variables with")
+	asJSON := fs.Bool("json", false, "Print the variables as JSON")
+	fs.Parse(args)
+
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: rayder vars -w workflow.yaml [-profile name] [-set module.NAME.VAR=value] [-json] [VAR=value ...]")
+		return 2
+	}
+
+	vars, err := resolveVars(files, *profile, fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	for i := range vars {
+		if vars[i].Value != "" && isSecretVar(vars[i].Name) {
+			vars[i].Value = "****"
+		}
+	}
+
+	if *asJSON {
+		data, _ := json.MarshalIndent(vars, "", "  ")
+		fmt.Println(string(data))
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVALUE\tSOURCE")
+	for _, v := range vars {
+		name := v.Name
+		if v.Module != "" {
+			name = "module." + v.Module + "." + v.Name
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, strings.ReplaceAll(v.Value, "\n", `\n`), v.Source)
+	}
+	w.Flush()
+	return 0
+}
+
+// resolveVars goes through the steps of a run setting variables, noting the
+// source of each value as it changes.
+func resolveVars(files []string, profile string, args []string) ([]resolvedVar, error) {
+	sources := make(map[string]string)
+	var configs []Config
+	for _, ref := range files {
+		config, err := loadWorkflow(ref, false, verifyOptions{})
+		if err != nil {
+			return nil, err
+		}
+		overridden := overrideVarNames(ref)
+		for name := range config.Vars {
+			sources[name] = "workflow " + ref
+			if overridden[name] {
+				sources[name] = "override " + overridePath(ref)
+			}
+		}
+		configs = append(configs, config)
+	}
+	config := configs[0]
+	if len(configs) > 1 {
+		var err error
+		if config, err = mergeConfigs(configs); err != nil {
+			return nil, err
+		}
+	}
+
+	changed := func(step func() error, source string) error {
+		before := make(map[string]string, len(config.Vars))
+		for name, value := range config.Vars {
+			before[name] = value
+		}
+		if err := step(); err != nil {
+			return err
+		}
+		for name, value := range config.Vars {
+			if old, ok := before[name]; !ok || old != value {
+				sources[name] = source
+			}
+		}
+		return nil
+	}
+	if profile != "" {
+		if err := applyProfile(&config, profile); err != nil {
+			return nil, err
+		}
+		for name := range config.Profiles[profile] {
+			sources[name] = "profile " + profile
+		}
+	}
+	if err := changed(func() error { return decryptVars(&config, args) }, "decrypted"); err != nil {
+		return nil, err
+	}
+	if err := changed(func() error { return fetchSecrets(&config, args) }, "Vault"); err != nil {
+		return nil, err
+	}
+	markSecret(secretNames(config.Secrets)...)
+
+	values := make(map[string]string, len(config.Vars))
+	for name, value := range config.Vars {
+		values[name] = value
+	}
+	for _, arg := range args {
+		if name, value, ok := strings.Cut(arg, "="); ok {
+			values[name] = value
+			sources[name] = "command line"
+		}
+	}
+	for name := range withRunVars(values) {
+		if sources[name] == "" {
+			sources[name] = "automatic"
+		}
+	}
+	for _, name := range missingVars(config.VarSpecs, values) {
+		sources[name] = "missing, required"
+	}
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	vars := make([]resolvedVar, 0, len(names)+len(moduleSettings))
+	for _, name := range names {
+		vars = append(vars, resolvedVar{Name: name, Value: values[name], Source: sources[name]})
+	}
+
+	if unknown := unknownSettingModules(config.Tasks); len(unknown) > 0 {
+		return nil, fmt.Errorf("-set names modules the workflow doesn't define: %s", strings.Join(unknown, ", "))
+	}
+	for _, s := range moduleSettings {
+		vars = append(vars, resolvedVar{Name: s.name, Module: s.module, Value: s.value, Source: "-set"})
+	}
+	return vars, nil
+}
+
+// overrideVarNames returns the variables the override file of the local
+// workflow at path sets.
+func overrideVarNames(path string) map[string]bool {
+	content, err := ioutil.ReadFile(overridePath(path))
+	if err != nil {
+		return nil
+	}
+	var override overrideFile
+	if content, err = workflowYAML(overridePath(path), content); err != nil {
+		return nil
+	}
+	if yaml.Unmarshal(content, &override) != nil {
+		return nil
+	}
+	names := make(map[string]bool, len(override.Vars))
+	for name := range override.Vars {
+		names[name] = true
+	}
+	return names
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Sink ships the results of a run to an external store when the run ends:
+// Elasticsearch or OpenSearch, or the findings to DefectDojo or Faraday.
+// URL, Index, Engagement, Product, Workspace and the credentials may
+// reference variables, with RUN_ID set to the ID of the run.
+type Sink struct {
+	Type       string `yaml:"type"`
+	URL        string `yaml:"url"`
+	Index      string `yaml:"index"`
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+	APIKey     string `yaml:"api_key"`
+	Artifacts  bool   `yaml:"artifacts"`
+	ParseJSON  bool   `yaml:"parse_json"`
+	Engagement string `yaml:"engagement"`
+	Product    string `yaml:"product"`
+	Workspace  string `yaml:"workspace"`
+}
+
+func (s *Sink) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type sink Sink
+	if err := unmarshal((*sink)(s)); err != nil {
+		return err
+	}
+
+	switch s.Type {
+	case "elasticsearch", "opensearch":
+	case "defectdojo":
+		if s.APIKey == "" || s.Engagement == "" {
+			return fmt.Errorf("defectdojo sink needs an api_key and an engagement")
+		}
+	case "faraday":
+		if s.Workspace == "" || (s.APIKey == "" && s.Username == "") {
+			return fmt.Errorf("faraday sink needs a workspace and an api_key or username")
+		}
+	case "":
+		return fmt.Errorf("sink needs a type")
+	default:
+		return fmt.Errorf("unknown sink type %q, expected elasticsearch, opensearch, defectdojo or faraday", s.Type)
+	}
+	if s.URL == "" {
+		return fmt.Errorf("%s sink needs a url", s.Type)
+	}
+	return nil
+}
+
+// ship sends the results of run to the sink.
+func (s Sink) ship(run RunRecord, vars map[string]string) error {
+	resolved := make(map[string]string, len(vars)+1)
+	for key, value := range vars {
+		resolved[key] = value
+	}
+	resolved["RUN_ID"] = run.ID
+
+	switch s.Type {
+	case "defectdojo":
+		return s.shipDefectDojo(run, resolved)
+	case "faraday":
+		return s.shipFaraday(run, resolved)
+	}
+	docs, err := s.documents(run)
+	if err != nil {
+		return err
+	}
+	return s.bulkIndex(resolved, docs)
+}
+
+// documents returns one document per module and finding and, if artifacts
+// are shipped, one per non-empty artifact line.
+func (s Sink) documents(run RunRecord) ([]map[string]interface{}, error) {
+	var docs []map[string]interface{}
+	for _, module := range run.Modules {
+		docs = append(docs, map[string]interface{}{
+			"type":        "module",
+			"run_id":      run.ID,
+			"workflows":   run.Workflows,
+			"run_status":  run.Status,
+			"module":      module.Name,
+			"status":      module.Status,
+			"@timestamp":  module.Started.Format(time.RFC3339),
+			"duration_ms": module.Duration.Milliseconds(),
+			"artifacts":   module.Artifacts,
+			"cpu_ms":      module.CPU.Milliseconds(),
+			"peak_memory": module.PeakMemory,
+			"written":     module.Written,
+		})
+	}
+	for _, finding := range run.Findings {
+		docs = append(docs, map[string]interface{}{
+			"type":       "finding",
+			"run_id":     run.ID,
+			"workflows":  run.Workflows,
+			"module":     finding.Module,
+			"@timestamp": run.Finished.Format(time.RFC3339),
+			"finding":    finding,
+		})
+	}
+	if !s.Artifacts {
+		return docs, nil
+	}
+
+	for _, module := range run.Modules {
+		for _, path := range module.Artifacts {
+			lines, err := s.artifactLines(path)
+			if err != nil {
+				return nil, err
+			}
+			for _, line := range lines {
+				doc := map[string]interface{}{
+					"type":       "artifact",
+					"run_id":     run.ID,
+					"workflows":  run.Workflows,
+					"module":     module.Name,
+					"path":       path,
+					"@timestamp": run.Finished.Format(time.RFC3339),
+				}
+				var data map[string]interface{}
+				if s.ParseJSON && json.Unmarshal([]byte(line), &data) == nil {
+					doc["data"] = data
+				} else {
+					doc["line"] = line
+				}
+				docs = append(docs, doc)
+			}
+		}
+	}
+	return docs, nil
+}
+
+func (s Sink) artifactLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// bulkBatch is the number of documents sent per bulk request.
+const bulkBatch = 1000
+
+// bulkIndex indexes docs with the _bulk API that Elasticsearch and
+// OpenSearch share.
+func (s Sink) bulkIndex(vars map[string]string, docs []map[string]interface{}) error {
+	index := replacePlaceholders(s.Index, vars)
+	if index == "" {
+		index = "rayder-" + vars["RUN_ID"]
+	}
+	url := strings.TrimSuffix(replacePlaceholders(s.URL, vars), "/") + "/_bulk"
+	action, _ := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": index}})
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	for start := 0; start < len(docs); start += bulkBatch {
+		end := start + bulkBatch
+		if end > len(docs) {
+			end = len(docs)
+		}
+
+		var body bytes.Buffer
+		for _, doc := range docs[start:end] {
+			data, err := json.Marshal(doc)
+			if err != nil {
+				return err
+			}
+			body.Write(action)
+			body.WriteByte('\n')
+			body.Write(data)
+			body.WriteByte('\n')
+		}
+
+		req, err := http.NewRequest("POST", url, &body)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		if s.APIKey != "" {
+			req.Header.Set("Authorization", "ApiKey "+replacePlaceholders(s.APIKey, vars))
+		} else if s.Username != "" {
+			req.SetBasicAuth(replacePlaceholders(s.Username, vars), replacePlaceholders(s.Password, vars))
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+		}
+
+		var result struct {
+			Errors bool `json:"errors"`
+			Items  []map[string]struct {
+				Error json.RawMessage `json:"error"`
+			} `json:"items"`
+		}
+		if err := json.Unmarshal(respBody, &result); err == nil && result.Errors {
+			for _, item := range result.Items {
+				for _, status := range item {
+					if len(status.Error) > 0 {
+						return fmt.Errorf("indexing into %s: %s", index, status.Error)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Stdin is the input fed to a command on stdin, so tools reading their
+// targets from a pipe don't have to be wrapped in `echo ... |`. It is given
+// as inline text or as a mapping with one of text, file or var; a plain
+// string is text.
+type Stdin struct {
+	Text string `yaml:"text"`
+	File string `yaml:"file"`
+	Var  string `yaml:"var"`
+}
+
+func (s *Stdin) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var text string
+	if err := unmarshal(&text); err == nil {
+		s.Text = text
+		return nil
+	}
+	type plain Stdin
+	var p plain
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	set := 0
+	for _, field := range []string{p.Text, p.File, p.Var} {
+		if field != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("stdin takes exactly one of text, file or var")
+	}
+	*s = Stdin(p)
+	return nil
+}
+
+// open returns the input, with placeholders substituted, and a function
+// releasing it once the command has run.
+func (s *Stdin) open(vars map[string]string) (io.Reader, func(), error) {
+	if s.File != "" {
+		file, err := os.Open(replacePlaceholders(s.File, vars))
+		if err != nil {
+			return nil, func() {}, fmt.Errorf("reading stdin: %w", err)
+		}
+		return file, func() { file.Close() }, nil
+	}
+
+	text := replacePlaceholders(s.Text, vars)
+	if s.Var != "" {
+		value, ok := vars[s.Var]
+		if !ok {
+			return nil, func() {}, fmt.Errorf("stdin: unknown variable %s", s.Var)
+		}
+		text = value
+	}
+	// Line oriented tools would lose an unterminated last line.
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return strings.NewReader(text), func() {}, nil
+}
+
+// describe renders the input the way a shell redirection would show it.
+func (s *Stdin) describe(vars map[string]string) string {
+	switch {
+	case s.File != "":
+		return " < " + replacePlaceholders(s.File, vars)
+	case s.Var != "":
+		return " <<< $" + s.Var
+	}
+	return " <<< " + strconv.Quote(replacePlaceholders(s.Text, vars))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// stepMode makes the run ask before each module whether to run it, for
+// developing a workflow against a live target one module at a time.
+var stepMode bool
+
+// stepper asks the step mode questions. Parallel modules ask one at a time.
+var stepper struct {
+	sync.Mutex
+	in *bufio.Reader
+}
+
+type stepAction int
+
+const (
+	stepRun stepAction = iota
+	stepSkip
+	stepAbort
+)
+
+// stepPrompt asks what to do with task, whose placeholders resolve with
+// vars, until it gets an answer that is not a request to show the commands.
+// Answers are read from the terminal, so a workflow read from stdin can be
+// stepped through too. When there is no more input the run is aborted.
+func stepPrompt(task Task, vars map[string]string, cyan, yellow func(a ...interface{}) string) stepAction {
+	stepper.Lock()
+	defer stepper.Unlock()
+	if stepper.in == nil {
+		in := os.Stdin
+		if tty, err := os.Open("/dev/tty"); err == nil {
+			in = tty
+		}
+		stepper.in = bufio.NewReader(in)
+	}
+
+	for {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s': [r]un, [s]kip, [a]bort or show [c]ommands? ", yellow(currentTime()), yellow("STEP"), cyan(task.Name))
+		line, err := stepper.in.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(os.Stderr)
+			return stepAbort
+		}
+
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "", "r", "run":
+			return stepRun
+		case "s", "skip":
+			return stepSkip
+		case "a", "abort":
+			return stepAbort
+		case "c", "commands":
+			printStepCommands(task, vars)
+		default:
+			fmt.Fprintln(os.Stderr, "Answer r, s, a or c.")
+		}
+	}
+}
+
+// printStepCommands prints the commands of task as they would run, with
+// secrets masked.
+func printStepCommands(task Task, vars map[string]string) {
+	sections := []struct {
+		name string
+		cmds []Command
+	}{{"before", task.Before}, {"cmds", task.Cmds}, {"after", task.After}}
+
+	if task.Workflow != "" {
+		fmt.Fprintf(os.Stderr, "    workflow: %s\n", replacePlaceholders(task.Workflow, vars))
+	}
+	for _, section := range sections {
+		if len(section.cmds) == 0 {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "    %s:\n", section.name)
+		for _, cmd := range section.cmds {
+			fmt.Fprintf(os.Stderr, "      $ %s\n", maskSecrets(describeCommand(cmd, vars), vars))
+		}
+	}
+}
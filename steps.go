@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Command is a single entry of a module's cmds list. It is either a plain
+// shell command, an argv list executed without a shell, or one of the
+// built-in step types that run in-process. Written as a mapping, a shell
+// command (run) or argv list (argv) can be given options such as stdin.
+type Command struct {
+	Shell    string
+	Argv     []string
+	Stdin    *Stdin
+	HTTP     *HTTPStep
+	Copy     *CopyStep
+	Download *DownloadStep
+	Sleep    string
+	WaitFor  *WaitFor
+	Assert   *AssertStep
+	Merge    *MergeStep
+}
+
+type HTTPStep struct {
+	URL     string            `yaml:"url"`
+	Method  string            `yaml:"method"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+	Output  string            `yaml:"output"`
+	Timeout string            `yaml:"timeout"`
+}
+
+type CopyStep struct {
+	Src  string `yaml:"src"`
+	Dest string `yaml:"dest"`
+}
+
+type DownloadStep struct {
+	URL     string `yaml:"url"`
+	Dest    string `yaml:"dest"`
+	Timeout string `yaml:"timeout"`
+}
+
+// Default timeouts of the http and download steps, covering the whole
+// request including the body.
+const (
+	httpStepTimeout     = 30 * time.Second
+	downloadStepTimeout = 10 * time.Minute
+)
+
+// AssertStep checks an intermediate result and fails the module with
+// Message (or a description of the failed check) when it does not hold.
+type AssertStep struct {
+	File     string `yaml:"file"`
+	NotEmpty bool   `yaml:"not_empty"`
+	MinLines *int   `yaml:"min_lines"`
+	MaxLines *int   `yaml:"max_lines"`
+	Command  string `yaml:"command"`
+	ExitCode int    `yaml:"exit_code"`
+	Expr     string `yaml:"expr"`
+	Message  string `yaml:"message"`
+}
+
+type commandSpec struct {
+	Run      string        `yaml:"run"`
+	Argv     []string      `yaml:"argv"`
+	Stdin    *Stdin        `yaml:"stdin"`
+	HTTP     *HTTPStep     `yaml:"http"`
+	Copy     *CopyStep     `yaml:"copy"`
+	Download *DownloadStep `yaml:"download"`
+	Sleep    string        `yaml:"sleep"`
+	WaitFor  *WaitFor      `yaml:"wait_for"`
+	Assert   *AssertStep   `yaml:"assert"`
+	Merge    *MergeStep    `yaml:"merge"`
+}
+
+func (c *Command) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var shell string
+	if err := unmarshal(&shell); err == nil {
+		c.Shell = shell
+		return nil
+	}
+
+	var argv []string
+	if err := unmarshal(&argv); err == nil {
+		if len(argv) == 0 {
+			return fmt.Errorf("empty command list")
+		}
+		c.Argv = argv
+		return nil
+	}
+
+	// The local name is what strict decoding reports for unknown fields.
+	type step commandSpec
+	var spec step
+	if err := unmarshal(&spec); err != nil {
+		return err
+	}
+
+	c.Shell = spec.Run
+	c.Argv = spec.Argv
+	c.Stdin = spec.Stdin
+	c.HTTP = spec.HTTP
+	c.Copy = spec.Copy
+	c.Download = spec.Download
+	c.Sleep = spec.Sleep
+	c.WaitFor = spec.WaitFor
+	c.Assert = spec.Assert
+	c.Merge = spec.Merge
+
+	isCommand := c.Shell != "" || len(c.Argv) > 0
+	switch {
+	case c.Shell != "" && len(c.Argv) > 0:
+		return fmt.Errorf("a command takes either run or argv, not both")
+	case isCommand && c.isBuiltin():
+		return fmt.Errorf("a step can't also be a command (run or argv)")
+	case !isCommand && c.Stdin != nil:
+		return fmt.Errorf("stdin can only be given to a command (run or argv)")
+	case !isCommand && !c.isBuiltin():
+		return fmt.Errorf("unknown step type, expected a shell command or one of http, copy, download, sleep, wait_for, assert, merge")
+	}
+	var timeout string
+	if c.HTTP != nil {
+		timeout = c.HTTP.Timeout
+	} else if c.Download != nil {
+		timeout = c.Download.Timeout
+	}
+	if timeout != "" && !strings.Contains(timeout, "{{") {
+		if _, err := parseDuration(timeout); err != nil {
+			return fmt.Errorf("timeout: %w", err)
+		}
+	}
+	return nil
+}
+
+// describeCommand renders cmd with placeholders substituted, the way it is
+// about to be executed.
+func describeCommand(cmd Command, vars map[string]string) string {
+	switch {
+	case len(cmd.Argv) > 0:
+		argv := make([]string, len(cmd.Argv))
+		for i, arg := range cmd.Argv {
+			argv[i] = strconv.Quote(replacePlaceholders(arg, vars))
+		}
+		return "[" + strings.Join(argv, ", ") + "]" + cmd.describeStdin(vars)
+	case cmd.HTTP != nil:
+		return fmt.Sprintf("http %s %s", httpMethod(cmd.HTTP.Method), replacePlaceholders(cmd.HTTP.URL, vars))
+	case cmd.Copy != nil:
+		return fmt.Sprintf("copy %s %s", replacePlaceholders(cmd.Copy.Src, vars), replacePlaceholders(cmd.Copy.Dest, vars))
+	case cmd.Download != nil:
+		return fmt.Sprintf("download %s %s", replacePlaceholders(cmd.Download.URL, vars), replacePlaceholders(cmd.Download.Dest, vars))
+	case cmd.Sleep != "":
+		return "sleep " + replacePlaceholders(cmd.Sleep, vars)
+	case cmd.WaitFor != nil:
+		return "wait_for " + cmd.WaitFor.resolve(vars).String()
+	case cmd.Assert != nil:
+		return "assert " + cmd.Assert.describe(vars)
+	case cmd.Merge != nil:
+		return "merge " + cmd.Merge.describe(vars)
+	}
+	return replacePlaceholders(cmd.Shell, vars) + cmd.describeStdin(vars)
+}
+
+func (c Command) describeStdin(vars map[string]string) string {
+	if c.Stdin == nil {
+		return ""
+	}
+	return c.Stdin.describe(vars)
+}
+
+func (c Command) isBuiltin() bool {
+	return c.HTTP != nil || c.Copy != nil || c.Download != nil || c.Sleep != "" || c.WaitFor != nil || c.Assert != nil || c.Merge != nil
+}
+
+func runBuiltinStep(cmd Command, task Task, vars map[string]string) error {
+	silent := !showToolOutput(task.Silent)
+	switch {
+	case cmd.HTTP != nil:
+		client, err := stepClient(cmd.HTTP.Timeout, httpStepTimeout, task, vars)
+		if err != nil {
+			return fmt.Errorf("http step failed: %w", err)
+		}
+		return runHTTPStep(cmd.HTTP, client, silent, vars)
+	case cmd.Copy != nil:
+		return copyFile(replacePlaceholders(cmd.Copy.Src, vars), replacePlaceholders(cmd.Copy.Dest, vars))
+	case cmd.Download != nil:
+		client, err := stepClient(cmd.Download.Timeout, downloadStepTimeout, task, vars)
+		if err != nil {
+			return fmt.Errorf("download failed: %w", err)
+		}
+		return runDownloadStep(cmd.Download, client, vars)
+	case cmd.Sleep != "":
+		d, err := parseDuration(replacePlaceholders(cmd.Sleep, vars))
+		if err != nil {
+			return err
+		}
+		if !control.sleep(d) {
+			return errCancelled
+		}
+		return nil
+	case cmd.WaitFor != nil:
+		return waitForConditions(cmd.WaitFor, vars)
+	case cmd.Assert != nil:
+		return runAssertStep(cmd.Assert, task.Shell, vars)
+	case cmd.Merge != nil:
+		return runMergeStep(cmd.Merge, vars)
+	}
+	return fmt.Errorf("empty step")
+}
+
+func httpMethod(method string) string {
+	if method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(method)
+}
+
+// stepClient returns the client of an http or download step: it gives up
+// after timeout, or fallback when none is set (0 waits forever), and goes
+// through the proxy of task.
+func stepClient(timeout string, fallback time.Duration, task Task, vars map[string]string) (*http.Client, error) {
+	client := &http.Client{Timeout: fallback}
+	if timeout != "" {
+		d, err := parseDuration(replacePlaceholders(timeout, vars))
+		if err != nil {
+			return nil, err
+		}
+		client.Timeout = d
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// The proxy of the module replaces the one of rayder's own environment.
+	transport.Proxy = nil
+	if p := task.proxy(); p != nil && p.URL != "" {
+		proxyURL, err := parseProxyURL(replacePlaceholders(p.URL, vars))
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(proxyURL.Scheme, "socks4") {
+			return nil, fmt.Errorf("%s proxies are not supported by built-in steps", proxyURL.Scheme)
+		}
+		noProxy := replacePlaceholders(p.NoProxy, vars)
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if bypassProxy(req.URL.Hostname(), noProxy) {
+				return nil, nil
+			}
+			return proxyURL, nil
+		}
+	}
+	client.Transport = transport
+	return client, nil
+}
+
+func runHTTPStep(step *HTTPStep, client *http.Client, silent bool, vars map[string]string) (err error) {
+	target := replacePlaceholders(step.URL, vars)
+
+	var body io.Reader
+	if step.Body != "" {
+		body = strings.NewReader(replacePlaceholders(step.Body, vars))
+	}
+
+	req, err := http.NewRequest(httpMethod(step.Method), target, body)
+	if err != nil {
+		return fmt.Errorf("http step failed: %w", err)
+	}
+	for key, value := range step.Headers {
+		req.Header.Set(key, replacePlaceholders(value, vars))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http step failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var out io.Writer = io.Discard
+	if step.Output != "" {
+		f, createErr := createFile(replacePlaceholders(step.Output, vars))
+		if createErr != nil {
+			return fmt.Errorf("http step failed: %w", createErr)
+		}
+		defer func() {
+			if closeErr := f.Close(); closeErr != nil && err == nil {
+				err = fmt.Errorf("http step failed: %w", closeErr)
+			}
+		}()
+		out = f
+	} else if !silent {
+		out = os.Stdout
+	}
+
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		return fmt.Errorf("http step failed: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("http step failed: %s returned %s", target, resp.Status)
+	}
+	return nil
+}
+
+func runDownloadStep(step *DownloadStep, client *http.Client, vars map[string]string) error {
+	target := replacePlaceholders(step.URL, vars)
+	dest := replacePlaceholders(step.Dest, vars)
+	if dest == "" {
+		dest = downloadName(target)
+	}
+
+	resp, err := client.Get(target)
+	if err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("download failed: %s returned %s", target, resp.Status)
+	}
+
+	f, err := createFile(dest)
+	if err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return fmt.Errorf("download failed: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+	return nil
+}
+
+// downloadName returns the file a download is saved to without a dest: the
+// last element of the URL's path, without its query string or fragment.
+func downloadName(target string) string {
+	if u, err := url.Parse(target); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+		return path.Base(u.Path)
+	}
+	return filepath.Base(target)
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("copy failed: %w", err)
+	}
+	defer in.Close()
+
+	if info, err := os.Stat(dest); err == nil && info.IsDir() {
+		dest = filepath.Join(dest, filepath.Base(src))
+	}
+
+	out, err := createFile(dest)
+	if err != nil {
+		return fmt.Errorf("copy failed: %w", err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("copy failed: %w", err)
+	}
+	return nil
+}
+
+// createFile creates (or truncates) the named file, creating any missing
+// parent directories first.
+func createFile(name string) (*os.File, error) {
+	if dir := filepath.Dir(name); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return os.Create(name)
+}
+
+// parseDuration accepts Go duration strings ("1m30s") as well as a bare
+// number of seconds.
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Storage uploads the artifacts and logs of a run to an S3 or GCS bucket,
+// using the aws and gcloud (or gsutil) command line tools and whatever
+// credentials they are configured with.
+type Storage struct {
+	URL      string `yaml:"url"`
+	Key      string `yaml:"key"`
+	Stream   bool   `yaml:"stream"`
+	Endpoint string `yaml:"endpoint"`
+}
+
+func (s *Storage) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type storage Storage
+	if err := unmarshal((*storage)(s)); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(s.URL, "s3://") && !strings.HasPrefix(s.URL, "gs://") {
+		return fmt.Errorf("invalid storage url %q, expected s3://bucket/prefix or gs://bucket/prefix", s.URL)
+	}
+	return nil
+}
+
+// runStorage is where the current run is uploaded, nil without a storage
+// config, and storagePrefix the location below which it goes. Artifacts are
+// named after their path relative to storageRoot, the run's OUTPUT_DIR.
+var (
+	runStorage    *Storage
+	storagePrefix string
+	storageRoot   string
+)
+
+// prefix returns the location objects of the run are uploaded below: the
+// bucket URL followed by the rendered key, the run ID by default.
+func (s *Storage) prefix(runID string, vars map[string]string) string {
+	key := s.Key
+	if key == "" {
+		key = "{{RUN_ID}}"
+	}
+	resolved := make(map[string]string, len(vars)+1)
+	for k, v := range vars {
+		resolved[k] = v
+	}
+	resolved["RUN_ID"] = runID
+	return strings.TrimSuffix(s.URL, "/") + "/" + strings.Trim(replacePlaceholders(key, resolved), "/")
+}
+
+// upload copies the local file at path to the object url.
+func (s *Storage) upload(path, url string) error {
+	var cmd *exec.Cmd
+	if strings.HasPrefix(url, "s3://") {
+		args := []string{"s3", "cp", "--only-show-errors", path, url}
+		if s.Endpoint != "" {
+			args = append(args, "--endpoint-url", s.Endpoint)
+		}
+		cmd = exec.Command("aws", args...)
+	} else if _, err := exec.LookPath("gcloud"); err == nil {
+		cmd = exec.Command("gcloud", "storage", "cp", "--no-user-output-enabled", path, url)
+	} else {
+		cmd = exec.Command("gsutil", "-q", "cp", path, url)
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("uploading %s: %s", path, msg)
+		}
+		return fmt.Errorf("uploading %s: %w", path, err)
+	}
+	return nil
+}
+
+// uploadArtifacts uploads the artifacts of a module that exist, below the
+// module's name. Directories are uploaded with everything in them.
+func (s *Storage) uploadArtifacts(prefix, module string, artifacts []string) []error {
+	var errs []error
+	for _, artifact := range artifacts {
+		err := filepath.WalkDir(artifact, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == artifact {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if err := s.upload(path, prefix+"/"+objectName(module)+"/"+artifactKey(path)); err != nil {
+				errs = append(errs, err)
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// artifactKey returns the object key of the artifact at path, below the
+// module's: its path relative to OUTPUT_DIR, or to the working directory,
+// so files of the same name in different directories are kept apart.
+// Files outside both keep their absolute path.
+func artifactKey(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.ToSlash(filepath.Base(path))
+	}
+	cwd, _ := os.Getwd()
+	for _, root := range []string{storageRoot, cwd} {
+		if root == "" {
+			continue
+		}
+		if root, err = filepath.Abs(root); err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(root, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return strings.TrimPrefix(filepath.ToSlash(strings.TrimPrefix(abs, filepath.VolumeName(abs))), "/")
+}
+
+// uploadRecord uploads the run record as run.json.
+func (s *Storage) uploadRecord(prefix string, run RunRecord) error {
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile("", "rayder-run-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return s.upload(file.Name(), prefix+"/run.json")
+}
+
+// objectName makes a module name usable as part of an object key.
+func objectName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == ' ' || r == '[' || r == ']' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// streamArtifacts uploads the artifacts of a module as soon as it finished,
+// when the storage streams.
+func streamArtifacts(module string, artifacts []string, yellow, red func(a ...interface{}) string) {
+	if runStorage == nil || !runStorage.Stream {
+		return
+	}
+	for _, err := range runStorage.uploadArtifacts(storagePrefix, module, artifacts) {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Storage: %v\n", yellow(currentTime()), red("ERROR"), err)
+	}
+}
+
+// uploadRun uploads what is left of run when it ends: the artifacts unless
+// they were streamed, the run record and the run log. The log is uploaded
+// last, once it has been closed.
+func uploadRun(run RunRecord, yellow, cyan, red func(a ...interface{}) string) {
+	if runStorage == nil {
+		return
+	}
+
+	var errs []error
+	if !runStorage.Stream {
+		for _, module := range run.Modules {
+			errs = append(errs, runStorage.uploadArtifacts(storagePrefix, module.Name, module.Artifacts)...)
+		}
+	}
+	if err := runStorage.uploadRecord(storagePrefix, run); err != nil {
+		errs = append(errs, err)
+	}
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "[%s] [%s] Storage: %v\n", yellow(currentTime()), red("ERROR"), err)
+	}
+	if len(errs) == 0 {
+		logLifecycle("[%s] [%s] Run uploaded to %s\n", yellow(currentTime()), yellow("INFO"), cyan(storagePrefix))
+	}
+
+	if runLogPath != "" {
+		// Exit hooks run last to first, so this runs after the log is closed.
+		exitHooks = append([]func(){func() {
+			if err := runStorage.upload(runLogPath, storagePrefix+"/"+filepath.Base(runLogPath)); err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] [%s] Storage: %v\n", yellow(currentTime()), red("ERROR"), err)
+			}
+		}}, exitHooks...)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// stream carries the stdout of a module with `stream: NAME` to the stdin of
+// the modules with `stdin_stream: NAME`, so a pipeline like
+// `subfinder | dnsx | httpx` can be split into modules that are reported on
+// their own. Everything written is kept, and every reader reads it from the
+// start, so consumers may start before, while or after the producer runs.
+// The data goes to a temporary file rather than memory, as a stream can be
+// far larger than the consumers keep up with.
+type stream struct {
+	name   string
+	file   *os.File
+	mu     sync.Mutex
+	cond   *sync.Cond
+	size   int64
+	closed bool
+}
+
+func newStream(name string) (*stream, error) {
+	file, err := os.CreateTemp("", "rayder-stream-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating stream %q: %w", name, err)
+	}
+	s := &stream{name: name, file: file}
+	s.cond = sync.NewCond(&s.mu)
+	return s, nil
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := s.file.WriteAt(p, s.size)
+	s.size += int64(n)
+	s.cond.Broadcast()
+	if err != nil {
+		return n, fmt.Errorf("writing stream %q: %w", s.name, err)
+	}
+	return n, nil
+}
+
+// close ends the stream once its producer has finished, however it did.
+func (s *stream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.cond.Broadcast()
+}
+
+// remove deletes the data of the stream once the run no longer needs it.
+func (s *stream) remove() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
+
+// reader returns a reader of the stream from its start, which blocks until
+// more is written and returns io.EOF once the stream is closed.
+func (s *stream) reader() io.Reader {
+	return &streamReader{s: s}
+}
+
+type streamReader struct {
+	s   *stream
+	off int64
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	s := r.s
+	s.mu.Lock()
+	for r.off == s.size && !s.closed {
+		s.cond.Wait()
+	}
+	size := s.size
+	s.mu.Unlock()
+	if r.off == size {
+		return 0, io.EOF
+	}
+
+	// What was written up to size is on file; reading it doesn't hold up
+	// the producer.
+	if int64(len(p)) > size-r.off {
+		p = p[:size-r.off]
+	}
+	n, err := s.file.ReadAt(p, r.off)
+	r.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// removeStreams deletes the streams of tasks.
+func removeStreams(tasks []Task) {
+	for _, task := range tasks {
+		if task.output != nil {
+			task.output.remove()
+		}
+	}
+}
+
+// connectStreams creates the streams of tasks and attaches them to their
+// producers and consumers. Every stream read must be produced by a module of
+// the run, or its readers would wait forever.
+func connectStreams(tasks []Task) ([]Task, error) {
+	// Modules reading a stream have to come after its producer, so the
+	// producer is started by the time one of them waits for its output.
+	streams := make(map[string]*stream)
+	connected := make([]Task, len(tasks))
+	for i, task := range tasks {
+		if task.StdinStream != "" {
+			if task.input = streams[task.StdinStream]; task.input == nil {
+				removeStreams(connected[:i])
+				return nil, fmt.Errorf("module %q reads stream %q, which no module before it produces", task.Name, task.StdinStream)
+			}
+		}
+		if task.Stream != "" {
+			if streams[task.Stream] != nil {
+				removeStreams(connected[:i])
+				return nil, fmt.Errorf("stream %q is produced by more than one module", task.Stream)
+			}
+			output, err := newStream(task.Stream)
+			if err != nil {
+				removeStreams(connected[:i])
+				return nil, err
+			}
+			task.output = output
+			streams[task.Stream] = output
+		}
+		connected[i] = task
+	}
+	return connected, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// parentVerify is how the workflows given with -w were verified. Remote
+// sub-workflows of a verified workflow have to be verified as well: against
+// the module's workflow_sha256, or the same public key.
+var parentVerify verifyOptions
+
+// subWorkflowVerify returns how the sub-workflow ref of task is verified,
+// or an error when it is remote, the parent was verified with parent and
+// nothing pins the child.
+func subWorkflowVerify(task Task, ref string, parent verifyOptions) (verifyOptions, error) {
+	opts := verifyOptions{sha256: task.WorkflowSum}
+	if !isRemoteWorkflow(ref) || !parent.enabled() {
+		return opts, nil
+	}
+	if parent.pubkey != "" {
+		opts.pubkey, opts.sigTool = parent.pubkey, parent.sigTool
+	}
+	if !opts.enabled() {
+		return opts, fmt.Errorf("module %q runs the remote workflow %s unverified; set workflow_sha256 to pin it, the parent workflow was verified", task.Name, ref)
+	}
+	return opts, nil
+}
+
+// runSubWorkflow runs the child workflow referenced by a module. The child
+// starts from its own variable defaults, overridden by the module's vars
+// mapping (which may reference the parent's variables). Child modules are
+// prefixed with the module name in log lines.
+func runSubWorkflow(taskName string, task Task, vars map[string]string, cyan, magenta, white, yellow, red, green func(a ...interface{}) string) error {
+	ref := replacePlaceholders(task.Workflow, vars)
+	verify, err := subWorkflowVerify(task, ref, parentVerify)
+	if err == nil {
+		var child Config
+		if child, err = loadWorkflow(ref, false, verify); err == nil {
+			return runChildWorkflow(taskName, task, ref, child, vars, cyan, magenta, white, yellow, red, green)
+		}
+		err = fmt.Errorf("loading sub-workflow %s: %w", ref, err)
+	}
+	// Nothing of the child ran, so nothing else tells why the module failed.
+	fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s': %v\n", yellow(currentTime()), red("ERROR"), cyan(taskName), err)
+	return err
+}
+
+// runChildWorkflow runs child, the loaded sub-workflow ref of task.
+func runChildWorkflow(taskName string, task Task, ref string, child Config, vars map[string]string, cyan, magenta, white, yellow, red, green func(a ...interface{}) string) error {
+	if err := decryptVars(&child, nil); err != nil {
+		return fmt.Errorf("sub-workflow %s: %w", ref, err)
+	}
+	if err := fetchSecrets(&child, nil); err != nil {
+		return fmt.Errorf("sub-workflow %s: %w", ref, err)
+	}
+
+	if missing := checkTools(child.Tools); len(missing) > 0 {
+		return fmt.Errorf("sub-workflow %s requires missing tools: %s", ref, strings.Join(missing, "; "))
+	}
+
+	childVars := make(map[string]string, len(child.Vars)+len(task.Vars))
+	for key, value := range child.Vars {
+		childVars[key] = value
+	}
+	for key, value := range task.Vars {
+		childVars[key] = replacePlaceholders(value, vars)
+	}
+
+	if missing := missingVars(child.VarSpecs, childVars); len(missing) > 0 {
+		return fmt.Errorf("sub-workflow %s is missing required variables: %s", ref, strings.Join(missing, ", "))
+	}
+	childVars = withRunVars(childVars)
+
+	markSecret(secretNames(child.Secrets)...)
+	child.Tasks = prefixTasks(child.Tasks, taskName)
+	applySettings(child.Tasks)
+
+	if !runWorkflow(child, childVars, cyan, magenta, white, yellow, red, green) {
+		return fmt.Errorf("Module '%s' %s%s", taskName, red(word("errored")), mark("failure"))
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "log/syslog"
+
+// openSyslog connects to the local syslog daemon, or the journal on systemd
+// hosts, logging as rayder with the daemon facility.
+func openSyslog() (syslogWriter, error) {
+	return syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, "rayder")
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "errors"
+
+// openSyslog fails, Windows has no syslog.
+func openSyslog() (syslogWriter, error) {
+	return nil, errors.New("syslog is not available on Windows")
+}
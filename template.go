@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// templateFuncs is a sprig-compatible subset of template functions. The
+// argument order follows sprig so that pipelines read the same way.
+var templateFuncs = template.FuncMap{
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"title":      title,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"splitList":  func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       func(sep string, list []string) string { return strings.Join(list, sep) },
+	"trunc": func(n int, s string) string {
+		if n >= 0 && len(s) > n {
+			return s[:n]
+		}
+		return s
+	},
+	"default": func(def string, value ...string) string {
+		if len(value) == 0 || value[0] == "" {
+			return def
+		}
+		return value[0]
+	},
+	"quote":     func(s string) string { return fmt.Sprintf("%q", s) },
+	"squote":    func(s string) string { return "'" + s + "'" },
+	"now":       time.Now,
+	"date":      func(layout string, t time.Time) string { return t.Format(layout) },
+	"unixEpoch": func(t time.Time) string { return fmt.Sprint(t.Unix()) },
+	"uuidv4":    uuidv4,
+	"randAlphaNum": func(n int) (string, error) {
+		return randomString(n, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+	},
+	"env":       os.Getenv,
+	"base":      filepath.Base,
+	"dir":       filepath.Dir,
+	"ext":       filepath.Ext,
+	"b64enc":    func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"b64dec":    func(s string) (string, error) { b, err := base64.StdEncoding.DecodeString(s); return string(b), err },
+	"sha256sum": func(s string) string { sum := sha256.Sum256([]byte(s)); return hex.EncodeToString(sum[:]) },
+}
+
+// actionPattern matches the actions of a template, {{ and }} included.
+var actionPattern = regexp.MustCompile(`(?s)\{\{.*?\}\}`)
+
+// renderTemplate evaluates input as a Go template with vars as its data
+// (`{{ .DOMAIN | upper }}`). Every variable is also callable by name, so
+// the original `{{DOMAIN}}` syntax keeps working. When input as a whole is
+// not a valid template, or references unknown variables, each action is
+// rendered on its own instead, and those that still fail, such as
+// `{{BaseURL}}` meant for nuclei or `{{.State}}` for docker, are left as
+// they are.
+func renderTemplate(input string, vars map[string]string) string {
+	if !strings.Contains(input, "{{") {
+		return input
+	}
+
+	funcs := make(template.FuncMap, len(templateFuncs)+len(vars))
+	for name, fn := range templateFuncs {
+		funcs[name] = fn
+	}
+	for key, value := range vars {
+		if !identifierPattern.MatchString(key) {
+			// Names the template parser cannot call are looked up in the
+			// data instead, so their values are never parsed as templates.
+			input = strings.ReplaceAll(input, "{{"+key+"}}", "{{index . "+strconv.Quote(key)+"}}")
+			continue
+		}
+		value := value
+		funcs[key] = func() string { return value }
+	}
+
+	if cache, ok := renderCaches.Load(reflect.ValueOf(vars).Pointer()); ok {
+		return cache.(*renderCache).render(input, func() string { return executeActions(input, funcs, vars) })
+	}
+	return executeActions(input, funcs, vars)
+}
+
+// executeActions renders input as a whole or, failing that, action by
+// action, leaving the actions that fail as they are.
+func executeActions(input string, funcs template.FuncMap, vars map[string]string) string {
+	if out, err := executeTemplate(input, funcs, vars); err == nil {
+		return out
+	}
+	return actionPattern.ReplaceAllStringFunc(input, func(action string) string {
+		if out, err := executeTemplate(action, funcs, vars); err == nil {
+			return out
+		}
+		return action
+	})
+}
+
+// renderCaches holds the renderCache of each vars map returned by
+// pinRenders, by the map's pointer.
+var renderCaches sync.Map
+
+// renderCache remembers what each input rendered to.
+type renderCache struct {
+	mu  sync.Mutex
+	out map[string]string
+}
+
+func (c *renderCache) render(input string, render func() string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out, ok := c.out[input]
+	if !ok {
+		out = render()
+		c.out[input] = out
+	}
+	return out
+}
+
+// pinRenders returns a copy of vars with which every input is rendered only
+// once until release is called, so the command that is logged is the one
+// that runs, with the same {{now}} and {{uuidv4}}.
+func pinRenders(vars map[string]string) (pinned map[string]string, release func()) {
+	pinned = make(map[string]string, len(vars))
+	for key, value := range vars {
+		pinned[key] = value
+	}
+	key := reflect.ValueOf(pinned).Pointer()
+	renderCaches.Store(key, &renderCache{out: make(map[string]string)})
+	return pinned, func() { renderCaches.Delete(key) }
+}
+
+// executeTemplate parses and executes input, failing on unknown variables.
+func executeTemplate(input string, funcs template.FuncMap, vars map[string]string) (string, error) {
+	tmpl, err := template.New("").Funcs(funcs).Option("missingkey=error").Parse(input)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, vars); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// title upper-cases the first letter of every word of s.
+func title(s string) string {
+	prev := ' '
+	return strings.Map(func(r rune) rune {
+		start := !unicode.IsLetter(prev) && !unicode.IsDigit(prev) && prev != '_'
+		prev = r
+		if start {
+			return unicode.ToTitle(r)
+		}
+		return r
+	}, s)
+}
+
+func uuidv4() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func randomString(n int, alphabet string) (string, error) {
+	out := make([]byte, n)
+	max := big.NewInt(int64(len(alphabet)))
+	for i := range out {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		out[i] = alphabet[idx.Int64()]
+	}
+	return string(out), nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Template is a module defined once under templates and instantiated by
+// modules with use/with. Params holds the default values of its parameters.
+type Template struct {
+	Task   `yaml:",inline"`
+	Params map[string]string `yaml:"params"`
+}
+
+// expandTemplates replaces every module using a template with an instance of
+// it. The module keeps its own name and anything it sets itself; its with
+// values, over the template's param defaults, become module variables.
+func expandTemplates(tasks []Task, templates map[string]Template) error {
+	for i, task := range tasks {
+		if task.Use == "" {
+			continue
+		}
+
+		tmpl, ok := templates[task.Use]
+		if !ok {
+			return fmt.Errorf("module %q uses unknown template %q", task.Name, task.Use)
+		}
+
+		instance := tmpl.Task
+		overrideFields(&instance, task)
+		instance.Pacing = task.Pacing.withDefaults(instance.Pacing)
+
+		instance.With = make(map[string]string, len(tmpl.Params)+len(task.With))
+		for key, value := range tmpl.Params {
+			instance.With[key] = value
+		}
+		for key, value := range task.With {
+			instance.With[key] = value
+		}
+		instance.Use = ""
+
+		tasks[i] = instance
+	}
+	return nil
+}
+
+// overrideFields sets every field of instance that task sets itself, that is
+// every exported field where task doesn't hold the zero value. Pacing is
+// merged field by field by the caller, and use/with are the caller's too.
+func overrideFields(instance *Task, task Task) {
+	dst := reflect.ValueOf(instance).Elem()
+	src := reflect.ValueOf(task)
+	for i := 0; i < src.NumField(); i++ {
+		field := src.Type().Field(i)
+		switch field.Name {
+		case "Pacing", "Use", "With":
+			continue
+		}
+		if !field.IsExported() || src.Field(i).IsZero() {
+			continue
+		}
+		dst.Field(i).Set(src.Field(i))
+	}
+}
+
+// taskVars returns the variables a module runs with: the workflow variables,
+// MODULE_NAME and WORKFLOW_DIR, plus the module's own with values, which may
+// reference any of them.
+func taskVars(task Task, vars map[string]string) map[string]string {
+	merged := make(map[string]string, len(vars)+len(task.With)+2)
+	for key, value := range vars {
+		merged[key] = value
+	}
+	if _, exists := merged["MODULE_NAME"]; !exists {
+		merged["MODULE_NAME"] = task.Name
+	}
+	if _, exists := merged["WORKFLOW_DIR"]; !exists && task.dir != "" {
+		merged["WORKFLOW_DIR"] = task.dir
+	}
+	for key, value := range task.With {
+		merged[key] = replacePlaceholders(value, merged)
+	}
+	return merged
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// TestExpandTemplatesOverrides checks that a field set on a module using a
+// template reaches the instance, for fields the template sets too and for
+// those it doesn't.
+func TestExpandTemplatesOverrides(t *testing.T) {
+	const template = `
+params:
+  WHO: world
+cmds:
+  - echo hello {{WHO}}
+env:
+  FROM: template
+`
+	tests := []struct {
+		name   string
+		module string
+		got    func(Task) interface{}
+		want   interface{}
+	}{
+		{"cmds", "cmds: [echo own]", func(t Task) interface{} { return t.Cmds[0].Shell }, "echo own"},
+		{"env", "env: {FROM: module}", func(t Task) interface{} { return t.Env }, map[string]string{"FROM": "module"}},
+		{"matrix", "matrix: {PORT: ['80', '443']}", func(t Task) interface{} { return t.Matrix }, map[string][]string{"PORT": {"80", "443"}}},
+		{"skip_if", "skip_if: 'DOMAIN == \"\"'", func(t Task) interface{} { return t.SkipIf }, `DOMAIN == ""`},
+		{"only_if", "only_if: 'DOMAIN != \"\"'", func(t Task) interface{} { return t.OnlyIf }, `DOMAIN != ""`},
+		{"when", "when: 'DOMAIN != \"\"'", func(t Task) interface{} { return t.When }, `DOMAIN != ""`},
+		{"fail_if", "fail_if: 'EXIT_CODE == 3'", func(t Task) interface{} { return t.FailIf }, "EXIT_CODE == 3"},
+		{"allowed_exit_codes", "allowed_exit_codes: [1, 2]", func(t Task) interface{} { return t.AllowedExit }, []int{1, 2}},
+		{"skip_exit_codes", "skip_exit_codes: [3]", func(t Task) interface{} { return t.SkipExit }, []int{3}},
+		{"retry", "retry: {attempts: 3}", func(t Task) interface{} { return t.Retry.Attempts }, 3},
+		{"artifacts", "artifacts: [out.txt]", func(t Task) interface{} { return t.Artifacts }, []string{"out.txt"}},
+		{"max_failures", "max_failures: 2", func(t Task) interface{} { return *t.MaxFailures }, 2},
+	}
+
+	var tmpl Template
+	if err := yaml.UnmarshalStrict([]byte(template), &tmpl); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var task Task
+			if err := yaml.UnmarshalStrict([]byte("name: m\nuse: tmpl\n"+tt.module), &task); err != nil {
+				t.Fatal(err)
+			}
+			tasks := []Task{task}
+			if err := expandTemplates(tasks, map[string]Template{"tmpl": tmpl}); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.got(tasks[0]); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+			if tasks[0].Name != "m" || tasks[0].Use != "" || tasks[0].With["WHO"] != "world" {
+				t.Errorf("instance lost its name or params: %+v", tasks[0])
+			}
+		})
+	}
+}
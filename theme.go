@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+)
+
+// colorSlots are the colors rayder's output is written with. A theme remaps
+// each slot to another terminal color.
+var colorSlots = []string{"cyan", "magenta", "white", "yellow", "red", "green"}
+
+var colorNames = map[string]color.Attribute{
+	"black":      color.FgBlack,
+	"red":        color.FgRed,
+	"green":      color.FgGreen,
+	"yellow":     color.FgYellow,
+	"blue":       color.FgBlue,
+	"magenta":    color.FgMagenta,
+	"cyan":       color.FgCyan,
+	"white":      color.FgWhite,
+	"hi-black":   color.FgHiBlack,
+	"hi-red":     color.FgHiRed,
+	"hi-green":   color.FgHiGreen,
+	"hi-yellow":  color.FgHiYellow,
+	"hi-blue":    color.FgHiBlue,
+	"hi-magenta": color.FgHiMagenta,
+	"hi-cyan":    color.FgHiCyan,
+	"hi-white":   color.FgHiWhite,
+}
+
+// builtinThemes can be selected by name instead of listing every slot.
+var builtinThemes = map[string]string{
+	"default": "",
+	"light":   "white=black,yellow=blue,cyan=magenta,magenta=hi-black",
+}
+
+// colorsDisabled reports whether output should be plain: when requested with
+// -no-color or NO_COLOR, on dumb terminals, or when stderr (where rayder logs)
+// isn't a terminal.
+func colorsDisabled(noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return true
+	}
+	fd := os.Stderr.Fd()
+	return !isatty.IsTerminal(fd) && !isatty.IsCygwinTerminal(fd)
+}
+
+// parseTheme turns a builtin theme name or a list of slot=color pairs
+// (e.g. "yellow=blue,white=black") into a slot to color mapping.
+func parseTheme(spec string) (map[string]color.Attribute, error) {
+	if builtin, ok := builtinThemes[spec]; ok {
+		spec = builtin
+	}
+
+	theme := make(map[string]color.Attribute, len(colorSlots))
+	for _, slot := range colorSlots {
+		theme[slot] = colorNames[slot]
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		slot, name, ok := strings.Cut(pair, "=")
+		if _, known := theme[slot]; !ok || !known {
+			return nil, fmt.Errorf("invalid theme entry %q, expected one of %s followed by =color", pair, strings.Join(colorSlots, ", "))
+		}
+
+		attr, ok := colorNames[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown color %q in theme", name)
+		}
+		theme[slot] = attr
+	}
+
+	return theme, nil
+}
+
+// themeColor returns the print function for a color slot under theme.
+func themeColor(theme map[string]color.Attribute, slot string) func(a ...interface{}) string {
+	return color.New(theme[slot]).SprintFunc()
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseTOML decodes the subset of TOML that workflows need: tables, arrays
+// of tables, dotted keys, strings (basic, literal and multi-line), integers,
+// floats, booleans, arrays and inline tables. Dates are kept as strings.
+func parseTOML(content string) (map[string]interface{}, error) {
+	p := &tomlParser{src: content, line: 1}
+	root := make(map[string]interface{})
+	current := root
+
+	for {
+		p.skipBlank(true)
+		if p.eof() {
+			return root, nil
+		}
+
+		if p.peek() == '[' {
+			array := strings.HasPrefix(p.src[p.pos:], "[[")
+			if array {
+				p.pos += 2
+			} else {
+				p.pos++
+			}
+			keys, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			p.skipBlank(false)
+			closing := "]"
+			if array {
+				closing = "]]"
+			}
+			if !strings.HasPrefix(p.src[p.pos:], closing) {
+				return nil, p.errorf("expected %s", closing)
+			}
+			p.pos += len(closing)
+
+			if array {
+				current, err = appendTable(root, keys)
+			} else {
+				current, err = descend(root, keys)
+			}
+			if err != nil {
+				return nil, p.errorf("%v", err)
+			}
+		} else {
+			if err := p.parseKeyValue(current); err != nil {
+				return nil, err
+			}
+		}
+
+		p.skipBlank(false)
+		if !p.eof() && p.peek() != '\n' && p.peek() != '\r' {
+			return nil, p.errorf("expected end of line")
+		}
+	}
+}
+
+type tomlParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) eof() bool  { return p.pos >= len(p.src) }
+func (p *tomlParser) peek() byte { return p.src[p.pos] }
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// skipBlank skips spaces and comments, and newlines too when newlines is
+// set.
+func (p *tomlParser) skipBlank(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t':
+			p.pos++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		case newlines && (c == '\n' || c == '\r'):
+			if c == '\n' {
+				p.line++
+			}
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) parseKey() ([]string, error) {
+	var keys []string
+	for {
+		p.skipBlank(false)
+		if p.eof() {
+			return nil, p.errorf("expected a key")
+		}
+
+		var key string
+		switch p.peek() {
+		case '"':
+			s, err := p.parseBasicString()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		case '\'':
+			s, err := p.parseLiteralString()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, p.errorf("invalid key")
+			}
+			key = p.src[start:p.pos]
+		}
+		keys = append(keys, key)
+
+		p.skipBlank(false)
+		if p.eof() || p.peek() != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) parseKeyValue(table map[string]interface{}) error {
+	keys, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	if p.eof() || p.peek() != '=' {
+		return p.errorf("expected = after key %s", strings.Join(keys, "."))
+	}
+	p.pos++
+	p.skipBlank(false)
+
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+
+	parent, err := descend(table, keys[:len(keys)-1])
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+	last := keys[len(keys)-1]
+	if _, exists := parent[last]; exists {
+		return p.errorf("duplicate key %s", strings.Join(keys, "."))
+	}
+	parent[last] = value
+	return nil
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	if p.eof() {
+		return nil, p.errorf("expected a value")
+	}
+
+	rest := p.src[p.pos:]
+	switch {
+	case strings.HasPrefix(rest, `"""`):
+		return p.parseMultilineString(`"""`, true)
+	case strings.HasPrefix(rest, "'''"):
+		return p.parseMultilineString("'''", false)
+	case rest[0] == '"':
+		return p.parseBasicString()
+	case rest[0] == '\'':
+		return p.parseLiteralString()
+	case rest[0] == '[':
+		return p.parseArray()
+	case rest[0] == '{':
+		return p.parseInlineTable()
+	case strings.HasPrefix(rest, "true"):
+		p.pos += 4
+		return true, nil
+	case strings.HasPrefix(rest, "false"):
+		p.pos += 5
+		return false, nil
+	}
+
+	start := p.pos
+	for !p.eof() && !strings.ContainsRune(" \t\r\n,]}#", rune(p.peek())) {
+		p.pos++
+	}
+	token := p.src[start:p.pos]
+	clean := strings.ReplaceAll(token, "_", "")
+	if n, err := strconv.ParseInt(clean, 0, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(clean, 64); err == nil {
+		return f, nil
+	}
+	// Dates and times are passed through as strings.
+	if len(token) >= 10 && token[4] == '-' && token[7] == '-' {
+		return token, nil
+	}
+	return nil, p.errorf("invalid value %q", token)
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	p.pos++ // opening quote
+	var sb strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '"':
+			return sb.String(), nil
+		case '\\':
+			if err := p.parseEscape(&sb); err != nil {
+				return "", err
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+}
+
+func (p *tomlParser) parseLiteralString() (string, error) {
+	p.pos++
+	end := strings.IndexAny(p.src[p.pos:], "'\n")
+	if end < 0 || p.src[p.pos+end] != '\'' {
+		return "", p.errorf("unterminated string")
+	}
+	s := p.src[p.pos : p.pos+end]
+	p.pos += end + 1
+	return s, nil
+}
+
+func (p *tomlParser) parseMultilineString(delim string, escapes bool) (string, error) {
+	p.pos += len(delim)
+	// A newline directly after the opening delimiter is trimmed.
+	if strings.HasPrefix(p.src[p.pos:], "\r\n") {
+		p.pos += 2
+		p.line++
+	} else if strings.HasPrefix(p.src[p.pos:], "\n") {
+		p.pos++
+		p.line++
+	}
+
+	var sb strings.Builder
+	for {
+		if p.eof() {
+			return "", p.errorf("unterminated multi-line string")
+		}
+		if strings.HasPrefix(p.src[p.pos:], delim) {
+			p.pos += len(delim)
+			return sb.String(), nil
+		}
+
+		c := p.peek()
+		p.pos++
+		if c == '\n' {
+			p.line++
+		}
+		if c != '\\' || !escapes {
+			sb.WriteByte(c)
+			continue
+		}
+
+		// A backslash at the end of a line trims the line break and the
+		// whitespace that follows it.
+		if rest := strings.TrimLeft(p.src[p.pos:], " \t"); strings.HasPrefix(rest, "\n") || strings.HasPrefix(rest, "\r\n") {
+			for !p.eof() && strings.ContainsRune(" \t\r\n", rune(p.peek())) {
+				if p.peek() == '\n' {
+					p.line++
+				}
+				p.pos++
+			}
+			continue
+		}
+		if err := p.parseEscape(&sb); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (p *tomlParser) parseEscape(sb *strings.Builder) error {
+	if p.eof() {
+		return p.errorf("unterminated escape")
+	}
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'b':
+		sb.WriteByte('\b')
+	case 't':
+		sb.WriteByte('\t')
+	case 'n':
+		sb.WriteByte('\n')
+	case 'f':
+		sb.WriteByte('\f')
+	case 'r':
+		sb.WriteByte('\r')
+	case '"', '\\':
+		sb.WriteByte(c)
+	case 'u', 'U':
+		size := 4
+		if c == 'U' {
+			size = 8
+		}
+		if p.pos+size > len(p.src) {
+			return p.errorf("invalid unicode escape")
+		}
+		r, err := strconv.ParseUint(p.src[p.pos:p.pos+size], 16, 32)
+		if err != nil {
+			return p.errorf("invalid unicode escape")
+		}
+		sb.WriteRune(rune(r))
+		p.pos += size
+	default:
+		return p.errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
+
+func (p *tomlParser) parseArray() ([]interface{}, error) {
+	p.pos++
+	values := []interface{}{}
+	for {
+		p.skipBlank(true)
+		if p.eof() {
+			return nil, p.errorf("unterminated array")
+		}
+		if p.peek() == ']' {
+			p.pos++
+			return values, nil
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		p.skipBlank(true)
+		if !p.eof() && p.peek() == ',' {
+			p.pos++
+		} else if p.eof() || p.peek() != ']' {
+			return nil, p.errorf("expected , or ] in array")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (map[string]interface{}, error) {
+	p.pos++
+	table := make(map[string]interface{})
+	for {
+		p.skipBlank(false)
+		if p.eof() {
+			return nil, p.errorf("unterminated inline table")
+		}
+		if p.peek() == '}' {
+			p.pos++
+			return table, nil
+		}
+
+		if err := p.parseKeyValue(table); err != nil {
+			return nil, err
+		}
+
+		p.skipBlank(false)
+		if !p.eof() && p.peek() == ',' {
+			p.pos++
+		} else if p.eof() || p.peek() != '}' {
+			return nil, p.errorf("expected , or } in inline table")
+		}
+	}
+}
+
+// descend returns the table at keys below table, creating missing tables.
+// A key holding an array of tables refers to its last element.
+func descend(table map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for _, key := range keys {
+		switch next := table[key].(type) {
+		case nil:
+			child := make(map[string]interface{})
+			table[key] = child
+			table = child
+		case map[string]interface{}:
+			table = next
+		case []interface{}:
+			if len(next) == 0 {
+				return nil, fmt.Errorf("%s is not a table", key)
+			}
+			last, ok := next[len(next)-1].(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not a table", key)
+			}
+			table = last
+		default:
+			return nil, fmt.Errorf("%s is not a table", key)
+		}
+	}
+	return table, nil
+}
+
+// appendTable adds a new table to the array of tables at keys.
+func appendTable(root map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	parent, err := descend(root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	last := keys[len(keys)-1]
+	table := make(map[string]interface{})
+	switch existing := parent[last].(type) {
+	case nil:
+		parent[last] = []interface{}{table}
+	case []interface{}:
+		parent[last] = append(existing, table)
+	default:
+		return nil, fmt.Errorf("%s is not an array of tables", last)
+	}
+	return table, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const toolVersionTimeout = 10 * time.Second
+
+var (
+	toolConstraintPattern = regexp.MustCompile(`^\s*([^<>=!\s]+)\s*(?:(>=|<=|==|!=|>|<|=)\s*v?([0-9][0-9.]*))?\s*$`)
+	versionPattern        = regexp.MustCompile(`\d+(\.\d+)+`)
+)
+
+// ToolRequirement is an entry of requires_tools: a binary that must be in
+// PATH, optionally with a version constraint such as "nuclei>=3.0".
+type ToolRequirement struct {
+	Name    string
+	Op      string
+	Version string
+}
+
+func (t *ToolRequirement) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	m := toolConstraintPattern.FindStringSubmatch(s)
+	if m == nil {
+		return fmt.Errorf("invalid tool requirement %q", s)
+	}
+	t.Name, t.Op, t.Version = m[1], m[2], m[3]
+	if t.Op == "=" {
+		t.Op = "=="
+	}
+	return nil
+}
+
+func (t ToolRequirement) String() string {
+	if t.Op == "" {
+		return t.Name
+	}
+	return t.Name + t.Op + t.Version
+}
+
+// check returns why the requirement is not met, or "" if it is.
+func (t ToolRequirement) check() string {
+	path, err := exec.LookPath(t.Name)
+	if err != nil {
+		return "not found in PATH"
+	}
+	if t.Op == "" {
+		return ""
+	}
+
+	installed, err := toolVersion(path)
+	if err != nil {
+		return fmt.Sprintf("could not determine version: %v", err)
+	}
+	if !compareVersions(installed, t.Op, t.Version) {
+		return fmt.Sprintf("version %s installed", installed)
+	}
+	return ""
+}
+
+// toolVersion returns the first version number printed by the tool, trying
+// the common spellings of the version flag in turn.
+func toolVersion(path string) (string, error) {
+	for _, arg := range []string{"--version", "-version", "version"} {
+		ctx, cancel := context.WithTimeout(context.Background(), toolVersionTimeout)
+		// Many tools exit non-zero for their version flag, so only the
+		// output matters.
+		out, _ := exec.CommandContext(ctx, path, arg).CombinedOutput()
+		cancel()
+
+		if version := versionPattern.FindString(string(out)); version != "" {
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("no version in the output of %s --version", path)
+}
+
+// compareVersions compares dotted version numbers component by component,
+// treating missing components as 0.
+func compareVersions(installed, op, wanted string) bool {
+	a, b := strings.Split(installed, "."), strings.Split(wanted, ".")
+	cmp := 0
+	for i := 0; cmp == 0 && (i < len(a) || i < len(b)); i++ {
+		var x, y int
+		if i < len(a) {
+			x, _ = strconv.Atoi(a[i])
+		}
+		if i < len(b) {
+			y, _ = strconv.Atoi(b[i])
+		}
+		cmp = compareFloats(float64(x), float64(y))
+	}
+
+	switch op {
+	case ">=":
+		return cmp >= 0
+	case ">":
+		return cmp > 0
+	case "<=":
+		return cmp <= 0
+	case "<":
+		return cmp < 0
+	case "!=":
+		return cmp != 0
+	}
+	return cmp == 0
+}
+
+// checkTools returns a description of every requirement that is not met.
+func checkTools(tools []ToolRequirement) []string {
+	var problems []string
+	seen := make(map[string]bool)
+	for _, tool := range tools {
+		if seen[tool.String()] {
+			continue
+		}
+		seen[tool.String()] = true
+
+		if reason := tool.check(); reason != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s", tool, reason))
+		}
+	}
+	return problems
+}
+
+// installTools runs the install command of every required tool that is
+// missing or does not match its version constraint. Tools without an install
+// command are left for checkTools to report.
+func installTools(tools []ToolRequirement, install map[string]string, shell string, yellow, cyan, red func(a ...interface{}) string) error {
+	done := make(map[string]bool)
+	for _, tool := range tools {
+		cmdStr, ok := install[tool.Name]
+		if !ok || done[tool.Name] || tool.check() == "" {
+			continue
+		}
+		done[tool.Name] = true
+
+		logLifecycle("[%s] [%s] Installing '%s' $ %s\n", yellow(currentTime()), yellow("INFO"), cyan(tool.Name), cmdStr)
+		cmd := shellCommand(shell, cmdStr)
+		if showToolOutput(false) {
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+		}
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("installing %s failed: %w", tool.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,627 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// tuiMode is set by -tui.
+var tuiMode bool
+
+// tui is the terminal UI of the run, nil unless -tui is given.
+var tui *tuiRunner
+
+// errStopped ends a module skipped or cancelled from the terminal UI.
+var errStopped = errors.New("stopped from the terminal UI")
+
+// tuiLogLines is how much of the output of each module the UI keeps.
+const tuiLogLines = 1000
+
+var tuiSpinner = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// tuiRunner draws the run on the terminal: a list of the modules with their
+// status and a pane with the output of the selected one, refreshed a few
+// times a second. Everything rayder and the tools print while it runs goes
+// to the UI instead of the terminal. Keys skip, cancel and retry modules.
+type tuiRunner struct {
+	mu       sync.Mutex
+	tty      *os.File
+	stty     string // terminal state to restore
+	title    string
+	started  time.Time
+	modules  []*tuiModule
+	byName   map[string]*tuiModule
+	root     map[string]bool
+	runLog   *tailBuffer
+	focus    int // 0 is the run log, i the module i-1
+	rows     int
+	cols     int
+	frame    int
+	message  string
+	finished bool
+	queue    []string
+	wake     chan struct{}
+	quit     chan struct{}
+	closed   bool
+
+	cyan, yellow, red, green func(a ...interface{}) string
+
+	stopRender chan struct{}
+	rendered   sync.WaitGroup
+	restoreOut func()
+	stopOnce   sync.Once
+	stdoutPipe *os.File
+	forwarded  sync.WaitGroup
+}
+
+type tuiModule struct {
+	name     string
+	status   string // empty while pending
+	started  time.Time
+	duration time.Duration
+	log      *tailBuffer
+	cmds     map[*exec.Cmd]bool
+	stopped  string // status asked for from the UI
+	done     int    // instances finished, of a module running several
+	total    int
+	task     *Task // the workflow's own modules, which can be retried
+}
+
+// startTUI takes over the terminal for a run of the workflows in files
+// running tasks.
+func startTUI(files []string, tasks []Task, cyan, yellow, red, green func(a ...interface{}) string) (*tuiRunner, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("-tui needs a terminal: %w", err)
+	}
+	state, err := sttyOutput(tty, "-g")
+	if err != nil {
+		tty.Close()
+		return nil, fmt.Errorf("-tui needs a terminal: %w", err)
+	}
+	// Signals stay on, so Ctrl-C cancels the run as usual.
+	if _, err := sttyOutput(tty, "-icanon", "-echo", "min", "1"); err != nil {
+		tty.Close()
+		return nil, fmt.Errorf("setting up the terminal: %w", err)
+	}
+
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = filepath.Base(file)
+	}
+	t := &tuiRunner{
+		tty:        tty,
+		stty:       strings.TrimSpace(state),
+		title:      strings.Join(names, ", "),
+		started:    time.Now(),
+		byName:     make(map[string]*tuiModule),
+		root:       make(map[string]bool),
+		runLog:     newTailBuffer(tuiLogLines),
+		wake:       make(chan struct{}, 1),
+		quit:       make(chan struct{}),
+		stopRender: make(chan struct{}),
+		cyan:       cyan,
+		yellow:     yellow,
+		red:        red,
+		green:      green,
+	}
+	for i := range tasks {
+		m := t.module(tasks[i].Name)
+		m.task = &tasks[i]
+		t.root[tasks[i].Name] = true
+	}
+	if len(t.modules) > 0 {
+		t.focus = 1
+	}
+	t.resize()
+
+	// What rayder itself prints goes to the run log.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.restoreTerminal()
+		return nil, err
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = w, w
+	t.stdoutPipe = w
+	t.restoreOut = func() { os.Stdout, os.Stderr = stdout, stderr }
+	t.forwarded.Add(1)
+	go func() {
+		defer t.forwarded.Done()
+		io.Copy(t.logWriter(t.runLog), r)
+		r.Close()
+	}()
+
+	fmt.Fprint(tty, "\x1b[?1049h\x1b[?25l")
+	t.rendered.Add(1)
+	go t.renderLoop()
+	go t.readKeys()
+	return t, nil
+}
+
+func sttyOutput(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// module returns the entry of the module name, adding it for the modules of
+// sub-workflows, which aren't known up front. Callers hold mu or haven't
+// started the UI yet.
+func (t *tuiRunner) module(name string) *tuiModule {
+	m, ok := t.byName[name]
+	if !ok {
+		m = &tuiModule{name: name, log: newTailBuffer(tuiLogLines), cmds: make(map[*exec.Cmd]bool)}
+		t.byName[name] = m
+		t.modules = append(t.modules, m)
+	}
+	return m
+}
+
+// logWriter returns a writer appending to buffer, and to the run log file
+// when there is one.
+func (t *tuiRunner) logWriter(buffer *tailBuffer) io.Writer {
+	if runLogWriter != nil {
+		return io.MultiWriter(buffer, runLogWriter)
+	}
+	return buffer
+}
+
+func (t *tuiRunner) moduleStarted(name string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := t.module(name)
+	m.status, m.started = "running", time.Now()
+	m.done, m.total = 0, 0
+}
+
+// instanceProgress shows how many of the instances of the module name have
+// finished.
+func (t *tuiRunner) instanceProgress(name string, done, total int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := t.module(name)
+	m.done, m.total = done, total
+}
+
+// moduleFinished shows the outcome of a module. As in the run history, a
+// module that was skipped stays skipped.
+func (t *tuiRunner) moduleFinished(name, status string, duration time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := t.module(name)
+	if m.status != statusSkipped {
+		m.status, m.duration = status, duration
+	}
+}
+
+// output returns the writer for the tool output of the module name.
+func (t *tuiRunner) output(name string) io.Writer {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.logWriter(t.module(name).log)
+}
+
+// track keeps cmd of the module name, so it can be stopped from the UI, or
+// reports false when the module was stopped already.
+func (t *tuiRunner) track(name string, cmd *exec.Cmd) bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := t.module(name)
+	if m.stopped != "" {
+		return false
+	}
+	m.cmds[cmd] = true
+	return true
+}
+
+func (t *tuiRunner) untrack(name string, cmd *exec.Cmd) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.module(name).cmds, cmd)
+}
+
+// stopped returns the status the module name was given from the UI, or "".
+func (t *tuiRunner) stopped(name string) string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if m, ok := t.byName[name]; ok {
+		return m.stopped
+	}
+	return ""
+}
+
+// isRoot reports whether tasks are the modules of the workflow the UI runs,
+// rather than of a sub-workflow.
+func (t *tuiRunner) isRoot(tasks []Task) bool {
+	return t != nil && len(tasks) > 0 && t.root[tasks[0].Name]
+}
+
+// nextRetry waits at the end of the run for a module to be retried and
+// returns its name, or reports false once the UI is quit or the run was
+// cancelled.
+func (t *tuiRunner) nextRetry() (string, bool) {
+	t.mu.Lock()
+	t.finished = true
+	t.message = "Run finished: r retries the selected module, q quits"
+	t.mu.Unlock()
+
+	for !control.isCancelled() {
+		t.mu.Lock()
+		if len(t.queue) > 0 {
+			name := t.queue[0]
+			t.queue = t.queue[1:]
+			t.mu.Unlock()
+			return name, true
+		}
+		t.mu.Unlock()
+
+		select {
+		case <-t.wake:
+		case <-t.quit:
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func (t *tuiRunner) readKeys() {
+	in := bufio.NewReader(t.tty)
+	for {
+		b, err := in.ReadByte()
+		if err != nil {
+			return
+		}
+		select {
+		case <-t.stopRender:
+			return
+		default:
+		}
+		key := string(b)
+		if b == 0x1b {
+			// Arrow keys arrive as ESC [ A and ESC [ B.
+			if next, _ := in.ReadByte(); next == '[' {
+				arrow, _ := in.ReadByte()
+				key = map[byte]string{'A': "up", 'B': "down"}[arrow]
+			}
+		}
+		t.handleKey(key)
+	}
+}
+
+func (t *tuiRunner) handleKey(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var m *tuiModule
+	if t.focus > 0 {
+		m = t.modules[t.focus-1]
+	}
+	switch key {
+	case "k", "up":
+		if t.focus > 0 {
+			t.focus--
+		}
+	case "j", "down":
+		if t.focus < len(t.modules) {
+			t.focus++
+		}
+	case "s", "c":
+		status := statusSkipped
+		if key == "c" {
+			status = statusCancelled
+		}
+		switch {
+		case m == nil:
+		case m.status != "" && m.status != "running":
+			t.message = fmt.Sprintf("Module '%s' has finished already", m.name)
+		default:
+			m.stopped = status
+			for cmd := range m.cmds {
+				if pid := control.pid(cmd); pid != 0 {
+					terminateTree(pid, false)
+				}
+			}
+			t.message = fmt.Sprintf("Module '%s' %s", m.name, status)
+		}
+	case "r":
+		switch {
+		case m == nil:
+		case m.task == nil:
+			t.message = "Only the modules of the workflow itself can be retried"
+		case m.status != statusErrored && m.status != statusCancelled:
+			t.message = "Only errored and cancelled modules can be retried"
+		case m.task.Stream != "" || m.task.StdinStream != "":
+			t.message = "Modules using streams can't be retried"
+		case containsString(t.queue, m.name):
+		default:
+			t.queue = append(t.queue, m.name)
+			m.status, m.stopped = "", ""
+			if !t.finished {
+				t.message = fmt.Sprintf("Module '%s' runs again at the end of the run", m.name)
+			}
+			select {
+			case t.wake <- struct{}{}:
+			default:
+			}
+		}
+	case "p":
+		if control.togglePause() {
+			t.message = "Paused: running modules finish, no new ones start"
+		} else {
+			t.message = "Resumed"
+		}
+	case "q":
+		if t.finished {
+			if !t.closed {
+				t.closed = true
+				close(t.quit)
+			}
+			return
+		}
+		t.message = "Cancelling the run..."
+		go control.cancel()
+	}
+}
+
+// resize reads the size of the terminal.
+func (t *tuiRunner) resize() {
+	size, err := sttyOutput(t.tty, "size")
+	var rows, cols int
+	if err == nil {
+		fmt.Sscan(size, &rows, &cols)
+	}
+	if rows < 10 {
+		rows = 10
+	}
+	if cols < 40 {
+		cols = 40
+	}
+	t.mu.Lock()
+	t.rows, t.cols = rows, cols
+	t.mu.Unlock()
+}
+
+func (t *tuiRunner) renderLoop() {
+	defer t.rendered.Done()
+	ticker := time.NewTicker(150 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stopRender:
+			return
+		case <-ticker.C:
+		}
+		if t.frame%7 == 0 {
+			t.resize()
+		}
+		t.mu.Lock()
+		screen := t.render()
+		t.mu.Unlock()
+		t.tty.WriteString(screen)
+	}
+}
+
+// render draws the whole screen. Callers hold mu.
+func (t *tuiRunner) render() string {
+	t.frame++
+	var b strings.Builder
+	line := func(s string) {
+		b.WriteString(s + "\x1b[K\r\n")
+	}
+
+	done := 0
+	for _, m := range t.modules {
+		if t.root[m.name] && m.status != "" && m.status != "running" {
+			done++
+		}
+	}
+	state := ""
+	switch {
+	case control.isCancelled():
+		state = t.red("  cancelled")
+	case control.isPaused():
+		state = t.yellow("  paused")
+	}
+	header := fmt.Sprintf("rayder %s  %d/%d modules  %s", t.title, done, len(t.root), formatElapsed(time.Since(t.started)))
+	b.WriteString("\x1b[H")
+	line(t.cyan(truncate(header, t.cols)) + state)
+
+	// The list takes up to half of the screen, scrolled to the selection.
+	entries := len(t.modules) + 1
+	height := (t.rows - 4) / 2
+	if height < 3 {
+		height = 3
+	}
+	if height > entries {
+		height = entries
+	}
+	first := t.focus - height/2
+	if first > entries-height {
+		first = entries - height
+	}
+	if first < 0 {
+		first = 0
+	}
+	width := 0
+	for _, m := range t.modules {
+		if n := utf8.RuneCountInString(m.name); n > width {
+			width = n
+		}
+	}
+	if width > t.cols/2 {
+		width = t.cols / 2
+	}
+	for i := first; i < first+height; i++ {
+		marker := "  "
+		if i == t.focus {
+			marker = "> "
+		}
+		if i == 0 {
+			line(marker + "  " + truncate("run log", t.cols-4))
+			continue
+		}
+		m := t.modules[i-1]
+		icon, status := t.statusIcon(m)
+		elapsed := ""
+		switch {
+		case m.status == "running":
+			elapsed = formatElapsed(time.Since(m.started))
+		case m.status != "":
+			elapsed = formatElapsed(m.duration)
+		}
+		text := fmt.Sprintf("%-*s  %-9s  %s", width, truncate(m.name, width), status, elapsed)
+		if m.status == "running" && m.total > 0 {
+			text += fmt.Sprintf("  %s %d/%d%s", progressBar(m.done, m.total, 10), m.done, m.total, rateNote(m.done, m.total, time.Since(m.started)))
+		}
+		line(marker + icon + " " + truncate(text, t.cols-4))
+	}
+
+	name := "run log"
+	log := t.runLog
+	if t.focus > 0 {
+		name, log = t.modules[t.focus-1].name, t.modules[t.focus-1].log
+	}
+	line(truncate("── "+name+" "+strings.Repeat("─", t.cols), t.cols))
+	pane := t.rows - height - 3
+	lines := log.Lines()
+	if len(lines) > pane {
+		lines = lines[len(lines)-pane:]
+	}
+	for _, l := range lines {
+		line(truncate(cleanLogLine(l), t.cols))
+	}
+	for i := len(lines); i < pane; i++ {
+		line("")
+	}
+
+	footer := "↑/↓ select  s skip  c cancel  r retry  p pause  q cancel run"
+	if t.finished {
+		footer = "↑/↓ select  r retry  q quit"
+	}
+	if t.message != "" {
+		footer = t.message + "  |  " + footer
+	}
+	b.WriteString(truncate(footer, t.cols) + "\x1b[K\x1b[J")
+	return b.String()
+}
+
+func (t *tuiRunner) statusIcon(m *tuiModule) (string, string) {
+	switch m.status {
+	case "running":
+		return t.cyan(tuiSpinner[t.frame%len(tuiSpinner)]), "running"
+	case statusCompleted:
+		return t.green("✔"), m.status
+	case statusErrored:
+		return t.red("✖"), m.status
+	case statusSkipped, statusCancelled:
+		return t.yellow("-"), m.status
+	}
+	return "·", "pending"
+}
+
+// cleanLogLine drops what would garble the screen from a line of output:
+// escape sequences, and what a progress bar overwrote with \r.
+func cleanLogLine(s string) string {
+	if i := strings.LastIndex(s, "\r"); i >= 0 {
+		s = s[i+1:]
+	}
+	s = ansiPattern.ReplaceAllString(s, "")
+	return strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return ' '
+		}
+		if r < ' ' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// truncate cuts s to n runes.
+func truncate(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+func formatElapsed(d time.Duration) string {
+	d = d.Round(time.Second)
+	if d >= time.Hour {
+		return fmt.Sprintf("%d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+	}
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// stop gives the terminal back, then prints the end of the output of the
+// modules that errored, which the UI no longer shows.
+func (t *tuiRunner) stop() {
+	if t == nil {
+		return
+	}
+	t.stopOnce.Do(func() {
+		close(t.stopRender)
+		t.rendered.Wait()
+		t.restoreOut()
+		t.stdoutPipe.Close()
+		t.forwarded.Wait()
+		t.restoreTerminal()
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		for _, m := range t.modules {
+			if m.status != statusErrored {
+				continue
+			}
+			lines := m.log.Lines()
+			if len(lines) > defaultTailLines {
+				lines = lines[len(lines)-defaultTailLines:]
+			}
+			fmt.Fprintf(os.Stderr, "[%s] [%s] Module '%s' %s, last %d lines of output:\n", t.yellow(currentTime()), t.red("ERROR"), t.cyan(m.name), t.red(word("errored")), len(lines))
+			for _, line := range lines {
+				fmt.Fprintf(os.Stderr, "    %s\n", line)
+			}
+		}
+	})
+}
+
+func (t *tuiRunner) restoreTerminal() {
+	fmt.Fprint(t.tty, "\x1b[?25h\x1b[?1049l")
+	sttyOutput(t.tty, t.stty)
+	t.tty.Close()
+}
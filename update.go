@@ -0,0 +1,306 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// releaseRepo is the GitHub repository rayder update fetches releases from.
+const releaseRepo = "devanshbatham/rayder"
+
+// releasePublicKey is the minisign public key the release checksums are
+// signed with, in the format of minisign -G. Release builds ship it in
+// release.pub; a build without it can only update with -pubkey or
+// -insecure.
+//
+//go:embed release.pub
+var releasePublicKey []byte
+
+// githubRelease is the part of a GitHub release rayder update uses.
+type githubRelease struct {
+	Tag    string `json:"tag_name"`
+	Assets []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// runUpdateCommand replaces the running binary with the one of the latest
+// release for this platform, after checking the signature of the checksums
+// published with the release and the binary against them. Only -insecure
+// skips the signature.
+func runUpdateCommand(args []string) int {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	check := fs.Bool("check", false, "Only report whether a newer release is available")
+	force := fs.Bool("force", false, "Install the latest release even if it is not newer")
+	repo := fs.String("repo", releaseRepo, "GitHub repository to fetch releases from")
+	pubkey := fs.String("pubkey", "", "Public key to verify the signature of the release checksums with, instead of the built-in one")
+	sigTool := fs.String("sig-tool", "minisign", "Signature tool to verify with: minisign or cosign")
+	insecure := fs.Bool("insecure", false, "Install without verifying the signature of the release, trusting its checksums alone")
+	fs.Parse(args)
+
+	opts := verifyOptions{pubkey: *pubkey, sigTool: *sigTool}
+	switch {
+	case *check:
+		// Only reporting needs no key.
+	case *insecure:
+		opts.pubkey = ""
+		fmt.Fprintln(os.Stderr, "Warning: -insecure, the release signature is not verified")
+	case opts.pubkey == "" && len(bytes.TrimSpace(releasePublicKey)) == 0:
+		fmt.Fprintln(os.Stderr, "Error: this build has no release key built in, give one with -pubkey, or -insecure to trust the release checksums alone")
+		return 1
+	case opts.pubkey == "":
+		// The built-in key is a minisign key, whatever -sig-tool says.
+		key, err := os.CreateTemp("", "rayder-release-*.pub")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		defer os.Remove(key.Name())
+		key.Write(releasePublicKey)
+		key.Close()
+		opts = verifyOptions{pubkey: key.Name(), sigTool: "minisign"}
+	}
+
+	release, err := latestRelease(*repo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	newer := compareVersions(strings.TrimPrefix(release.Tag, "v"), ">", strings.TrimPrefix(version, "v"))
+	if !newer && !*force {
+		fmt.Printf("rayder %s is up to date\n", version)
+		return 0
+	}
+	if *check {
+		fmt.Printf("rayder %s is available, this is %s\n", release.Tag, version)
+		return 0
+	}
+
+	if err := installRelease(release, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Updated rayder from %s to %s\n", version, release.Tag)
+	return 0
+}
+
+// latestRelease fetches the latest release of repo. GITHUB_TOKEN, when set,
+// lifts the API's rate limit for unauthenticated requests.
+func latestRelease(repo string) (*githubRelease, error) {
+	req, err := http.NewRequest("GET", "https://api.github.com/repos/"+repo+"/releases/latest", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching the latest release: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching the latest release of %s: %s", repo, resp.Status)
+	}
+
+	var release githubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return nil, fmt.Errorf("reading the latest release: %w", err)
+	}
+	return &release, nil
+}
+
+// installRelease downloads the archive of release for this platform,
+// verifies it and replaces the running binary with the one it holds. The
+// signature of the checksums is verified unless opts has no public key.
+func installRelease(release *githubRelease, opts verifyOptions) error {
+	var archive, archiveURL, checksums, checksumsURL string
+	platform := runtime.GOOS + "_" + runtime.GOARCH
+	for _, asset := range release.Assets {
+		name := strings.ToLower(asset.Name)
+		switch {
+		case strings.Contains(name, "checksums") || strings.HasSuffix(name, "sha256sums"):
+			if !strings.HasSuffix(name, ".minisig") && !strings.HasSuffix(name, ".sig") {
+				checksums, checksumsURL = asset.Name, asset.URL
+			}
+		case strings.Contains(name, platform) && !strings.HasSuffix(name, ".minisig") && !strings.HasSuffix(name, ".sig"):
+			archive, archiveURL = asset.Name, asset.URL
+		}
+	}
+	if archive == "" {
+		return fmt.Errorf("release %s has no build for %s", release.Tag, platform)
+	}
+	if checksums == "" {
+		return fmt.Errorf("release %s publishes no checksums, not installing an unverified binary", release.Tag)
+	}
+
+	dir, err := os.MkdirTemp("", "rayder-update-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	checksumsPath := filepath.Join(dir, checksums)
+	if err := downloadWorkflow(checksumsURL, checksumsPath); err != nil {
+		return err
+	}
+	if opts.pubkey != "" {
+		if err := verifySignature(checksumsURL, checksumsPath, opts.pubkey, opts.sigTool); err != nil {
+			return err
+		}
+	}
+	want, err := releaseChecksum(checksumsPath, archive)
+	if err != nil {
+		return err
+	}
+	archivePath := filepath.Join(dir, archive)
+	if err := downloadWorkflow(archiveURL, archivePath); err != nil {
+		return err
+	}
+	if err := verifyChecksum(archivePath, want); err != nil {
+		return fmt.Errorf("%s: %w", archive, err)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	// Written next to the binary so the final rename stays on one
+	// filesystem and can't leave a half written binary behind.
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".rayder-update-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := extractBinary(archivePath, tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%s: %w", archive, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	return replaceExecutable(exe, tmp.Name())
+}
+
+// releaseChecksum returns the SHA-256 listed for name in a checksums file
+// in the format of sha256sum.
+func releaseChecksum(path, name string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return fields[0], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no checksum for %s in %s", name, filepath.Base(path))
+}
+
+// extractBinary writes the rayder binary held in the archive at path, a
+// .tar.gz, a .zip or the bare binary, to out.
+func extractBinary(path string, out io.Writer) error {
+	binary := "rayder"
+	if runtime.GOOS == "windows" {
+		binary += ".exe"
+	}
+
+	switch {
+	case strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz"):
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		tr := tar.NewReader(gz)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if header.Typeflag == tar.TypeReg && filepath.Base(header.Name) == binary {
+				_, err := io.Copy(out, tr)
+				return err
+			}
+		}
+	case strings.HasSuffix(path, ".zip"):
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if !f.FileInfo().IsDir() && filepath.Base(f.Name) == binary {
+				rc, err := f.Open()
+				if err != nil {
+					return err
+				}
+				defer rc.Close()
+				_, err = io.Copy(out, rc)
+				return err
+			}
+		}
+	default:
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(out, file)
+		return err
+	}
+	return fmt.Errorf("no %s in the archive", binary)
+}
+
+// replaceExecutable moves the binary at path over exe. Windows can't
+// replace a running executable, but can rename it, so the old binary is
+// moved aside to exe.old first there; it is removed by the next update.
+func replaceExecutable(exe, path string) error {
+	if runtime.GOOS != "windows" {
+		return os.Rename(path, exe)
+	}
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(path, exe); err != nil {
+		os.Rename(old, exe)
+		return err
+	}
+	return nil
+}
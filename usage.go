@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// resourceUsage is what the commands of a module used, hooks included.
+type resourceUsage struct {
+	cpu        time.Duration
+	peakMemory uint64 // largest resident set of a single process, in bytes
+	written    uint64 // bytes written to storage
+}
+
+// moduleUsage collects the usage of the commands run so far per module.
+var moduleUsage = struct {
+	sync.Mutex
+	modules map[string]resourceUsage
+}{modules: make(map[string]resourceUsage)}
+
+// addUsage adds the usage of a finished command of module. The usage of a
+// process includes that of the descendants it waited for, so a shell
+// accounts for the tools it ran.
+func addUsage(module string, state *os.ProcessState) {
+	if state == nil {
+		return
+	}
+	peak, written := commandUsage(state)
+
+	moduleUsage.Lock()
+	defer moduleUsage.Unlock()
+	usage := moduleUsage.modules[module]
+	usage.cpu += state.UserTime() + state.SystemTime()
+	if peak > usage.peakMemory {
+		usage.peakMemory = peak
+	}
+	usage.written += written
+	moduleUsage.modules[module] = usage
+}
+
+func usageOf(module string) resourceUsage {
+	moduleUsage.Lock()
+	defer moduleUsage.Unlock()
+	return moduleUsage.modules[module]
+}
+
+// printSummary prints the outcome and resource usage of each module of run,
+// to help tune parallelism on small machines.
+func printSummary(run RunRecord, yellow func(a ...interface{}) string) {
+	if verbosity <= levelNoLifecycle || len(run.Modules) == 0 {
+		return
+	}
+	logLifecycle("[%s] [%s] Summary:\n", yellow(currentTime()), yellow("INFO"))
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "    MODULE\tSTATUS\tDURATION\tCPU\tPEAK MEMORY\tWRITTEN")
+	for _, module := range run.Modules {
+		if module.Status == statusSkipped {
+			fmt.Fprintf(w, "    %s\t%s\t-\t-\t-\t-\n", module.Name, module.Status)
+			continue
+		}
+		fmt.Fprintf(w, "    %s\t%s\t%s\t%s\t%s\t%s\n", module.Name, module.Status, module.Duration.Round(time.Millisecond), module.CPU.Round(time.Millisecond), formatBytes(module.PeakMemory), formatBytes(module.Written))
+	}
+	w.Flush()
+
+	if len(run.Findings) > 0 {
+		counts := make(map[string]int)
+		for _, finding := range run.Findings {
+			counts[finding.Kind]++
+		}
+		var parts []string
+		for _, kind := range []string{findingHost, findingPort, findingURL, findingVuln} {
+			switch counts[kind] {
+			case 0:
+			case 1:
+				parts = append(parts, "1 "+kind)
+			default:
+				parts = append(parts, fmt.Sprintf("%d %ss", counts[kind], kind))
+			}
+		}
+		logLifecycle("[%s] [%s] Findings: %s\n", yellow(currentTime()), yellow("INFO"), strings.Join(parts, ", "))
+	}
+}
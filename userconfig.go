@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// UserConfig holds the user's defaults from ~/.config/rayder/config.yaml.
+// Command line flags and environment variables take precedence.
+type UserConfig struct {
+	Registry    string   `yaml:"registry"`
+	Theme       string   `yaml:"theme"`
+	NoColor     bool     `yaml:"no_color"`
+	MaxParallel int      `yaml:"max_parallel"`
+	LogDir      string   `yaml:"log_dir"`
+	LogMaxSize  string   `yaml:"log_max_size"` // size at which run logs are rotated
+	LogMaxAge   string   `yaml:"log_max_age"`  // age at which run logs are removed
+	LogKeep     int      `yaml:"log_keep"`     // number of runs whose logs are kept
+	Webhooks    []string `yaml:"webhooks"`
+	DatabaseURL string   `yaml:"database_url"`
+
+	Banner      string            `yaml:"banner"`       // off, or text replacing the banner
+	NoEmoji     bool              `yaml:"no_emoji"`     // drop the markers not set in Markers
+	Markers     map[string]string `yaml:"markers"`      // start, success and failure markers
+	StatusWords map[string]string `yaml:"status_words"` // status words shown instead
+}
+
+var userConfig UserConfig
+
+// userConfigPath returns the location of the user config, which can be
+// changed with RAYDER_CONFIG.
+func userConfigPath() (string, error) {
+	if path := os.Getenv("RAYDER_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "rayder", "config.yaml"), nil
+}
+
+// loadUserConfig reads the user config. A missing file is not an error.
+func loadUserConfig() (UserConfig, error) {
+	var config UserConfig
+
+	path, err := userConfigPath()
+	if err != nil {
+		return config, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return config, err
+	}
+
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return config, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	config.LogDir = expandHome(config.LogDir)
+	if err := applyMarkers(config); err != nil {
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// expandHome replaces a leading ~ with the user's home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
+
+// runSummary is posted to the configured webhooks when a run ends. Text (and
+// Content, for Discord) make it readable in chat webhooks as is.
+type runSummary struct {
+	Text      string   `json:"text"`
+	Content   string   `json:"content"`
+	Workflows []string `json:"workflows"`
+	Status    string   `json:"status"`
+	Started   string   `json:"started"`
+	Duration  string   `json:"duration"`
+
+	// Changes lists what the artifacts gained and lost since the previous
+	// run, when it was compared.
+	Changes []artifactDiff `json:"changes,omitempty"`
+}
+
+// maxNotifiedLines caps the new lines per artifact quoted in the text of a
+// notification, to stay within the message size limits of chat services.
+const maxNotifiedLines = 10
+
+func notifyWebhooks(webhooks, workflows []string, started time.Time, ok bool, diffs []artifactDiff) {
+	if len(webhooks) == 0 {
+		return
+	}
+
+	status, outcome := "success", "succeeded"
+	if !ok {
+		status, outcome = "failed", "failed"
+	}
+	duration := time.Since(started).Round(time.Second)
+	text := fmt.Sprintf("rayder run of %s %s after %s", strings.Join(workflows, ", "), outcome, duration)
+	summary := runSummary{
+		Workflows: workflows,
+		Status:    status,
+		Started:   started.Format(time.RFC3339),
+		Duration:  duration.String(),
+		Changes:   diffs,
+	}
+	for _, d := range diffs {
+		text += fmt.Sprintf("\n%s: %d new, %d removed", d.Path, len(d.Added), len(d.Removed))
+		for i, line := range d.Added {
+			if i == maxNotifiedLines {
+				text += fmt.Sprintf("\n... and %d more", len(d.Added)-i)
+				break
+			}
+			text += "\n+ " + line
+		}
+	}
+	summary.Text, summary.Content = text, text
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	for _, url := range webhooks {
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: notifying %s: %v\n", url, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			fmt.Fprintf(os.Stderr, "Error: notifying %s: %s\n", url, resp.Status)
+		}
+	}
+}
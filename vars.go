@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// VarSpec describes a workflow variable. In YAML a variable is either its
+// default value or a map with default, description and required.
+type VarSpec struct {
+	Default     string `yaml:"default"`
+	Description string `yaml:"description"`
+	Required    bool   `yaml:"required"`
+}
+
+func (v *VarSpec) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err == nil {
+		v.Default = value
+		return nil
+	}
+
+	type variable VarSpec
+	return unmarshal((*variable)(v))
+}
+
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type workflow Config
+	if err := unmarshal((*workflow)(c)); err != nil {
+		return err
+	}
+
+	// Vars holds the default values used at run time; required variables
+	// without a default have to be given on the command line.
+	c.Vars = make(map[string]string, len(c.VarSpecs))
+	for name, spec := range c.VarSpecs {
+		if !spec.Required || spec.Default != "" {
+			c.Vars[name] = spec.Default
+		}
+	}
+	return nil
+}
+
+// assignedVars returns the names of the variables assigned in args, the
+// command line's NAME=value arguments.
+func assignedVars(args []string) map[string]bool {
+	names := make(map[string]bool)
+	for _, arg := range args {
+		if name, _, ok := strings.Cut(arg, "="); ok {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// missingVars returns the required variables that have no value.
+func missingVars(specs map[string]VarSpec, vars map[string]string) []string {
+	var missing []string
+	for name, spec := range specs {
+		if spec.Required && vars[name] == "" {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// printUsage describes how to run a workflow: its usage text, an example
+// command line and its variables, required ones first.
+func printUsage(w io.Writer, config Config, files []string) {
+	fmt.Fprintln(w, "Usage:")
+	for _, text := range []string{config.Usage, config.Vars["USAGE"]} {
+		if text = strings.TrimSpace(text); text != "" {
+			fmt.Fprintln(w, text)
+		}
+	}
+
+	names := make([]string, 0, len(config.VarSpecs))
+	width := 0
+	for name := range config.VarSpecs {
+		if name == "USAGE" {
+			continue
+		}
+		names = append(names, name)
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := config.VarSpecs[names[i]], config.VarSpecs[names[j]]
+		if a.Required != b.Required {
+			return a.Required
+		}
+		return names[i] < names[j]
+	})
+
+	var args []string
+	for _, file := range files {
+		args = append(args, "-w", file)
+	}
+	for _, name := range names {
+		spec := config.VarSpecs[name]
+		switch {
+		case spec.Required && spec.Default == "":
+			args = append(args, fmt.Sprintf("%s=<%s>", name, strings.ToLower(name)))
+		case spec.Required:
+			args = append(args, fmt.Sprintf("%s=%s", name, spec.Default))
+		default:
+			args = append(args, fmt.Sprintf("[%s=%s]", name, spec.Default))
+		}
+	}
+	fmt.Fprintf(w, "\n  rayder %s\n", strings.Join(args, " "))
+
+	if len(names) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nVariables:")
+	for _, name := range names {
+		spec := config.VarSpecs[name]
+		detail := "optional"
+		switch {
+		case spec.Required && spec.Default == "":
+			detail = "required"
+		case spec.Required:
+			detail = fmt.Sprintf("required, default: %s", spec.Default)
+		case spec.Default != "":
+			detail = fmt.Sprintf("default: %s", spec.Default)
+		}
+		if spec.Description != "" {
+			detail = spec.Description + " (" + detail + ")"
+		}
+		fmt.Fprintf(w, "  %-*s  %s\n", width, name, detail)
+	}
+}
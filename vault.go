@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fetchSecrets sets the variables of config's secrets that name a Vault
+// secret to its value, so credentials live in Vault rather than on the scan
+// box. Variables given in args are left alone. The values are only held in
+// memory and, as secrets, masked wherever they'd be shown.
+func fetchSecrets(config *Config, args []string) error {
+	given := assignedVars(args)
+	var vault *vaultClient
+	for _, secret := range config.Secrets {
+		if secret.Vault == "" || given[secret.Name] {
+			continue
+		}
+		if vault == nil {
+			var err error
+			if vault, err = newVaultClient(); err != nil {
+				return err
+			}
+		}
+		value, err := vault.get(secret.Vault, secret.Name)
+		if err != nil {
+			return fmt.Errorf("fetching %s from Vault: %w", secret.Name, err)
+		}
+		if config.Vars == nil {
+			config.Vars = make(map[string]string)
+		}
+		config.Vars[secret.Name] = value
+	}
+	return nil
+}
+
+// vaultClient reads KV v2 secrets from the Vault server configured in the
+// environment the way the vault CLI is: VAULT_ADDR, VAULT_TOKEN (or
+// ~/.vault-token), VAULT_NAMESPACE and VAULT_CACERT.
+type vaultClient struct {
+	addr      string
+	token     string
+	namespace string
+	client    *http.Client
+	secrets   map[string]map[string]interface{} // by API path, as each is read once
+}
+
+func newVaultClient() (*vaultClient, error) {
+	addr := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is not set")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if b, err := os.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+				token = strings.TrimSpace(string(b))
+			}
+		}
+	}
+	if token == "" {
+		return nil, fmt.Errorf("no Vault token, set VAULT_TOKEN or log in with vault login")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if file := os.Getenv("VAULT_CACERT"); file != "" {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in VAULT_CACERT %s", file)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &vaultClient{
+		addr:      addr,
+		token:     token,
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+		client:    &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		secrets:   make(map[string]map[string]interface{}),
+	}, nil
+}
+
+// get returns a field of the KV v2 secret ref, given as mount/path#field
+// like secret/recon#shodan. The field defaults to the variable's name. As
+// the vault CLI shows them, paths may leave out the data/ segment the API
+// puts after the mount.
+func (v *vaultClient) get(ref, name string) (string, error) {
+	path, field, _ := strings.Cut(ref, "#")
+	if field == "" {
+		field = name
+	}
+	mount, rest, ok := strings.Cut(strings.Trim(path, "/"), "/")
+	if !ok {
+		return "", fmt.Errorf("invalid secret %q, expected mount/path#field", ref)
+	}
+	if !strings.HasPrefix(rest, "data/") {
+		rest = "data/" + rest
+	}
+	apiPath := mount + "/" + rest
+
+	data, ok := v.secrets[apiPath]
+	if !ok {
+		var err error
+		if data, err = v.read(apiPath); err != nil {
+			return "", err
+		}
+		v.secrets[apiPath] = data
+	}
+	value, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("%s has no field %s", path, field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(value)
+	return string(b), err
+}
+
+// read returns the data of the latest version of the secret at apiPath.
+func (v *vaultClient) read(apiPath string) (map[string]interface{}, error) {
+	req, err := http.NewRequest("GET", v.addr+"/v1/"+apiPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+		Errors []string `json:"errors"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK {
+		if len(body.Errors) > 0 {
+			return nil, fmt.Errorf("%s: %s", resp.Status, strings.Join(body.Errors, "; "))
+		}
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	if body.Data.Data == nil {
+		return nil, fmt.Errorf("%s holds no data, is it a KV v2 secret?", apiPath)
+	}
+	return body.Data.Data, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// verifyOptions controls how fetched workflows are checked before they are
+// executed.
+type verifyOptions struct {
+	sha256  string
+	pubkey  string
+	sigTool string
+}
+
+func (v verifyOptions) enabled() bool {
+	return v.sha256 != "" || v.pubkey != ""
+}
+
+// verifyWorkflow checks the workflow stored at path, fetched from ref,
+// against the pinned checksum and/or signature.
+func verifyWorkflow(ref, path string, opts verifyOptions) error {
+	if opts.sha256 != "" {
+		if err := verifyChecksum(path, opts.sha256); err != nil {
+			return err
+		}
+	}
+
+	if opts.pubkey != "" {
+		if err := verifySignature(ref, path, opts.pubkey, opts.sigTool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func verifyChecksum(path, want string) error {
+	got, err := fileSHA256(path)
+	if err != nil {
+		return fmt.Errorf("computing checksum: %w", err)
+	}
+
+	want = strings.ToLower(strings.TrimPrefix(want, "sha256:"))
+	if got != want {
+		return fmt.Errorf("checksum mismatch: expected sha256 %s, got %s", want, got)
+	}
+	return nil
+}
+
+// verifySignature runs minisign or cosign against the detached signature
+// published next to the workflow (<workflow>.minisig or <workflow>.sig).
+func verifySignature(ref, path, pubkey, tool string) error {
+	if tool == "" {
+		tool = "minisign"
+	}
+
+	var ext string
+	switch tool {
+	case "minisign":
+		ext = ".minisig"
+	case "cosign":
+		ext = ".sig"
+	default:
+		return fmt.Errorf("unsupported signature tool %q, expected minisign or cosign", tool)
+	}
+
+	sig, err := fetchSignature(ref, path, ext)
+	if err != nil {
+		return fmt.Errorf("fetching signature: %w", err)
+	}
+
+	var cmd *exec.Cmd
+	if tool == "minisign" {
+		cmd = exec.Command("minisign", "-V", "-q", "-p", pubkey, "-m", path, "-x", sig)
+	} else {
+		cmd = exec.Command("cosign", "verify-blob", "--key", pubkey, "--signature", sig, path)
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("signature verification failed: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// fetchSignature returns the path of the signature for the workflow at path.
+// Workflows from git already have it next to them in the clone; for HTTP
+// workflows it is downloaded from the same URL with ext appended.
+func fetchSignature(ref, path, ext string) (string, error) {
+	sig := path + ext
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		return sig, downloadWorkflow(ref+ext, sig)
+	}
+	if _, err := os.Stat(sig); err != nil {
+		return "", err
+	}
+	return sig, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+)
+
+// commit and buildDate describe the build; release builds set them with
+// -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=...".
+// Otherwise they are taken from what the Go toolchain recorded, if anything.
+var (
+	commit    string
+	buildDate string
+)
+
+// releaseVersion matches the version of a tagged release.
+var releaseVersion = regexp.MustCompile(`^v\d+\.\d+\.\d+$`)
+
+func init() {
+	// go install module@version records the release it installed; local
+	// builds only get pseudo-versions, which say less than the commit.
+	if info, ok := debug.ReadBuildInfo(); ok && releaseVersion.MatchString(info.Main.Version) {
+		version = info.Main.Version
+	}
+}
+
+// buildInfo returns the version, commit and build date of the binary.
+func buildInfo() (ver, rev, date string) {
+	ver, rev, date = version, commit, buildDate
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ver, rev, date
+	}
+	settings := make(map[string]string)
+	for _, setting := range info.Settings {
+		settings[setting.Key] = setting.Value
+	}
+	if rev == "" && settings["vcs.revision"] != "" {
+		rev = settings["vcs.revision"]
+		if settings["vcs.modified"] == "true" {
+			rev += "-dirty"
+		}
+	}
+	if date == "" {
+		date = settings["vcs.time"]
+	}
+	return ver, rev, date
+}
+
+// printVersion writes what a bug report needs to know about the binary.
+func printVersion(w io.Writer) {
+	ver, rev, date := buildInfo()
+	if rev == "" {
+		rev = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	fmt.Fprintf(w, "rayder %s\n", ver)
+	fmt.Fprintf(w, "  commit:     %s\n", rev)
+	fmt.Fprintf(w, "  built:      %s\n", date)
+	fmt.Fprintf(w, "  go version: %s\n", runtime.Version())
+	fmt.Fprintf(w, "  platform:   %s/%s\n", runtime.GOOS, runtime.GOARCH)
+}
+
+func runVersionCommand(args []string) int {
+	printVersion(os.Stdout)
+	return 0
+}
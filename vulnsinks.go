@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// findingHostName returns the host a finding is about, its IP when it is known.
+// nuclei reports hosts as URLs, so those are reduced to their host name.
+func findingHostName(f Finding) string {
+	host := firstNonEmpty(f.IP, f.Host)
+	if u, err := url.Parse(host); err == nil && u.Scheme != "" && u.Host != "" {
+		return u.Hostname()
+	}
+	return host
+}
+
+// describeFinding is the description of a finding in a vulnerability
+// management system.
+func describeFinding(f Finding, run RunRecord) string {
+	lines := []string{f.String()}
+	if f.ID != "" {
+		lines = append(lines, "Template: "+f.ID)
+	}
+	lines = append(lines, fmt.Sprintf("Found by %s (module %s) in rayder run %s", f.Source, f.Module, run.ID))
+	return strings.Join(lines, "\n")
+}
+
+// shipDefectDojo imports the vulns of run into a DefectDojo engagement,
+// given by ID or, with product, by name, created if it doesn't exist yet.
+// They are uploaded in DefectDojo's Generic Findings Import format, as a
+// test named after the run.
+func (s Sink) shipDefectDojo(run RunRecord, vars map[string]string) error {
+	type endpoint struct {
+		Host string `json:"host"`
+		Port int    `json:"port,omitempty"`
+	}
+	type finding struct {
+		Title       string        `json:"title"`
+		Description string        `json:"description"`
+		Severity    string        `json:"severity"`
+		Date        string        `json:"date"`
+		UniqueID    string        `json:"unique_id_from_tool,omitempty"`
+		VulnID      string        `json:"vuln_id_from_tool,omitempty"`
+		Endpoints   []interface{} `json:"endpoints,omitempty"`
+	}
+	var findings []finding
+	for _, f := range run.Findings {
+		if f.Kind != findingVuln {
+			continue
+		}
+		severity := "Info"
+		switch s := strings.ToLower(f.Severity); s {
+		case "critical", "high", "medium", "low":
+			severity = strings.ToUpper(s[:1]) + s[1:]
+		}
+		item := finding{
+			Title:       f.Name,
+			Description: describeFinding(f, run),
+			Severity:    severity,
+			Date:        run.Finished.Format("2006-01-02"),
+			UniqueID:    findingKey(f),
+			VulnID:      f.ID,
+		}
+		if f.URL != "" {
+			item.Endpoints = append(item.Endpoints, f.URL)
+		} else if host := findingHostName(f); host != "" {
+			item.Endpoints = append(item.Endpoints, endpoint{Host: host, Port: f.Port})
+		}
+		findings = append(findings, item)
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+	report, err := json.Marshal(map[string]interface{}{"findings": findings})
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	fields := map[string]string{
+		"scan_type":  "Generic Findings Import",
+		"scan_date":  run.Finished.Format("2006-01-02"),
+		"test_title": "rayder " + run.ID,
+		"active":     "true",
+		"verified":   "false",
+	}
+	if s.Product != "" {
+		fields["product_name"] = replacePlaceholders(s.Product, vars)
+		fields["engagement_name"] = replacePlaceholders(s.Engagement, vars)
+		fields["auto_create_context"] = "true"
+	} else {
+		fields["engagement"] = replacePlaceholders(s.Engagement, vars)
+	}
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	file, err := form.CreateFormFile("file", "rayder-"+run.ID+".json")
+	if err != nil {
+		return err
+	}
+	file.Write(report)
+	if err := form.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(replacePlaceholders(s.URL, vars), "/")+"/api/v2/import-scan/", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Token "+replacePlaceholders(s.APIKey, vars))
+	return doSinkRequest(&http.Client{Timeout: 60 * time.Second}, req)
+}
+
+// shipFaraday creates the hosts, services and vulns of run in a Faraday
+// workspace with its bulk API.
+func (s Sink) shipFaraday(run RunRecord, vars map[string]string) error {
+	type vuln struct {
+		Name     string `json:"name"`
+		Desc     string `json:"desc"`
+		Severity string `json:"severity"`
+		Type     string `json:"type"`
+		Status   string `json:"status"`
+	}
+	type service struct {
+		Name            string `json:"name"`
+		Port            int    `json:"port"`
+		Protocol        string `json:"protocol"`
+		Status          string `json:"status"`
+		Version         string `json:"version"`
+		Description     string `json:"description"`
+		Vulnerabilities []vuln `json:"vulnerabilities"`
+	}
+	type host struct {
+		IP              string     `json:"ip"`
+		Hostnames       []string   `json:"hostnames"`
+		Description     string     `json:"description"`
+		Services        []*service `json:"services"`
+		Vulnerabilities []vuln     `json:"vulnerabilities"`
+	}
+
+	var hosts []*host
+	byName := make(map[string]*host)
+	hostFor := func(f Finding) *host {
+		name := findingHostName(f)
+		h, ok := byName[name]
+		if !ok {
+			h = &host{IP: name, Hostnames: []string{}, Description: "Found by rayder", Services: []*service{}, Vulnerabilities: []vuln{}}
+			byName[name] = h
+			hosts = append(hosts, h)
+		}
+		if f.Host != "" && f.Host != name && !strings.Contains(f.Host, "://") && !containsString(h.Hostnames, f.Host) {
+			h.Hostnames = append(h.Hostnames, f.Host)
+		}
+		return h
+	}
+	serviceFor := func(h *host, port int, protocol string) *service {
+		for _, svc := range h.Services {
+			if svc.Port == port && svc.Protocol == protocol {
+				return svc
+			}
+		}
+		svc := &service{Name: "unknown", Port: port, Protocol: protocol, Status: "open", Vulnerabilities: []vuln{}}
+		h.Services = append(h.Services, svc)
+		return svc
+	}
+
+	for _, f := range run.Findings {
+		if findingHostName(f) == "" {
+			continue
+		}
+		h := hostFor(f)
+		switch f.Kind {
+		case findingPort:
+			svc := serviceFor(h, f.Port, firstNonEmpty(f.Protocol, "tcp"))
+			if f.Service != "" {
+				svc.Name = f.Service
+			}
+			svc.Version = f.Product
+		case findingVuln:
+			severity := strings.ToLower(f.Severity)
+			switch severity {
+			case "critical", "high", "medium", "low":
+			case "info":
+				severity = "informational"
+			default:
+				severity = "unclassified"
+			}
+			v := vuln{Name: f.Name, Desc: describeFinding(f, run), Severity: severity, Type: "Vulnerability", Status: "open"}
+			if f.Port > 0 {
+				svc := serviceFor(h, f.Port, "tcp")
+				svc.Vulnerabilities = append(svc.Vulnerabilities, v)
+			} else {
+				h.Vulnerabilities = append(h.Vulnerabilities, v)
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"hosts": hosts,
+		"command": map[string]interface{}{
+			"tool":          "rayder",
+			"command":       "rayder " + strings.Join(run.Workflows, " "),
+			"params":        run.ID,
+			"user":          "rayder",
+			"hostname":      "",
+			"start_date":    run.Started.Format(time.RFC3339),
+			"end_date":      run.Finished.Format(time.RFC3339),
+			"import_source": "shell",
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	base := strings.TrimSuffix(replacePlaceholders(s.URL, vars), "/")
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Timeout: 60 * time.Second, Jar: jar}
+	if s.APIKey == "" && s.Username != "" {
+		// Without an API token Faraday authenticates a session.
+		login, _ := json.Marshal(map[string]string{
+			"email":    replacePlaceholders(s.Username, vars),
+			"password": replacePlaceholders(s.Password, vars),
+		})
+		req, err := http.NewRequest("POST", base+"/_api/login", bytes.NewReader(login))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if err := doSinkRequest(client, req); err != nil {
+			return fmt.Errorf("logging in: %w", err)
+		}
+	}
+
+	req, err := http.NewRequest("POST", base+"/_api/v3/ws/"+url.PathEscape(replacePlaceholders(s.Workspace, vars))+"/bulk_create", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.APIKey != "" {
+		req.Header.Set("Authorization", "Token "+replacePlaceholders(s.APIKey, vars))
+	}
+	return doSinkRequest(client, req)
+}
+
+// doSinkRequest sends req and turns a response other than a success into
+// an error holding what the server said.
+func doSinkRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// WaitFor describes conditions that must all hold before a module proceeds:
+// a TCP port accepting connections, an HTTP URL answering 200 and/or a file
+// existing.
+type WaitFor struct {
+	TCP     string `yaml:"tcp"`
+	HTTP    string `yaml:"http"`
+	File    string `yaml:"file"`
+	Timeout string `yaml:"timeout"`
+}
+
+// String describes the conditions for log lines.
+func (w *WaitFor) String() string {
+	var parts []string
+	if w.TCP != "" {
+		parts = append(parts, "tcp "+w.TCP)
+	}
+	if w.HTTP != "" {
+		parts = append(parts, "http "+w.HTTP)
+	}
+	if w.File != "" {
+		parts = append(parts, "file "+w.File)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// resolve returns a copy of w with placeholders substituted.
+func (w *WaitFor) resolve(vars map[string]string) *WaitFor {
+	return &WaitFor{
+		TCP:     replacePlaceholders(w.TCP, vars),
+		HTTP:    replacePlaceholders(w.HTTP, vars),
+		File:    replacePlaceholders(w.File, vars),
+		Timeout: replacePlaceholders(w.Timeout, vars),
+	}
+}
+
+func (w *WaitFor) timeout() (time.Duration, error) {
+	if w.Timeout == "" {
+		return defaultReadyTimeout, nil
+	}
+	return parseDuration(w.Timeout)
+}
+
+// satisfied reports whether all conditions currently hold.
+func (w *WaitFor) satisfied() bool {
+	if w.TCP != "" {
+		conn, err := net.DialTimeout("tcp", w.TCP, time.Second)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+	}
+
+	if w.HTTP != "" {
+		client := http.Client{Timeout: 5 * time.Second}
+		resp, err := client.Get(w.HTTP)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return false
+		}
+	}
+
+	if w.File != "" {
+		if _, err := os.Stat(w.File); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// waitForConditions blocks until w is satisfied or its timeout passes.
+func waitForConditions(w *WaitFor, vars map[string]string) error {
+	w = w.resolve(vars)
+	timeout, err := w.timeout()
+	if err != nil {
+		return err
+	}
+	if err := waitUntil(w.satisfied, timeout, nil); err != nil {
+		return fmt.Errorf("waiting for %s: %w", w, err)
+	}
+	return nil
+}
+
+// waitUntil polls check once a second until it returns true, the timeout
+// passes, the run is cancelled or one of the given service processes exits.
+func waitUntil(check func() bool, timeout time.Duration, services []*service) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if check() {
+			return nil
+		}
+
+		for _, svc := range services {
+			select {
+			case <-svc.done:
+				return fmt.Errorf("service exited before becoming ready")
+			default:
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("condition not met after %s", timeout)
+		}
+		if !control.sleep(time.Second) {
+			return errCancelled
+		}
+	}
+}
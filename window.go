@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Window restricts when modules may start, e.g. "22:00-06:00" for testing
+// outside business hours. Outside the window a module waits for it to open,
+// or is skipped when Outside is "skip".
+type Window struct {
+	Hours    string `yaml:"hours"`
+	Timezone string `yaml:"timezone"`
+	Outside  string `yaml:"outside"`
+
+	start, end int // minutes after midnight
+	loc        *time.Location
+}
+
+func (w *Window) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var hours string
+	if err := unmarshal(&hours); err == nil {
+		w.Hours = hours
+	} else {
+		type allowedWindow Window
+		if err := unmarshal((*allowedWindow)(w)); err != nil {
+			return err
+		}
+	}
+
+	var startH, startM, endH, endM int
+	if _, err := fmt.Sscanf(w.Hours, "%d:%d-%d:%d", &startH, &startM, &endH, &endM); err != nil ||
+		startH > 23 || endH > 24 || startM > 59 || endM > 59 {
+		return fmt.Errorf("invalid allowed_window %q, expected HH:MM-HH:MM", w.Hours)
+	}
+	w.start = startH*60 + startM
+	w.end = endH*60 + endM
+
+	w.loc = time.Local
+	if w.Timezone != "" {
+		loc, err := time.LoadLocation(w.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid allowed_window timezone: %w", err)
+		}
+		w.loc = loc
+	}
+
+	if w.Outside != "" && w.Outside != "wait" && w.Outside != "skip" {
+		return fmt.Errorf("invalid allowed_window outside %q, expected wait or skip", w.Outside)
+	}
+	return nil
+}
+
+func (w *Window) String() string {
+	if w.Timezone != "" {
+		return w.Hours + " " + w.Timezone
+	}
+	return w.Hours
+}
+
+// contains reports whether t falls inside the window. Windows whose end is
+// before their start span midnight.
+func (w *Window) contains(t time.Time) bool {
+	t = t.In(w.loc)
+	m := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return m >= w.start && m < w.end
+	}
+	return m >= w.start || m < w.end
+}
+
+// untilOpen returns how long after t the window next opens.
+func (w *Window) untilOpen(t time.Time) time.Duration {
+	t = t.In(w.loc)
+	open := time.Date(t.Year(), t.Month(), t.Day(), w.start/60, w.start%60, 0, 0, w.loc)
+	if !open.After(t) {
+		open = open.AddDate(0, 0, 1)
+	}
+	return open.Sub(t)
+}